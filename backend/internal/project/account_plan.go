@@ -0,0 +1,452 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Account planning compares a list of wanted accounts with the full account
+// lists of AD, print and VPN (list_accounts) and produces the items that would
+// bring the systems in line: create the missing accounts, update drifted
+// attributes and, where the caller asks for it, disable or delete accounts
+// nobody wants. The HR roster and the desired-state file both plan through
+// here; AccountPlanStep turns an item into an ordinary project action.
+
+// AccountPlanSystems lists the systems accounts are planned on, in the order
+// the items are listed.
+var AccountPlanSystems = []string{"ad", "print", "vpn"}
+
+const (
+	AccountCreate  = "create"
+	AccountUpdate  = "update"
+	AccountDisable = "disable"
+	AccountDelete  = "delete"
+	AccountKeep    = "keep"
+)
+
+// DesiredAccount is one wanted account. Empty attributes are not compared;
+// Systems limits the account to some systems, all of them when empty.
+type DesiredAccount struct {
+	Row        int      `json:"row,omitempty"`
+	Username   string   `json:"username"`
+	Name       string   `json:"name,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	Department string   `json:"department,omitempty"`
+	Status     string   `json:"status,omitempty"`
+	Systems    []string `json:"systems,omitempty"`
+}
+
+func (d DesiredAccount) wants(system string) bool {
+	if len(d.Systems) == 0 {
+		return true
+	}
+	for _, one := range d.Systems {
+		if one == system {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountChange is one attribute of an account that differs from the wanted
+// value. Supported is false where the system has no way to change it.
+type AccountChange struct {
+	Field     string `json:"field"`
+	Title     string `json:"title"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Supported bool   `json:"supported"`
+}
+
+// AccountPlanItem is one planned change. Username, Name, Email and Department
+// are the wanted values, or the account's own for an unwanted account; Row is
+// the source row, 0 for an unwanted account.
+type AccountPlanItem struct {
+	Key        string          `json:"key"`
+	System     string          `json:"system"`
+	Op         string          `json:"op"`
+	OpText     string          `json:"op_text"`
+	Row        int             `json:"row"`
+	Username   string          `json:"username"`
+	Name       string          `json:"name"`
+	Email      string          `json:"email"`
+	Department string          `json:"department"`
+	MatchedBy  string          `json:"matched_by,omitempty"`
+	Changes    []AccountChange `json:"changes"`
+	Actionable bool            `json:"actionable"`
+	Protected  bool            `json:"protected,omitempty"`
+	Note       string          `json:"note,omitempty"`
+}
+
+// DefaultProtectedAccounts is used when PROTECTED_ACCOUNTS is not set: the
+// built-in Windows and print accounts and the usual service account prefixes.
+const DefaultProtectedAccounts = "administrator,admin,guest,krbtgt,defaultaccount,wdagutilityaccount,svc-*,svc_*"
+
+// ProtectedAccounts are username patterns (path.Match syntax, any case) that
+// a plan never disables or deletes.
+type ProtectedAccounts []string
+
+// ParseProtectedAccounts reads a comma-separated pattern list.
+func ParseProtectedAccounts(text string) (ProtectedAccounts, error) {
+	out := make(ProtectedAccounts, 0)
+	for _, one := range strings.Split(text, ",") {
+		one = strings.ToLower(strings.TrimSpace(one))
+		if one == "" {
+			continue
+		}
+		if _, err := path.Match(one, ""); err != nil {
+			return nil, fmt.Errorf("无效的账号模式：%s", one)
+		}
+		out = append(out, one)
+	}
+	return out, nil
+}
+
+// Match reports whether username is protected.
+func (p ProtectedAccounts) Match(username string) bool {
+	name := accountKey(username)
+	for _, pattern := range p {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountOpTitle is the display name of a plan operation.
+func AccountOpTitle(op string) string {
+	switch op {
+	case AccountCreate:
+		return "创建"
+	case AccountUpdate:
+		return "更新"
+	default:
+		return OffboardPolicyTitle(op)
+	}
+}
+
+func accountKey(v interface{}) string {
+	return strings.ToLower(strings.TrimSpace(toString(v)))
+}
+
+// accountFieldSupported reports whether field can be changed on system: AD
+// can only rename (the AD API has no way to move an account to another OU or
+// set its department), print can change everything, and the VPN device can
+// only enable or disable.
+func accountFieldSupported(system, field string) bool {
+	switch system {
+	case "ad":
+		return field == "name"
+	case "print":
+		return true
+	case "vpn":
+		return field == "status"
+	default:
+		return false
+	}
+}
+
+// accountSameDept treats a print department path ending in the wanted
+// department as the same department.
+func accountSameDept(system, want, actual string) bool {
+	want, actual = strings.TrimSpace(want), strings.TrimSpace(actual)
+	if strings.EqualFold(want, actual) {
+		return true
+	}
+	return system == "print" && strings.HasSuffix(actual, `\`+want)
+}
+
+// PlanAccounts compares desired with the account lists of systems. unwanted
+// maps a system to what happens to accounts no desired entry claims there:
+// disable, delete or keep (listed, not actionable); a system missing from
+// unwanted leaves them out of the plan. Unwanted accounts matching protected
+// are kept and marked Protected. Items come back ordered by system,
+// operation and username.
+func PlanAccounts(desired []DesiredAccount, systems map[string][]PersonAccount, unwanted map[string]string, protected ProtectedAccounts) []AccountPlanItem {
+	items := make([]AccountPlanItem, 0)
+	for _, system := range AccountPlanSystems {
+		accounts, ok := systems[system]
+		if !ok {
+			continue
+		}
+		byUser := make(map[string]int, len(accounts))
+		byMail := make(map[string]int, len(accounts))
+		for i, one := range accounts {
+			byUser[accountKey(one.Username)] = i
+			if mail := accountKey(one.Email); mail != "" {
+				if _, dup := byMail[mail]; !dup {
+					byMail[mail] = i
+				}
+			}
+		}
+		claimed := make(map[int]bool, len(desired))
+		for _, want := range desired {
+			if !want.wants(system) {
+				continue
+			}
+			item := AccountPlanItem{
+				System:     system,
+				Row:        want.Row,
+				Username:   strings.TrimSpace(want.Username),
+				Name:       strings.TrimSpace(want.Name),
+				Email:      strings.TrimSpace(want.Email),
+				Department: strings.TrimSpace(want.Department),
+				Changes:    []AccountChange{},
+			}
+			idx, found := byUser[accountKey(item.Username)]
+			item.MatchedBy = "username"
+			if !found && item.Email != "" {
+				idx, found = byMail[accountKey(item.Email)]
+				item.MatchedBy = "email"
+			}
+			if found && claimed[idx] {
+				found = false
+			}
+			if !found {
+				item.Op = AccountCreate
+				item.MatchedBy = ""
+				if want.Status == "disabled" {
+					// Nothing to create for an account that is meant to be off.
+					continue
+				}
+				if err := OnboardCheck(map[string]interface{}{"username": item.Username, "name": item.Name, "email": item.Email}); err != nil {
+					item.Note = "无法创建：" + err.Error()
+				} else {
+					item.Actionable = true
+				}
+				items = append(items, item)
+				continue
+			}
+			claimed[idx] = true
+			account := accounts[idx]
+			if item.MatchedBy == "email" {
+				item.Note = fmt.Sprintf("按邮箱匹配到账号 %s", account.Username)
+			}
+			item.Changes = accountChanges(system, want, account)
+			if len(item.Changes) == 0 {
+				continue
+			}
+			item.Op = AccountUpdate
+			unsupported := make([]string, 0)
+			for _, change := range item.Changes {
+				if change.Supported {
+					item.Actionable = true
+				} else {
+					unsupported = append(unsupported, change.Title)
+				}
+			}
+			if len(unsupported) > 0 {
+				title := WorkflowSystemTitle(system)
+				note := fmt.Sprintf("%s不支持修改%s，仅报告，需在%s中手动处理", title, strings.Join(unsupported, "、"), title)
+				item.Note = strings.TrimPrefix(item.Note+"；"+note, "；")
+			}
+			// An account matched by email keeps its own username for the update.
+			item.Username = account.Username
+			items = append(items, item)
+		}
+
+		action, ok := unwanted[system]
+		if !ok {
+			continue
+		}
+		for i, one := range accounts {
+			if claimed[i] {
+				continue
+			}
+			if action == AccountDisable && one.State == "disabled" {
+				continue
+			}
+			item := AccountPlanItem{
+				System:     system,
+				Op:         action,
+				Username:   one.Username,
+				Name:       one.Name,
+				Email:      one.Email,
+				Department: one.Department,
+				Changes:    []AccountChange{},
+				Actionable: OffboardPolicyAllowed(system, action) && action != AccountKeep,
+			}
+			if item.Actionable && protected.Match(one.Username) {
+				item.Actionable = false
+				item.Protected = true
+			}
+			if !item.Actionable {
+				item.Op = AccountKeep
+			}
+			items = append(items, item)
+		}
+	}
+	for i := range items {
+		items[i].OpText = AccountOpTitle(items[i].Op)
+		items[i].Key = items[i].System + ":" + items[i].Op + ":" + items[i].Username
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].System != items[j].System {
+			return offboardOrder(items[i].System) < offboardOrder(items[j].System)
+		}
+		if items[i].Op != items[j].Op {
+			return accountOpOrder(items[i].Op) < accountOpOrder(items[j].Op)
+		}
+		return strings.ToLower(items[i].Username) < strings.ToLower(items[j].Username)
+	})
+	return items
+}
+
+func accountOpOrder(op string) int {
+	for i, one := range []string{AccountCreate, AccountUpdate, AccountDisable, AccountDelete, AccountKeep} {
+		if one == op {
+			return i
+		}
+	}
+	return 5
+}
+
+// accountChanges lists the attributes of account that differ from want. The
+// VPN group is not a department, so VPN departments are not compared; a
+// status is compared only when both sides have one.
+func accountChanges(system string, want DesiredAccount, account PersonAccount) []AccountChange {
+	changes := make([]AccountChange, 0)
+	add := func(field, title, from, to string) {
+		changes = append(changes, AccountChange{Field: field, Title: title, From: from, To: to, Supported: accountFieldSupported(system, field)})
+	}
+	name, email, dept := strings.TrimSpace(want.Name), strings.TrimSpace(want.Email), strings.TrimSpace(want.Department)
+	if name != "" && strings.TrimSpace(account.Name) != name {
+		add("name", "姓名", account.Name, name)
+	}
+	if email != "" && !strings.EqualFold(strings.TrimSpace(account.Email), email) {
+		add("email", "邮箱", account.Email, email)
+	}
+	if system != "vpn" && dept != "" && !accountSameDept(system, dept, account.Department) {
+		add("department", "部门", account.Department, dept)
+	}
+	if want.Status != "" && account.State != "" && want.Status != account.State {
+		add("status", "状态", reconcileStateTitle(account.State), reconcileStateTitle(want.Status))
+	}
+	return changes
+}
+
+// AccountPlanStep returns the project action and parameters that carry out a
+// plan item. For a create it also returns the new password.
+func AccountPlanStep(item AccountPlanItem) (string, map[string]interface{}, string, error) {
+	username := strings.TrimSpace(item.Username)
+	if username == "" {
+		return "", nil, "", errors.New("账号不能为空")
+	}
+	switch item.Op {
+	case AccountCreate:
+		person := map[string]interface{}{
+			"username":   username,
+			"name":       item.Name,
+			"email":      item.Email,
+			"department": item.Department,
+		}
+		if err := OnboardCheck(person); err != nil {
+			return "", nil, "", err
+		}
+		password := OnboardPasswords([]string{item.System}, randomPassword())[item.System]
+		return "add_user", OnboardParams(item.System, person, password), password, nil
+	case AccountDisable, AccountDelete:
+		if !OffboardPolicyAllowed(item.System, item.Op) {
+			return "", nil, "", fmt.Errorf("%s不支持%s", WorkflowSystemTitle(item.System), AccountOpTitle(item.Op))
+		}
+		name, params := OffboardStep(item.System, item.Op, username)
+		return name, params, "", nil
+	case AccountUpdate:
+		return accountUpdateStep(item.System, username, item.Changes)
+	default:
+		return "", nil, "", fmt.Errorf("该计划项无需执行：%s", AccountOpTitle(item.Op))
+	}
+}
+
+func accountUpdateStep(system, username string, changes []AccountChange) (string, map[string]interface{}, string, error) {
+	to := map[string]string{}
+	for _, change := range changes {
+		if accountFieldSupported(system, change.Field) && strings.TrimSpace(change.To) != "" {
+			to[change.Field] = strings.TrimSpace(change.To)
+		}
+	}
+	if len(to) == 0 {
+		return "", nil, "", fmt.Errorf("%s不支持该更新", WorkflowSystemTitle(system))
+	}
+	status := ""
+	if v, ok := to["status"]; ok {
+		if status = printNormalizeStatus(v); status == "" {
+			return "", nil, "", errors.New("状态参数不正确")
+		}
+	}
+	switch system {
+	case "ad":
+		sn, given := onboardSplitName(to["name"])
+		return "modify_name", map[string]interface{}{"name": username, "cn": to["name"], "sn": sn, "given_name": given}, "", nil
+	case "print":
+		params := map[string]interface{}{"search_key": "username", "search_content": username}
+		if v, ok := to["name"]; ok {
+			params["fullname"] = v
+		}
+		if v, ok := to["email"]; ok {
+			if !isValidEmail(v) {
+				return "", nil, "", errors.New("邮箱格式不正确")
+			}
+			params["email"] = v
+		}
+		if v, ok := to["department"]; ok {
+			params["section"] = v
+		}
+		if status != "" {
+			params["status"] = status
+		}
+		return "modify_user", params, "", nil
+	case "vpn":
+		return "modify_status", map[string]interface{}{"vpn_user": username, "status": status, "kick_online": status == "disabled"}, "", nil
+	default:
+		return "", nil, "", fmt.Errorf("%s不支持该更新", WorkflowSystemTitle(system))
+	}
+}
+
+// AccountChangesText describes the changes of an update item.
+func AccountChangesText(changes []AccountChange) string {
+	parts := make([]string, 0, len(changes))
+	for _, change := range changes {
+		from := change.From
+		if from == "" {
+			from = "空"
+		}
+		parts = append(parts, fmt.Sprintf("%s：%s → %s", change.Title, from, change.To))
+	}
+	return strings.Join(parts, "；")
+}
+
+// AccountPlanReport saves a computed plan under projectType.
+func AccountPlanReport(projectType string, items []AccountPlanItem) (string, error) {
+	rows := make([][]interface{}, 0, len(items))
+	for _, one := range items {
+		row := ""
+		if one.Row > 0 {
+			row = fmt.Sprint(one.Row)
+		}
+		actionable := "仅报告"
+		if one.Actionable {
+			actionable = "可执行"
+		}
+		rows = append(rows, []interface{}{
+			WorkflowSystemTitle(one.System), one.OpText, row, one.Username, one.Name, one.Email, one.Department,
+			AccountChangesText(one.Changes), actionable, one.Note,
+		})
+	}
+	return writeExportSheet(projectType, projectType+"_plan", []string{"系统", "操作", "行号", "账号", "姓名", "邮箱", "部门", "变更内容", "是否可执行", "说明"}, rows)
+}
+
+// AccountApplyReport saves the outcome of applying plan items under
+// projectType.
+func AccountApplyReport(projectType string, items []map[string]interface{}) (string, error) {
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []interface{}{
+			WorkflowSystemTitle(toString(item["system"])), item["op_text"], item["username"], item["summary"],
+		})
+	}
+	return writeExportSheet(projectType, projectType+"_apply", []string{"系统", "操作", "账号", "结果"}, rows)
+}
@@ -0,0 +1,60 @@
+package project
+
+import "testing"
+
+func TestParseProtectedAccounts(t *testing.T) {
+	protected, err := ParseProtectedAccounts(" Administrator , svc-*,,krbtgt ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"administrator": true,
+		"ADMINISTRATOR": true,
+		"svc-backup":    true,
+		"krbtgt":        true,
+		"zhangsan":      false,
+		"svc_backup":    false,
+	} {
+		if got := protected.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err = ParseProtectedAccounts("admin,[bad"); err == nil {
+		t.Fatal("bad pattern accepted")
+	}
+}
+
+func TestRosterPlanKeepsProtectedAccounts(t *testing.T) {
+	protected, _ := ParseProtectedAccounts(DefaultProtectedAccounts)
+	roster := []map[string]interface{}{
+		{"__row": 2, "username": "zhangsan", "name": "张三", "email": "zhangsan@example.com", "department": "研发部"},
+	}
+	systems := map[string][]PersonAccount{
+		"ad": {
+			{Username: "zhangsan", Name: "张三", Email: "zhangsan@example.com", Department: "销售部"},
+			{Username: "Administrator"},
+			{Username: "krbtgt", State: "disabled"},
+			{Username: "svc-backup"},
+			{Username: "leaver"},
+		},
+	}
+	items := RosterPlan(roster, systems, map[string]string{"ad": AccountDelete}, protected)
+	byUser := map[string]AccountPlanItem{}
+	for _, one := range items {
+		byUser[one.Username] = one
+	}
+	for _, name := range []string{"Administrator", "krbtgt", "svc-backup"} {
+		one := byUser[name]
+		if one.Op != AccountKeep || one.Actionable || !one.Protected {
+			t.Errorf("%s = %+v, want a protected keep", name, one)
+		}
+	}
+	if one := byUser["leaver"]; one.Op != AccountDelete || !one.Actionable || one.Protected {
+		t.Errorf("leaver = %+v, want an actionable delete", one)
+	}
+	// AD cannot move an account to another OU, so the department change is
+	// reported only.
+	if one := byUser["zhangsan"]; one.Op != AccountUpdate || one.Actionable || len(one.Changes) != 1 || one.Changes[0].Supported {
+		t.Errorf("zhangsan = %+v, want a report-only department change", one)
+	}
+}
@@ -0,0 +1,376 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Account reconciliation compares the full user lists of print and VPN with
+// AD, which is the record of who works here. Accounts are joined to AD by
+// username, then by email. Each difference becomes a ReconcileIssue that
+// lists the remediation actions it allows; ReconcileFix turns a chosen action
+// into an ordinary project action.
+
+// ReconcileSystems are the systems compared with AD.
+var ReconcileSystems = []string{"print", "vpn"}
+
+const (
+	ReconcileOrphan         = "orphan"
+	ReconcileMissing        = "missing"
+	ReconcileNameMismatch   = "name_mismatch"
+	ReconcileEmailMismatch  = "email_mismatch"
+	ReconcileStatusMismatch = "status_mismatch"
+)
+
+// ReconcileIssue is one difference between a system and AD. Username is the
+// account on System, or the AD account for a missing one; AD holds the AD
+// side when the account was joined. A protected account's issues are only
+// reported and allow no action.
+type ReconcileIssue struct {
+	Key       string        `json:"key"`
+	System    string        `json:"system"`
+	Issue     string        `json:"issue"`
+	IssueText string        `json:"issue_text"`
+	Username  string        `json:"username"`
+	MatchedBy string        `json:"matched_by,omitempty"`
+	Value     string        `json:"value"`
+	ADValue   string        `json:"ad_value"`
+	AD        PersonAccount `json:"ad"`
+	Actions   []string      `json:"actions"`
+	Protected bool          `json:"protected,omitempty"`
+}
+
+// ReconcileActions lists the remediation actions issue allows on system.
+// The VPN device cannot rename a user or change its mail, so those
+// differences are report-only there.
+func ReconcileActions(system, issue string) []string {
+	switch issue {
+	case ReconcileOrphan:
+		return []string{"disable", "delete"}
+	case ReconcileMissing:
+		return []string{"create"}
+	case ReconcileNameMismatch:
+		if system == "print" {
+			return []string{"sync_name"}
+		}
+	case ReconcileEmailMismatch:
+		if system == "print" {
+			return []string{"sync_email"}
+		}
+	case ReconcileStatusMismatch:
+		return []string{"sync_status"}
+	}
+	return []string{}
+}
+
+// ReconcileActionTitle is the display name of a remediation action.
+func ReconcileActionTitle(action string) string {
+	switch action {
+	case "disable":
+		return "禁用账号"
+	case "delete":
+		return "删除账号"
+	case "create":
+		return "按AD创建账号"
+	case "sync_name":
+		return "姓名同步为AD"
+	case "sync_email":
+		return "邮箱同步为AD"
+	case "sync_status":
+		return "状态同步为AD"
+	default:
+		return action
+	}
+}
+
+func reconcileStateTitle(state string) string {
+	switch state {
+	case "enabled":
+		return "启用"
+	case "disabled":
+		return "禁用"
+	default:
+		return "未知"
+	}
+}
+
+// ReconcileAccounts compares the accounts of each system with AD. systems
+// maps a system to its full account list; issues come back ordered by
+// system, then username. Disabled AD accounts are not missing anywhere, and
+// the issues of protected accounts are report-only.
+func ReconcileAccounts(ad []PersonAccount, systems map[string][]PersonAccount, protected ProtectedAccounts) []ReconcileIssue {
+	byUser := make(map[string]PersonAccount, len(ad))
+	byMail := make(map[string]PersonAccount, len(ad))
+	for _, one := range ad {
+		byUser[strings.ToLower(strings.TrimSpace(one.Username))] = one
+		if mail := strings.ToLower(strings.TrimSpace(one.Email)); mail != "" {
+			byMail[mail] = one
+		}
+	}
+
+	issues := make([]ReconcileIssue, 0)
+	add := func(system, issue, text, username, matchedBy, value, adValue string, adAcct PersonAccount) {
+		one := ReconcileIssue{
+			Key:       system + ":" + issue + ":" + username,
+			System:    system,
+			Issue:     issue,
+			IssueText: text,
+			Username:  username,
+			MatchedBy: matchedBy,
+			Value:     value,
+			ADValue:   adValue,
+			AD:        adAcct,
+			Actions:   ReconcileActions(system, issue),
+		}
+		if protected.Match(username) {
+			one.IssueText += "（受保护账号，仅报告）"
+			one.Actions = []string{}
+			one.Protected = true
+		}
+		issues = append(issues, one)
+	}
+	for _, system := range ReconcileSystems {
+		accounts, ok := systems[system]
+		if !ok {
+			continue
+		}
+		title := WorkflowSystemTitle(system)
+		matched := map[string]bool{}
+		for _, one := range accounts {
+			adAcct, found := byUser[strings.ToLower(strings.TrimSpace(one.Username))]
+			matchedBy := "username"
+			if !found && strings.TrimSpace(one.Email) != "" {
+				adAcct, found = byMail[strings.ToLower(strings.TrimSpace(one.Email))]
+				matchedBy = "email"
+			}
+			if !found {
+				add(system, ReconcileOrphan, "AD中不存在该人员", one.Username, "", reconcileDescribe(one), "", PersonAccount{})
+				if one.State == "disabled" && !issues[len(issues)-1].Protected {
+					issues[len(issues)-1].Actions = []string{"delete"}
+				}
+				continue
+			}
+			matched[strings.ToLower(adAcct.Username)] = true
+			if one.Name != "" && adAcct.Name != "" && !strings.EqualFold(strings.TrimSpace(one.Name), strings.TrimSpace(adAcct.Name)) {
+				add(system, ReconcileNameMismatch, title+"姓名与AD不一致", one.Username, matchedBy, one.Name, adAcct.Name, adAcct)
+			}
+			if one.Email != "" && adAcct.Email != "" && !strings.EqualFold(strings.TrimSpace(one.Email), strings.TrimSpace(adAcct.Email)) {
+				add(system, ReconcileEmailMismatch, title+"邮箱与AD不一致", one.Username, matchedBy, one.Email, adAcct.Email, adAcct)
+			}
+			if one.State != "" && adAcct.State != "" && one.State != adAcct.State {
+				add(system, ReconcileStatusMismatch, title+"启用状态与AD不一致", one.Username, matchedBy, reconcileStateTitle(one.State), reconcileStateTitle(adAcct.State), adAcct)
+			}
+		}
+		for _, one := range ad {
+			if !matched[strings.ToLower(one.Username)] && one.State != "disabled" {
+				add(system, ReconcileMissing, title+"缺少该AD人员的账号", one.Username, "", "", reconcileDescribe(one), one)
+			}
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].System != issues[j].System {
+			return offboardOrder(issues[i].System) < offboardOrder(issues[j].System)
+		}
+		return strings.ToLower(issues[i].Username) < strings.ToLower(issues[j].Username)
+	})
+	return issues
+}
+
+func reconcileDescribe(one PersonAccount) string {
+	parts := make([]string, 0, 3)
+	for _, v := range []string{one.Name, one.Email, reconcileStateTitle(one.State)} {
+		if v != "" && v != "未知" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, "，")
+}
+
+// ReconcileFix returns the project action and parameters that apply
+// remediation action to issue. For "create" it also returns the password the
+// new account gets.
+func ReconcileFix(issue ReconcileIssue, action string) (string, map[string]interface{}, string, error) {
+	allowed := false
+	for _, one := range ReconcileActions(issue.System, issue.Issue) {
+		allowed = allowed || one == action
+	}
+	if !allowed {
+		return "", nil, "", fmt.Errorf("该差异不支持修复操作：%s", action)
+	}
+	username := strings.TrimSpace(issue.Username)
+	if username == "" {
+		return "", nil, "", errors.New("账号不能为空")
+	}
+	switch action {
+	case "disable":
+		name, params := OffboardStep(issue.System, OffboardDisable, username)
+		return name, params, "", nil
+	case "delete":
+		name, params := OffboardStep(issue.System, OffboardDelete, username)
+		return name, params, "", nil
+	case "create":
+		person := map[string]interface{}{
+			"username":   issue.AD.Username,
+			"name":       issue.AD.Name,
+			"email":      issue.AD.Email,
+			"department": issue.AD.Department,
+		}
+		if err := OnboardCheck(person); err != nil {
+			return "", nil, "", fmt.Errorf("AD信息不完整：%s", err.Error())
+		}
+		password := OnboardPasswords([]string{issue.System}, randomPassword())[issue.System]
+		return "add_user", OnboardParams(issue.System, person, password), password, nil
+	case "sync_name":
+		if strings.TrimSpace(issue.AD.Name) == "" {
+			return "", nil, "", errors.New("AD姓名为空")
+		}
+		return "modify_user", map[string]interface{}{"search_key": "username", "search_content": username, "fullname": issue.AD.Name}, "", nil
+	case "sync_email":
+		if !isValidEmail(strings.TrimSpace(issue.AD.Email)) {
+			return "", nil, "", errors.New("AD邮箱格式不正确")
+		}
+		return "modify_user", map[string]interface{}{"search_key": "username", "search_content": username, "email": issue.AD.Email}, "", nil
+	case "sync_status":
+		state := issue.AD.State
+		if state != "enabled" && state != "disabled" {
+			return "", nil, "", errors.New("AD启用状态未知")
+		}
+		if issue.System == "print" {
+			return "set_status", map[string]interface{}{"search_key": "username", "search_content": username, "status": state}, "", nil
+		}
+		return "modify_status", map[string]interface{}{"vpn_user": username, "status": state, "kick_online": state == "disabled"}, "", nil
+	default:
+		return "", nil, "", fmt.Errorf("该差异不支持修复操作：%s", action)
+	}
+}
+
+// ReconcileReport saves the issues of a reconciliation run.
+func ReconcileReport(issues []ReconcileIssue) (string, error) {
+	rows := make([][]interface{}, 0, len(issues))
+	for _, one := range issues {
+		actions := make([]string, 0, len(one.Actions))
+		for _, action := range one.Actions {
+			actions = append(actions, ReconcileActionTitle(action))
+		}
+		matchedBy := ""
+		switch one.MatchedBy {
+		case "username":
+			matchedBy = "用户名"
+		case "email":
+			matchedBy = "邮箱"
+		}
+		rows = append(rows, []interface{}{
+			WorkflowSystemTitle(one.System), one.IssueText, one.Username, matchedBy, one.Value, one.ADValue, strings.Join(actions, "、"),
+		})
+	}
+	return writeExportSheet("reconcile", "reconcile", []string{"系统", "差异", "账号", "匹配方式", "系统中的值", "AD中的值", "可用修复"}, rows)
+}
+
+// ReconcileFixReport saves the outcome of a remediation run.
+func ReconcileFixReport(items []map[string]interface{}) (string, error) {
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []interface{}{
+			WorkflowSystemTitle(toString(item["system"])), item["issue_text"], item["username"],
+			ReconcileActionTitle(toString(item["action"])), item["summary"],
+		})
+	}
+	return writeExportSheet("reconcile", "reconcile_fix", []string{"系统", "差异", "账号", "修复操作", "结果"}, rows)
+}
+
+// adListAccounts lists every AD user; the search endpoint returns all users
+// for an empty keyword. userAccountControl carries the disabled flag (0x2)
+// when the API includes it.
+func adListAccounts(client *http.Client, p map[string]interface{}) projectResult {
+	data, err := adSearchRaw(client, "")
+	if err != nil {
+		return projectResult{OK: false, Message: "读取AD用户失败", Error: err.Error()}
+	}
+	items := make([]PersonAccount, 0)
+	for _, one := range toSlice(data["message"]) {
+		m, ok := one.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		account := strings.TrimSpace(toString(m["sAMAccountName"]))
+		dn := strings.TrimSpace(toString(m["distinguishedName"]))
+		if account == "" || strings.HasSuffix(account, "$") || strings.Contains(strings.ToLower(dn), "cn=computers,") {
+			continue
+		}
+		state := ""
+		if _, ok := m["userAccountControl"]; ok {
+			state = "enabled"
+			if toInt(m["userAccountControl"])&0x2 != 0 {
+				state = "disabled"
+			}
+		}
+		items = append(items, PersonAccount{
+			System:     "ad",
+			Username:   account,
+			Name:       strings.TrimSpace(toString(m["displayName"])),
+			Email:      strings.TrimSpace(toString(m["mail"])),
+			State:      state,
+			Department: adDepartmentFromDN(dn),
+			Detail:     dn,
+		})
+	}
+	emitProgress(p, fmt.Sprintf("AD用户 %d 个", len(items)), len(items), len(items))
+	return projectResult{OK: true, Message: fmt.Sprintf("读取完成，共 %d 个", len(items)), Data: map[string]interface{}{"items": items, "total": len(items)}}
+}
+
+// adDepartmentFromDN returns the department OU of a user DN, the OU that
+// holds the "Users" OU accounts are created in.
+func adDepartmentFromDN(dn string) string {
+	for _, part := range strings.Split(dn, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "OU") && !strings.EqualFold(kv[1], "Users") {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+func printListAccounts(ctx *printCtx, p map[string]interface{}) projectResult {
+	items := make([]PersonAccount, 0)
+	_, err := printEachUser(ctx, "fullname", "", func(m map[string]interface{}) bool {
+		dept := printNormalizePathName(toString(m["dept.name"]))
+		items = append(items, PersonAccount{
+			System:     "print",
+			Username:   printFieldValue(m["name"]),
+			Name:       printFieldValue(m["fullname"]),
+			Email:      printFieldValue(m["email"]),
+			State:      printFieldValue(m["status"]),
+			Department: dept,
+			Detail:     dept,
+		})
+		return true
+	})
+	if err != nil {
+		return projectResult{OK: false, Message: "读取打印用户失败", Error: err.Error()}
+	}
+	emitProgress(p, fmt.Sprintf("打印用户 %d 个", len(items)), len(items), len(items))
+	return projectResult{OK: true, Message: fmt.Sprintf("读取完成，共 %d 个", len(items)), Data: map[string]interface{}{"items": items, "total": len(items)}}
+}
+
+func vpnListAccounts(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	users, out, err := vpnListAllUsers(ctx, p, "VPN")
+	if err != nil {
+		return projectResult{OK: false, Message: "读取VPN用户失败", Error: err.Error(), Data: map[string]interface{}{"output": truncate(out, 2000)}}
+	}
+	items := make([]PersonAccount, 0, len(users))
+	for _, one := range users {
+		items = append(items, PersonAccount{
+			System:   "vpn",
+			Username: one.Name,
+			Name:     one.Description,
+			Email:    one.Mail,
+			Status:   one.StatusText,
+			State:    one.Status,
+			Detail:   one.Group,
+		})
+	}
+	return projectResult{OK: true, Message: fmt.Sprintf("读取完成，共 %d 个", len(items)), Data: map[string]interface{}{"items": items, "total": len(items)}}
+}
@@ -0,0 +1,44 @@
+package project
+
+import "testing"
+
+func TestReconcileAccountsReportsProtectedAndSkipsDisabledAD(t *testing.T) {
+	protected, _ := ParseProtectedAccounts(DefaultProtectedAccounts)
+	ad := []PersonAccount{
+		{Username: "zhangsan", Name: "张三", Email: "zhangsan@example.com", State: "enabled"},
+		{Username: "Administrator", State: "enabled"},
+		{Username: "krbtgt", State: "disabled"},
+		{Username: "leaver", Name: "离职", State: "disabled"},
+	}
+	systems := map[string][]PersonAccount{
+		"print": {
+			{Username: "zhangsan", Name: "张三", Email: "zhangsan@example.com", State: "enabled"},
+			{Username: "admin", State: "enabled"},
+			{Username: "contractor", State: "enabled"},
+		},
+	}
+	byKey := map[string]ReconcileIssue{}
+	for _, one := range ReconcileAccounts(ad, systems, protected) {
+		byKey[one.Key] = one
+	}
+	// The built-in print admin has no AD account, and the AD administrator
+	// has no print account; both are listed but offer nothing to run.
+	for _, key := range []string{"print:orphan:admin", "print:missing:Administrator"} {
+		one, ok := byKey[key]
+		if !ok || !one.Protected || len(one.Actions) != 0 {
+			t.Errorf("%s = %+v (found %v), want a report-only protected issue", key, one, ok)
+		}
+	}
+	if one := byKey["print:orphan:contractor"]; len(one.Actions) != 2 || one.Protected {
+		t.Errorf("contractor = %+v, want disable or delete", one)
+	}
+	// Disabled AD accounts are not expected on print at all.
+	for _, key := range []string{"print:missing:krbtgt", "print:missing:leaver"} {
+		if one, ok := byKey[key]; ok {
+			t.Errorf("%s = %+v, want no issue for a disabled AD account", key, one)
+		}
+	}
+	if len(byKey) != 3 {
+		t.Errorf("issues = %v, want 3", byKey)
+	}
+}
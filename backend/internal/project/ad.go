@@ -6,9 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
@@ -90,6 +88,10 @@ func adOperate(client *http.Client, action string, p map[string]interface{}) pro
 		return adModifyName(client, p)
 	case "delete_user":
 		return adDeleteUser(client, p)
+	case "find_account":
+		return adFindAccount(client, p)
+	case "list_accounts":
+		return adListAccounts(client, p)
 	default:
 		return projectResult{OK: false, Message: "不支持的AD操作", Error: "不支持的操作"}
 	}
@@ -249,58 +251,16 @@ func adBatchAddUsers(client *http.Client, p map[string]interface{}) projectResul
 	return projectResult{OK: true, Message: fmt.Sprintf("批量新增完成，成功 %d/%d", okCount, len(records)), Data: map[string]interface{}{"items": items}}
 }
 
-func adBatchUploadDir() string {
-	return filepath.Clean("./data/ad/uploads")
-}
-
 func adBatchTemplatePath() string {
 	return filepath.Clean("./data/ad/templates/创建AD用户模板.xlsx")
 }
 
 func adBatchExcelFiles() ([]string, error) {
-	dir := adBatchUploadDir()
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return nil, fmt.Errorf("prepare ad upload dir failed: %w", err)
-	}
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, fmt.Errorf("read ad upload dir failed: %w", err)
-	}
-	files := make([]string, 0)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if ext == ".xlsx" || ext == ".xls" {
-			files = append(files, entry.Name())
-		}
-	}
-	sort.Strings(files)
-	return files, nil
+	return batchFiles("ad")
 }
 
 func adResolveBatchExcelPath(excelFile string) (string, error) {
-	name := filepath.Base(strings.TrimSpace(excelFile))
-	if name == "" {
-		return "", errors.New("excel_file required")
-	}
-	ext := strings.ToLower(filepath.Ext(name))
-	if ext != ".xlsx" && ext != ".xls" {
-		return "", errors.New("excel file must be .xlsx or .xls")
-	}
-	path := filepath.Join(adBatchUploadDir(), name)
-	info, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "", fmt.Errorf("excel file not found: %s", name)
-		}
-		return "", fmt.Errorf("stat excel file failed: %w", err)
-	}
-	if info.IsDir() {
-		return "", fmt.Errorf("excel path is directory: %s", name)
-	}
-	return path, nil
+	return batchResolveFilePath("ad", excelFile)
 }
 
 func adReadBatchRowsFromExcel(excelPath string) ([]map[string]interface{}, error) {
@@ -473,6 +433,7 @@ func adSearchUsers(client *http.Client, p map[string]interface{}) projectResult
 		}
 
 		displayName := strings.TrimSpace(toString(m["displayName"]))
+		mail := strings.TrimSpace(toString(m["mail"]))
 		desc := ""
 		d := toSlice(m["description"])
 		if len(d) > 0 {
@@ -482,6 +443,7 @@ func adSearchUsers(client *http.Client, p map[string]interface{}) projectResult
 
 		if !strings.Contains(strings.ToLower(account), searchLower) &&
 			!strings.Contains(strings.ToLower(displayName), searchLower) &&
+			!strings.Contains(strings.ToLower(mail), searchLower) &&
 			!strings.Contains(strings.ToLower(desc), searchLower) {
 			continue
 		}
@@ -489,11 +451,12 @@ func adSearchUsers(client *http.Client, p map[string]interface{}) projectResult
 		items = append(items, map[string]interface{}{
 			"account":     account,
 			"displayName": displayName,
+			"mail":        mail,
 			"description": desc,
 			"roles":       roleText,
 			"dn":          dn,
 		})
-		logEntries = append(logEntries, fmt.Sprintf("账号：%s\n显示名称：%s\n描述：%s\n路径：%s", account, displayName, desc, dn))
+		logEntries = append(logEntries, fmt.Sprintf("账号：%s\n显示名称：%s\n邮箱：%s\n描述：%s\n路径：%s", account, displayName, mail, desc, dn))
 	}
 
 	var logBuilder strings.Builder
@@ -0,0 +1,359 @@
+package project
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+type batchField struct {
+	Key   string
+	Names []string
+}
+
+// ErrBatchActionUnsupported is returned for a batch action without a template.
+var ErrBatchActionUnsupported = errors.New("unsupported batch action")
+
+type batchTemplate struct {
+	Title    string
+	FileName string
+	Fields   []batchField
+}
+
+func BatchSupported(projectType string) bool {
+	switch projectType {
+	case "ad", "print", "vpn", "onboard", "offboard", "roster":
+		return true
+	default:
+		return false
+	}
+}
+
+func BatchFileExtAllowed(projectType, ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".xlsx", ".xls":
+		return true
+	case ".csv":
+		return projectType != "ad"
+	default:
+		return false
+	}
+}
+
+func BatchExcelFiles(projectType string) ([]string, error) {
+	return batchFiles(projectType)
+}
+
+func BatchUploadDir(projectType string) string {
+	return batchUploadDir(projectType)
+}
+
+// BatchTemplatePath returns the template for a batch action, generating it from
+// the header definition when the project has no static template file.
+func BatchTemplatePath(projectType, action string) (string, error) {
+	switch projectType {
+	case "ad":
+		return adBatchTemplatePath(), nil
+	case "print":
+		tpl, err := printBatchTemplate(action)
+		if err != nil {
+			return "", err
+		}
+		return batchEnsureTemplate(projectType, tpl)
+	case "vpn":
+		tpl, err := vpnBatchTemplate(action)
+		if err != nil {
+			return "", err
+		}
+		return batchEnsureTemplate(projectType, tpl)
+	case "onboard":
+		return batchEnsureTemplate(projectType, onboardTemplate)
+	case "offboard":
+		return batchEnsureTemplate(projectType, offboardTemplate)
+	case "roster":
+		return batchEnsureTemplate(projectType, rosterTemplate)
+	default:
+		return "", fmt.Errorf("unsupported batch project: %s", projectType)
+	}
+}
+
+func batchUploadDir(projectType string) string {
+	return filepath.Clean(filepath.Join("./data", projectType, "uploads"))
+}
+
+func batchTemplateDir(projectType string) string {
+	return filepath.Clean(filepath.Join("./data", projectType, "templates"))
+}
+
+func batchFiles(projectType string) ([]string, error) {
+	dir := batchUploadDir(projectType)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("prepare %s upload dir failed: %w", projectType, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read %s upload dir failed: %w", projectType, err)
+	}
+	files := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if BatchFileExtAllowed(projectType, filepath.Ext(entry.Name())) {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func batchResolveFilePath(projectType, fileName string) (string, error) {
+	name := filepath.Base(strings.TrimSpace(fileName))
+	if name == "" || name == "." {
+		return "", errors.New("excel_file required")
+	}
+	if !BatchFileExtAllowed(projectType, filepath.Ext(name)) {
+		if projectType == "ad" {
+			return "", errors.New("excel file must be .xlsx or .xls")
+		}
+		return "", errors.New("batch file must be .xlsx, .xls or .csv")
+	}
+	path := filepath.Join(batchUploadDir(projectType), name)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("excel file not found: %s", name)
+		}
+		return "", fmt.Errorf("stat excel file failed: %w", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("excel path is directory: %s", name)
+	}
+	return path, nil
+}
+
+func batchEnsureTemplate(projectType string, tpl batchTemplate) (string, error) {
+	if tpl.FileName == "" || len(tpl.Fields) == 0 {
+		return "", errors.New("batch template not defined")
+	}
+	path := filepath.Join(batchTemplateDir(projectType), tpl.FileName)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("prepare template dir failed: %w", err)
+	}
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	header := make([]interface{}, 0, len(tpl.Fields))
+	for _, one := range tpl.Fields {
+		header = append(header, one.Names[0])
+	}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return "", fmt.Errorf("write template header failed: %w", err)
+	}
+	if err := f.SaveAs(path); err != nil {
+		return "", fmt.Errorf("save template failed: %w", err)
+	}
+	return path, nil
+}
+
+// readBatchSheet returns all rows of the first sheet of an Excel file, or all
+// records of a CSV file. CSV files saved as GB18030 by Excel are transcoded.
+func readBatchSheet(path string) ([][]string, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		f, err := excelize.OpenFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("open excel failed: %w", err)
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("no sheet found")
+		}
+		rows, err := f.GetRows(sheets[0])
+		if err != nil {
+			return nil, fmt.Errorf("read excel rows failed: %w", err)
+		}
+		return rows, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open csv failed: %w", err)
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(b) {
+		if decoded, decErr := simplifiedchinese.GB18030.NewDecoder().Bytes(b); decErr == nil {
+			b = decoded
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv rows failed: %w", err)
+	}
+	return rows, nil
+}
+
+// batchColumns maps each field to its column index by header name, falling
+// back to the field's position in the template when the header is missing.
+func batchColumns(header []string, fields []batchField) []int {
+	idx := make([]int, len(fields))
+	for i, field := range fields {
+		idx[i] = i
+		for col, h := range header {
+			h = strings.ToLower(strings.TrimSpace(h))
+			for _, name := range field.Names {
+				if h == strings.ToLower(name) {
+					idx[i] = col
+				}
+			}
+		}
+	}
+	return idx
+}
+
+// batchParseRows converts sheet rows (header first) into records keyed by
+// field key. Blank rows are skipped and "__row" keeps the sheet row number.
+func batchParseRows(rows [][]string, fields []batchField) []map[string]interface{} {
+	if len(rows) == 0 {
+		return nil
+	}
+	cols := batchColumns(rows[0], fields)
+	records := make([]map[string]interface{}, 0, len(rows)-1)
+	for i := 1; i < len(rows); i++ {
+		m := make(map[string]interface{}, len(fields)+1)
+		blank := true
+		for j, field := range fields {
+			v := strings.TrimSpace(cellAt(rows[i], cols[j]))
+			if v != "" {
+				blank = false
+			}
+			m[field.Key] = v
+		}
+		if blank {
+			continue
+		}
+		m["__row"] = i + 1
+		records = append(records, m)
+	}
+	return records
+}
+
+// batchRecordsFromParams loads batch rows either from params["rows"] or from
+// the uploaded file named by params["excel_file"], parsed with parse.
+func batchRecordsFromParams(projectType string, p map[string]interface{}, parse func([][]string) ([]map[string]interface{}, error)) ([]map[string]interface{}, projectResult, bool) {
+	records := make([]map[string]interface{}, 0)
+	for _, one := range toSlice(p["rows"]) {
+		if m, ok := one.(map[string]interface{}); ok {
+			records = append(records, m)
+		}
+	}
+	if len(records) > 0 {
+		return records, projectResult{}, true
+	}
+	// Unlike the AD batch add, which still picks the only uploaded file, these
+	// batch actions must always name their file.
+	excelFile := strings.TrimSpace(toString(p["excel_file"]))
+	if excelFile == "" {
+		return nil, projectResult{OK: false, Message: "请先选择Excel文件", Error: "请先选择Excel文件"}, false
+	}
+	path, err := batchResolveFilePath(projectType, excelFile)
+	if err != nil {
+		return nil, projectResult{OK: false, Message: "Excel文件无效", Error: err.Error()}, false
+	}
+	rows, err := readBatchSheet(path)
+	if err != nil {
+		return nil, projectResult{OK: false, Message: "读取Excel失败", Error: err.Error()}, false
+	}
+	if len(rows) <= 1 {
+		return nil, projectResult{OK: false, Message: "Excel没有可用数据", Error: "excel has no data rows"}, false
+	}
+	records, err = parse(rows)
+	if err != nil {
+		return nil, projectResult{OK: false, Message: "读取Excel失败", Error: err.Error()}, false
+	}
+	if len(records) == 0 {
+		return nil, projectResult{OK: false, Message: "Excel没有可用数据", Error: "Excel没有可用数据"}, false
+	}
+	return records, projectResult{}, true
+}
+
+// batchRun applies one to every record of the batch file and streams a
+// progress line per row. one returns the row result and the password to
+// report; records without a "name" fail without calling one.
+func batchRun(projectType string, tpl batchTemplate, p map[string]interface{}, one func(m map[string]interface{}) (projectResult, string)) projectResult {
+	records, failRes, ok := batchRecordsFromParams(projectType, p, func(rows [][]string) ([]map[string]interface{}, error) {
+		return batchParseRows(rows, tpl.Fields), nil
+	})
+	if !ok {
+		return failRes
+	}
+
+	okCount := 0
+	items := make([]map[string]interface{}, 0, len(records))
+	logs := make([]string, 0, len(records))
+	for idx, m := range records {
+		user := strings.TrimSpace(toString(m["name"]))
+		res := projectResult{OK: false, Message: "用户名不能为空", Error: "用户名不能为空"}
+		pwd := ""
+		if user != "" {
+			res, pwd = one(m)
+		}
+		errorReason := ""
+		line := ""
+		if res.OK {
+			okCount++
+			line = fmt.Sprintf("第 %d 行：用户 %s %s成功", toInt(m["__row"]), user, tpl.Title)
+		} else {
+			errorReason = strings.TrimSpace(res.Error)
+			if errorReason == "" {
+				errorReason = strings.TrimSpace(res.Message)
+			}
+			pwd = ""
+			line = fmt.Sprintf("第 %d 行：用户 %s %s失败：%s", toInt(m["__row"]), user, tpl.Title, errorReason)
+		}
+		item := map[string]interface{}{
+			"row":          toInt(m["__row"]),
+			"ok":           res.OK,
+			"username":     user,
+			"password":     pwd,
+			"message":      res.Message,
+			"error_reason": errorReason,
+		}
+		// Rows that set an account expiry carry it, so it can be tracked.
+		if expire := strings.TrimSpace(toString(res.Data["expire_date"])); res.OK && expire != "" {
+			item["expire_date"] = expire
+		}
+		// Rows mirrored to another system (VPN to firewall) carry that
+		// outcome separately; it never changes the row's own result.
+		if remote := strings.TrimSpace(toString(res.Data["remote_log_text"])); remote != "" {
+			item["remote_log_text"] = remote
+			line += "；" + remote
+		}
+		items = append(items, item)
+		logs = append(logs, line)
+		emitProgress(p, line, idx+1, len(records))
+	}
+	return projectResult{
+		OK:      true,
+		Message: fmt.Sprintf("%s完成，成功 %d/%d", tpl.Title, okCount, len(records)),
+		Data: map[string]interface{}{
+			"items":    items,
+			"log_text": strings.Join(logs, "\n"),
+		},
+	}
+}
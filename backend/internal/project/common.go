@@ -16,10 +16,27 @@ import (
 )
 
 type Config struct {
-	ADAPIURL        string
-	PrintAPIURL     string
-	VPNSshAddr      string
-	FirewallSSHAddr string
+	ADAPIURL           string
+	PrintAPIURL        string
+	PrintBootstrapCSRF string
+	PrintAESKey        string
+	VPNSshAddr         string
+	VPNSSHPort         int
+	FirewallSSHAddr    string
+	FirewallSSHPort    int
+	// VPNDeviceExpiry sends VPN expiry dates to the device as expire-date;
+	// leave it off for firmware whose CLI has no account validity.
+	VPNDeviceExpiry bool
+}
+
+// Credential is the login material of one project. PrivateKey, Passphrase
+// and AuthMethod only apply to the SSH devices (vpn, firewall).
+type Credential struct {
+	Account    string
+	Password   string
+	PrivateKey string
+	Passphrase string
+	AuthMethod string
 }
 
 type Result struct {
@@ -92,7 +109,8 @@ func printEndpoint(path string) string {
 	return joinBaseURL(runtimeCfg.PrintAPIURL, path)
 }
 
-func Login(projectType, username, password string) (projectResult, error) {
+func Login(projectType string, cred Credential) (projectResult, error) {
+	username, password := cred.Account, cred.Password
 	switch projectType {
 	case "ad":
 		if _, err := adLogin(username, password); err != nil {
@@ -105,18 +123,26 @@ func Login(projectType, username, password string) (projectResult, error) {
 		}
 		return projectResult{OK: true, Message: "打印管理登录成功"}, nil
 	case "vpn":
-		cli, err := vpnLogin(username, password, runtimeCfg.VPNSshAddr, 22)
+		cli, err := sshDial(vpnDevice(), cred)
 		if err != nil {
 			return projectResult{OK: false, Message: "VPN 登录失败", Error: err.Error()}, nil
 		}
 		_ = cli.Close()
 		return projectResult{OK: true, Message: "VPN 登录成功"}, nil
+	case "firewall":
+		cli, err := sshDial(firewallDevice(), cred)
+		if err != nil {
+			return projectResult{OK: false, Message: "防火墙登录失败", Error: err.Error()}, nil
+		}
+		_ = cli.Close()
+		return projectResult{OK: true, Message: "防火墙登录成功"}, nil
 	default:
 		return projectResult{}, fmt.Errorf("unknown project type: %s", projectType)
 	}
 }
 
-func Operate(projectType, username, password, action string, params map[string]interface{}) (projectResult, error) {
+func Operate(projectType string, cred Credential, action string, params map[string]interface{}) (projectResult, error) {
+	username, password := cred.Account, cred.Password
 	if params == nil {
 		params = map[string]interface{}{}
 	}
@@ -134,31 +160,24 @@ func Operate(projectType, username, password, action string, params map[string]i
 		}
 		return printOperate(ctx, action, params), nil
 	case "vpn":
-		params["__vpn_account"] = username
-		params["__vpn_password"] = password
-		cli, err := vpnLogin(username, password, runtimeCfg.VPNSshAddr, 22)
+		ctx, err := newVPNCtx(vpnDevice(), cred)
+		if err != nil {
+			return projectResult{}, err
+		}
+		defer ctx.close()
+		return vpnOperate(ctx, action, params), nil
+	case "firewall":
+		ctx, err := newVPNCtx(firewallDevice(), cred)
 		if err != nil {
 			return projectResult{}, err
 		}
-		defer cli.Close()
-		return vpnOperate(cli, action, params), nil
+		defer ctx.close()
+		return firewallOperate(ctx, action, params), nil
 	default:
 		return projectResult{}, fmt.Errorf("unknown project type: %s", projectType)
 	}
 }
 
-func BatchExcelFiles() ([]string, error) {
-	return adBatchExcelFiles()
-}
-
-func BatchUploadDir() string {
-	return adBatchUploadDir()
-}
-
-func BatchTemplatePath() string {
-	return adBatchTemplatePath()
-}
-
 func newHTTPClient(timeout time.Duration) *http.Client {
 	jar, _ := cookiejar.New(nil)
 	return &http.Client{Timeout: timeout, Jar: jar}
@@ -0,0 +1,231 @@
+package project
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A desired-state file declares the accounts that should exist, in YAML or
+// JSON:
+//
+//	systems: [ad, print, vpn]
+//	accounts:
+//	  - username: zhangsan
+//	    name: 张三
+//	    email: zhangsan@example.com
+//	    department: 研发部
+//	    status: enabled
+//	    systems: [ad, vpn]
+//
+// The top-level systems are the ones the file manages (all when omitted); an
+// account's systems narrow that down for the account. Only the attributes an
+// account sets are compared.
+
+type desiredStateFile struct {
+	Systems  []string             `yaml:"systems"`
+	Accounts []desiredStateRecord `yaml:"accounts"`
+}
+
+type desiredStateRecord struct {
+	Username   string   `yaml:"username"`
+	Name       string   `yaml:"name"`
+	Email      string   `yaml:"email"`
+	Department string   `yaml:"department"`
+	Status     string   `yaml:"status"`
+	Systems    []string `yaml:"systems"`
+}
+
+// DesiredState is a parsed desired-state file. Row of each account is its
+// line in the file.
+type DesiredState struct {
+	Systems  []string
+	Accounts []DesiredAccount
+}
+
+// ParseDesiredState reads a desired-state file. Every problem is reported, one
+// per line, so a file can be fixed in one go.
+func ParseDesiredState(content string) (DesiredState, error) {
+	var state DesiredState
+	if strings.TrimSpace(content) == "" {
+		return state, errors.New("声明文件不能为空")
+	}
+	var doc yaml.Node
+	dec := yaml.NewDecoder(bytes.NewBufferString(content))
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return state, fmt.Errorf("声明文件格式错误：%s", err.Error())
+	}
+	var file desiredStateFile
+	dec = yaml.NewDecoder(bytes.NewBufferString(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && err != io.EOF {
+		return state, fmt.Errorf("声明文件格式错误：%s", err.Error())
+	}
+
+	problems := make([]string, 0)
+	state.Systems = AccountPlanSystems
+	if len(file.Systems) > 0 {
+		systems, bad := desiredSystems(file.Systems)
+		if len(bad) > 0 {
+			problems = append(problems, "不支持的系统："+strings.Join(bad, "、"))
+		}
+		state.Systems = systems
+	}
+	if len(file.Accounts) == 0 {
+		problems = append(problems, "没有声明任何账号（accounts）")
+	}
+
+	lines := desiredAccountLines(&doc)
+	seen := map[string]int{}
+	state.Accounts = make([]DesiredAccount, 0, len(file.Accounts))
+	for i, rec := range file.Accounts {
+		row := i + 1
+		if i < len(lines) {
+			row = lines[i]
+		}
+		fail := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("第 %d 行：", row)+fmt.Sprintf(format, args...))
+		}
+		account := DesiredAccount{
+			Row:        row,
+			Username:   strings.TrimSpace(rec.Username),
+			Name:       strings.TrimSpace(rec.Name),
+			Email:      strings.TrimSpace(rec.Email),
+			Department: strings.TrimSpace(rec.Department),
+		}
+		if account.Username == "" {
+			fail("用户名不能为空")
+			continue
+		}
+		if prev, ok := seen[accountKey(account.Username)]; ok {
+			fail("用户名 %s 与第 %d 行重复", account.Username, prev)
+			continue
+		}
+		seen[accountKey(account.Username)] = row
+		if account.Email != "" && !isValidEmail(account.Email) {
+			fail("邮箱格式不正确：%s", account.Email)
+		}
+		if status := strings.TrimSpace(rec.Status); status != "" {
+			if account.Status = printNormalizeStatus(status); account.Status == "" {
+				fail("状态只能是 enabled 或 disabled：%s", status)
+			}
+		}
+		if len(rec.Systems) > 0 {
+			systems, bad := desiredSystems(rec.Systems)
+			if len(bad) > 0 {
+				fail("不支持的系统：%s", strings.Join(bad, "、"))
+			}
+			if len(systems) == 0 {
+				continue
+			}
+			account.Systems = systems
+		}
+		state.Accounts = append(state.Accounts, account)
+	}
+	if len(problems) > 0 {
+		return state, errors.New(strings.Join(problems, "\n"))
+	}
+	return state, nil
+}
+
+func desiredSystems(names []string) ([]string, []string) {
+	systems := make([]string, 0, len(names))
+	bad := make([]string, 0)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, one := range AccountPlanSystems {
+			if one == name {
+				known = true
+			}
+		}
+		if !known {
+			bad = append(bad, name)
+			continue
+		}
+		systems = append(systems, name)
+	}
+	return systems, bad
+}
+
+// desiredAccountLines returns the line of each entry of the accounts list.
+func desiredAccountLines(doc *yaml.Node) []int {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "accounts" || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		lines := make([]int, 0, len(root.Content[i+1].Content))
+		for _, one := range root.Content[i+1].Content {
+			lines = append(lines, one.Line)
+		}
+		return lines
+	}
+	return nil
+}
+
+// PlanDesiredState plans state against the account lists of systems. Accounts
+// the file does not declare are left alone unless prune is set, in which case
+// they are planned for deletion; protected accounts are kept even then.
+func PlanDesiredState(state DesiredState, systems map[string][]PersonAccount, prune bool, protected ProtectedAccounts) []AccountPlanItem {
+	scoped := make(map[string][]PersonAccount, len(systems))
+	for _, system := range state.Systems {
+		if accounts, ok := systems[system]; ok {
+			scoped[system] = accounts
+		}
+	}
+	unwanted := map[string]string{}
+	if prune {
+		for system := range scoped {
+			unwanted[system] = AccountDelete
+		}
+	}
+	items := PlanAccounts(state.Accounts, scoped, unwanted, protected)
+	for i := range items {
+		if items[i].Row == 0 {
+			items[i].Note = "未在声明文件中声明"
+			if items[i].Protected {
+				items[i].Note = "未在声明文件中声明，受保护账号保留"
+			}
+		}
+	}
+	return items
+}
+
+// DesiredStateDigest fingerprints a plan, so an apply can tell whether the
+// live state still produces the plan the operator reviewed.
+func DesiredStateDigest(items []AccountPlanItem) string {
+	raw, _ := json.Marshal(items)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PruneOverLimit lists the systems on which the plan deletes more than
+// maxPercent of the accounts in systems, one description per system.
+func PruneOverLimit(items []AccountPlanItem, systems map[string][]PersonAccount, maxPercent int) []string {
+	deletes := map[string]int{}
+	for _, one := range items {
+		if one.Op == AccountDelete && one.Actionable {
+			deletes[one.System]++
+		}
+	}
+	out := make([]string, 0)
+	for _, system := range AccountPlanSystems {
+		n, total := deletes[system], len(systems[system])
+		if n == 0 || n*100 <= maxPercent*total {
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s将删除 %d/%d 个账号，超过上限 %d%%", WorkflowSystemTitle(system), n, total, maxPercent))
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testADAccounts(n int) []PersonAccount {
+	accounts := []PersonAccount{{Username: "Administrator"}, {Username: "krbtgt"}}
+	for i := 0; i < n; i++ {
+		accounts = append(accounts, PersonAccount{Username: fmt.Sprintf("user%02d", i), Name: "用户"})
+	}
+	return accounts
+}
+
+func TestPlanDesiredStatePruneKeepsProtectedAccounts(t *testing.T) {
+	protected, _ := ParseProtectedAccounts(DefaultProtectedAccounts)
+	state, err := ParseDesiredState("systems: [ad]\naccounts:\n  - username: user00\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := PlanDesiredState(state, map[string][]PersonAccount{"ad": testADAccounts(3)}, true, protected)
+	deleted := map[string]bool{}
+	for _, one := range items {
+		if one.Op == AccountDelete && one.Actionable {
+			deleted[one.Username] = true
+		}
+		if (one.Username == "Administrator" || one.Username == "krbtgt") && (one.Actionable || !one.Protected) {
+			t.Errorf("%s = %+v, want a protected keep", one.Username, one)
+		}
+	}
+	if len(deleted) != 2 || !deleted["user01"] || !deleted["user02"] {
+		t.Fatalf("deleted = %v, want user01 and user02", deleted)
+	}
+}
+
+func TestPruneOverLimit(t *testing.T) {
+	protected, _ := ParseProtectedAccounts(DefaultProtectedAccounts)
+	lists := map[string][]PersonAccount{"ad": testADAccounts(10)}
+
+	// user01..user09 go: 9 of the 12 accounts.
+	state, _ := ParseDesiredState("systems: [ad]\naccounts:\n  - username: user00\n")
+	items := PlanDesiredState(state, lists, true, protected)
+	over := PruneOverLimit(items, lists, 20)
+	if len(over) != 1 || over[0] != "AD将删除 9/12 个账号，超过上限 20%" {
+		t.Fatalf("over = %q", over)
+	}
+	if over = PruneOverLimit(items, lists, 80); len(over) != 0 {
+		t.Fatalf("9/12 is within 80%%: %q", over)
+	}
+	if over = PruneOverLimit(PlanDesiredState(state, lists, false, protected), lists, 0); len(over) != 0 {
+		t.Fatalf("no prune, yet over = %q", over)
+	}
+
+	// Only user09 goes: 1 of 12 is within 20%.
+	content := "systems: [ad]\naccounts:\n"
+	for i := 0; i < 9; i++ {
+		content += fmt.Sprintf("  - username: user%02d\n", i)
+	}
+	state, _ = ParseDesiredState(content)
+	if over = PruneOverLimit(PlanDesiredState(state, lists, true, protected), lists, 20); len(over) != 0 {
+		t.Fatalf("over = %q", over)
+	}
+}
+
+func TestDesiredStateDigestFollowsLiveState(t *testing.T) {
+	state, _ := ParseDesiredState("systems: [ad]\naccounts:\n  - username: user00\n    name: 张三\n")
+	lists := map[string][]PersonAccount{"ad": testADAccounts(2)}
+	digest := DesiredStateDigest(PlanDesiredState(state, lists, false, nil))
+	if again := DesiredStateDigest(PlanDesiredState(state, map[string][]PersonAccount{"ad": testADAccounts(2)}, false, nil)); again != digest {
+		t.Fatal("the same live state gave a different digest")
+	}
+	lists["ad"][2].Name = "张三"
+	if DesiredStateDigest(PlanDesiredState(state, lists, false, nil)) == digest {
+		t.Fatal("the digest did not change with the live state")
+	}
+}
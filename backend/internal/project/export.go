@@ -0,0 +1,79 @@
+package project
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func exportDir(projectType string) string {
+	return filepath.Clean(filepath.Join("./data", projectType, "exports"))
+}
+
+// ExportFilePath resolves a file produced by an export action. Only the base
+// name is honoured so a request cannot leave the export directory.
+func ExportFilePath(projectType, fileName string) (string, error) {
+	name := filepath.Base(strings.TrimSpace(fileName))
+	if name == "" || name == "." || strings.ToLower(filepath.Ext(name)) != ".xlsx" {
+		return "", errors.New("export file name invalid")
+	}
+	path := filepath.Join(exportDir(projectType), name)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("export file not found: %s", name)
+		}
+		return "", fmt.Errorf("stat export file failed: %w", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("export path is directory: %s", name)
+	}
+	return path, nil
+}
+
+// writeExportSheet saves header and rows as a new xlsx file in the project's
+// export directory and returns its file name. A random suffix keeps two
+// exports started in the same second apart. Exports must not carry
+// passwords: any logged-in user can download them.
+func writeExportSheet(projectType, prefix string, header []string, rows [][]interface{}) (string, error) {
+	dir := exportDir(projectType)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("prepare export dir failed: %w", err)
+	}
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	head := make([]interface{}, 0, len(header))
+	for _, one := range header {
+		head = append(head, one)
+	}
+	if err := f.SetSheetRow(sheet, "A1", &head); err != nil {
+		return "", fmt.Errorf("write export header failed: %w", err)
+	}
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return "", err
+		}
+		one := row
+		if err = f.SetSheetRow(sheet, cell, &one); err != nil {
+			return "", fmt.Errorf("write export row failed: %w", err)
+		}
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s_%s_%s.xlsx", prefix, time.Now().Format("20060102_150405"), hex.EncodeToString(suffix))
+	if err := f.SaveAs(filepath.Join(dir, name)); err != nil {
+		return "", fmt.Errorf("save export file failed: %w", err)
+	}
+	return name, nil
+}
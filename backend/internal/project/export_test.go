@@ -0,0 +1,48 @@
+package project
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestWriteExportSheetNamesDoNotCollide(t *testing.T) {
+	t.Chdir(t.TempDir())
+	first, err := writeExportSheet("vpn", "vpn_users", []string{"用户名"}, [][]interface{}{{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := writeExportSheet("vpn", "vpn_users", []string{"用户名"}, [][]interface{}{{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("two exports share the name %s", first)
+	}
+}
+
+func TestAccountApplyReportLeavesOutPasswords(t *testing.T) {
+	t.Chdir(t.TempDir())
+	name, err := AccountApplyReport("state", []map[string]interface{}{
+		{"system": "vpn", "op_text": "创建", "username": "zhangsan", "password": "S3cret-pass", "summary": "执行成功"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(filepath.Join(exportDir("state"), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows(f.GetSheetName(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if text := strings.Join(row, "|"); strings.Contains(text, "S3cret-pass") || strings.Contains(text, "密码") {
+			t.Fatalf("report row carries a password: %s", text)
+		}
+	}
+}
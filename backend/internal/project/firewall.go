@@ -0,0 +1,45 @@
+package project
+
+// The firewall keeps its own table of local VPN users and speaks the same CLI
+// as the gateway, so its actions reuse the VPN helpers on a firewall
+// connection. It never mirrors to itself and holds no VPN sessions, so
+// remote_firewall and kick_online are dropped before dispatching.
+
+func firewallOperate(ctx *vpnCtx, action string, p map[string]interface{}) projectResult {
+	return runVPNAction(ctx, action, p, firewallDispatch)
+}
+
+func firewallDispatch(ctx *vpnCtx, action string, p map[string]interface{}) projectResult {
+	p = firewallParams(p)
+	switch action {
+	case "list_sections":
+		return vpnListSections(ctx, p)
+	case "search_user":
+		return vpnSearchUser(ctx, p)
+	case "add_user":
+		return vpnAddUserWith(ctx, p, nil)
+	case "enable_user":
+		p["status"] = "enabled"
+		return vpnModifyStatus(ctx, p)
+	case "disable_user":
+		p["status"] = "disabled"
+		return vpnModifyStatus(ctx, p)
+	case "delete_users":
+		return vpnDeleteUsers(ctx, p)
+	case "find_account":
+		return vpnFindAccount(ctx, p)
+	default:
+		return projectResult{OK: false, Message: "不支持的防火墙操作", Error: "不支持的操作"}
+	}
+}
+
+// firewallParams copies p without the gateway-only switches.
+func firewallParams(p map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(p))
+	for k, v := range p {
+		out[k] = v
+	}
+	delete(out, "remote_firewall")
+	delete(out, "kick_online")
+	return out
+}
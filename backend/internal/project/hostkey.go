@@ -0,0 +1,54 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyStore keeps the pinned SSH host keys. TrustedHostKey returns the
+// confirmed SHA256 fingerprint for host ("" when none has been confirmed yet).
+// ObserveHostKey records a presented key that is not trusted so an admin can
+// confirm it; changed is set when it replaces a previously trusted key.
+type HostKeyStore interface {
+	TrustedHostKey(host string) (string, error)
+	ObserveHostKey(host, keyType, fingerprint string, changed bool) error
+}
+
+var hostKeyStore HostKeyStore
+
+var (
+	ErrHostKeyUnconfirmed = errors.New("SSH 主机密钥尚未确认")
+	ErrHostKeyChanged     = errors.New("SSH 主机密钥已变更")
+)
+
+func SetHostKeyStore(store HostKeyStore) {
+	hostKeyStore = store
+}
+
+// sshHostKeyCallback pins host keys trust-on-first-use: an unknown key is
+// recorded for confirmation and the connection is refused until an admin
+// confirms it; a key that differs from the trusted one always fails.
+func sshHostKeyCallback(hostname string, _ net.Addr, key ssh.PublicKey) error {
+	if hostKeyStore == nil {
+		return errors.New("SSH 主机密钥存储未初始化")
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	trusted, err := hostKeyStore.TrustedHostKey(hostname)
+	if err != nil {
+		return fmt.Errorf("读取 SSH 主机密钥失败：%w", err)
+	}
+	if trusted == fingerprint {
+		return nil
+	}
+	changed := trusted != ""
+	if err = hostKeyStore.ObserveHostKey(hostname, key.Type(), fingerprint, changed); err != nil {
+		return fmt.Errorf("记录 SSH 主机密钥失败：%w", err)
+	}
+	if changed {
+		return fmt.Errorf("%w：%s 当前指纹 %s 与已信任指纹 %s 不一致，已拒绝连接，请核实后在凭据配置中确认", ErrHostKeyChanged, hostname, fingerprint, trusted)
+	}
+	return fmt.Errorf("%w：首次连接 %s，指纹 %s，请在凭据配置中确认后重试", ErrHostKeyUnconfirmed, hostname, fingerprint)
+}
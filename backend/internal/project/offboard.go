@@ -0,0 +1,347 @@
+package project
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Offboarding looks a leaver up by username or email in every system
+// (find_account) and then disables, deletes or keeps each account found,
+// following a per-system policy. The runtime drives the steps on each
+// system's session; this file holds the lookups and the policy.
+
+// OffboardSystems lists the systems an offboarding covers, in the order the
+// steps run.
+var OffboardSystems = []string{"ad", "print", "vpn", "firewall"}
+
+const (
+	OffboardDisable = "disable"
+	OffboardDelete  = "delete"
+	OffboardKeep    = "keep"
+)
+
+// OffboardDefaultPolicy disables where the system can and keeps the AD
+// account, since the AD console can only delete.
+var OffboardDefaultPolicy = map[string]string{
+	"ad":       OffboardKeep,
+	"print":    OffboardDisable,
+	"vpn":      OffboardDisable,
+	"firewall": OffboardDisable,
+}
+
+var offboardTemplate = batchTemplate{
+	Title:    "离职",
+	FileName: "离职批量模板.xlsx",
+	Fields: []batchField{
+		{Key: "account", Names: []string{"用户名或邮箱", "用户名", "邮箱", "account", "username", "email"}},
+	},
+}
+
+// OffboardPolicyAllowed reports whether system supports the policy action.
+func OffboardPolicyAllowed(system, action string) bool {
+	switch action {
+	case OffboardKeep, OffboardDelete:
+		return true
+	case OffboardDisable:
+		return system != "ad"
+	default:
+		return false
+	}
+}
+
+// OffboardPolicyTitle is the display name of a policy action.
+func OffboardPolicyTitle(action string) string {
+	switch action {
+	case OffboardDisable:
+		return "禁用"
+	case OffboardDelete:
+		return "删除"
+	default:
+		return "保留"
+	}
+}
+
+// ParseOffboardPolicy reads "ad=keep,print=disable,..." on top of the
+// default policy. Unknown systems and actions a system cannot perform are
+// errors.
+func ParseOffboardPolicy(text string) (map[string]string, error) {
+	out := make(map[string]string, len(OffboardDefaultPolicy))
+	for k, v := range OffboardDefaultPolicy {
+		out[k] = v
+	}
+	for _, one := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' }) {
+		kv := strings.SplitN(one, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("离职策略格式错误：%s", one)
+		}
+		if err := SetOffboardPolicy(out, kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// SetOffboardPolicy sets one system's action in policy after checking it.
+func SetOffboardPolicy(policy map[string]string, system, action string) error {
+	system = strings.ToLower(strings.TrimSpace(system))
+	action = strings.ToLower(strings.TrimSpace(action))
+	if _, ok := OffboardDefaultPolicy[system]; !ok {
+		return fmt.Errorf("离职策略中的系统无效：%s", system)
+	}
+	if !OffboardPolicyAllowed(system, action) {
+		return fmt.Errorf("%s不支持离职动作：%s", WorkflowSystemTitle(system), action)
+	}
+	policy[system] = action
+	return nil
+}
+
+// FormatOffboardPolicy renders policy in system order, for logs.
+func FormatOffboardPolicy(policy map[string]string) string {
+	keys := make([]string, 0, len(policy))
+	for k := range policy {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return offboardOrder(keys[i]) < offboardOrder(keys[j]) })
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, WorkflowSystemTitle(k)+"="+OffboardPolicyTitle(policy[k]))
+	}
+	return strings.Join(parts, "，")
+}
+
+func offboardOrder(system string) int {
+	for i, one := range OffboardSystems {
+		if one == system {
+			return i
+		}
+	}
+	return len(OffboardSystems)
+}
+
+// OffboardRecords returns the leavers: p["accounts"] (usernames or emails,
+// a list or one text split on commas, semicolons and line breaks), otherwise
+// the rows of the uploaded sheet.
+func OffboardRecords(p map[string]interface{}) ([]map[string]interface{}, Result, bool) {
+	accounts := make([]string, 0)
+	values := toSlice(p["accounts"])
+	if text, ok := p["accounts"].(string); ok {
+		values = []interface{}{text}
+	}
+	for _, one := range values {
+		for _, acct := range strings.FieldsFunc(toString(one), func(r rune) bool {
+			return r == ',' || r == ';' || r == '，' || r == '；' || r == '\n' || r == '\r'
+		}) {
+			if acct = strings.TrimSpace(acct); acct != "" {
+				accounts = append(accounts, acct)
+			}
+		}
+	}
+	if len(accounts) > 0 {
+		records := make([]map[string]interface{}, 0, len(accounts))
+		for i, one := range accounts {
+			records = append(records, map[string]interface{}{"account": one, "__row": i + 1})
+		}
+		return records, Result{}, true
+	}
+	return batchRecordsFromParams("offboard", p, func(rows [][]string) ([]map[string]interface{}, error) {
+		return batchParseRows(rows, offboardTemplate.Fields), nil
+	})
+}
+
+// OffboardStep returns the action and parameters that apply policy to the
+// account found on system; an empty action means nothing to run.
+func OffboardStep(system, policy, account string) (string, map[string]interface{}) {
+	switch {
+	case policy == OffboardKeep:
+		return "", nil
+	case system == "ad" && policy == OffboardDelete:
+		return "delete_user", map[string]interface{}{"name": account}
+	case system == "print" && policy == OffboardDisable:
+		return "set_status", map[string]interface{}{"search_key": "username", "search_content": account, "status": "disabled"}
+	case system == "print" && policy == OffboardDelete:
+		return "delete_user", map[string]interface{}{"search_key": "username", "search_content": account}
+	case system == "vpn" && policy == OffboardDisable:
+		return "modify_status", map[string]interface{}{"vpn_user": account, "status": "disabled", "kick_online": true}
+	case system == "vpn" && policy == OffboardDelete:
+		return "delete_users", map[string]interface{}{"vpn_users": []interface{}{account}, "kick_online": true}
+	case system == "firewall" && policy == OffboardDisable:
+		return "disable_user", map[string]interface{}{"vpn_user": account}
+	case system == "firewall" && policy == OffboardDelete:
+		return "delete_users", map[string]interface{}{"vpn_users": []interface{}{account}}
+	default:
+		return "", nil
+	}
+}
+
+// OffboardPlanItem is the plan item that applies policy to account, the
+// account the leaver (as entered) resolved to on system.
+func OffboardPlanItem(system, policy string, row interface{}, leaver, account string) AccountPlanItem {
+	return AccountPlanItem{
+		Key:        system + ":" + policy + ":" + account,
+		System:     system,
+		Op:         policy,
+		OpText:     AccountOpTitle(policy),
+		Row:        toInt(row),
+		Username:   account,
+		Actionable: true,
+		Note:       "离职人员 " + leaver,
+	}
+}
+
+// OffboardReport saves the consolidated result of an offboarding run, one
+// column per system.
+func OffboardReport(systems []string, items []map[string]interface{}) (string, error) {
+	header := []string{"行号", "账号"}
+	for _, system := range systems {
+		header = append(header, WorkflowSystemTitle(system))
+	}
+	header = append(header, "结果")
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		row := []interface{}{item["row"], item["account"]}
+		steps, _ := item["steps"].([]map[string]interface{})
+		for _, system := range systems {
+			cell := "未执行"
+			for _, step := range steps {
+				if step["system"] == system {
+					cell = toString(step["summary"])
+				}
+			}
+			row = append(row, cell)
+		}
+		row = append(row, item["status_text"])
+		rows = append(rows, row)
+	}
+	return writeExportSheet("offboard", "offboard", header, rows)
+}
+
+// offboardFound is the find_account result: found reports whether exactly
+// one account matched, account is its username on that system.
+func offboardFound(account, name, email, status, detail string) projectResult {
+	lines := []string{"账号：" + account}
+	if name != "" {
+		lines = append(lines, "姓名："+name)
+	}
+	if email != "" {
+		lines = append(lines, "邮箱："+email)
+	}
+	if status != "" {
+		lines = append(lines, "状态："+status)
+	}
+	if detail != "" {
+		lines = append(lines, detail)
+	}
+	return projectResult{OK: true, Message: "已找到账号", Data: map[string]interface{}{
+		"found":    true,
+		"account":  account,
+		"name":     name,
+		"email":    email,
+		"status":   status,
+		"detail":   detail,
+		"log_text": strings.Join(lines, "\n"),
+	}}
+}
+
+func offboardNotFound() projectResult {
+	return projectResult{OK: true, Message: "未找到账号", Data: map[string]interface{}{"found": false, "log_text": "未找到账号"}}
+}
+
+// offboardLookup reads the account parameter and whether it is an email.
+func offboardLookup(p map[string]interface{}) (string, bool, projectResult, bool) {
+	value := strings.TrimSpace(toString(p["account"]))
+	if value == "" {
+		return "", false, projectResult{OK: false, Message: "查找账号失败", Error: "用户名或邮箱不能为空"}, false
+	}
+	return value, strings.Contains(value, "@"), projectResult{}, true
+}
+
+// adFindAccount finds the AD user by sAMAccountName, or by mail when value
+// is an email; the DN always comes from adFindDN on the account name.
+func adFindAccount(client *http.Client, p map[string]interface{}) projectResult {
+	value, byMail, failed, ok := offboardLookup(p)
+	if !ok {
+		return failed
+	}
+	data, err := adSearchRaw(client, value)
+	if err != nil {
+		return projectResult{OK: false, Message: "查找账号失败", Error: err.Error()}
+	}
+	var row map[string]interface{}
+	for _, one := range toSlice(data["message"]) {
+		m, ok := one.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if (byMail && strings.EqualFold(toString(m["mail"]), value)) || (!byMail && toString(m["sAMAccountName"]) == value) {
+			row = m
+			break
+		}
+	}
+	if row == nil {
+		return offboardNotFound()
+	}
+	account := toString(row["sAMAccountName"])
+	dn, err := adFindDN(client, account)
+	if err != nil {
+		return projectResult{OK: false, Message: "查找账号失败", Error: err.Error()}
+	}
+	if dn == "" {
+		return offboardNotFound()
+	}
+	return offboardFound(account, toString(row["displayName"]), toString(row["mail"]), "", "路径："+dn)
+}
+
+func printFindAccount(ctx *printCtx, p map[string]interface{}) projectResult {
+	value, byMail, failed, ok := offboardLookup(p)
+	if !ok {
+		return failed
+	}
+	key := "username"
+	if byMail {
+		key = "email"
+	}
+	u, err := printFindUser(ctx, key, value)
+	if err != nil {
+		return projectResult{OK: false, Message: "查找账号失败", Error: err.Error()}
+	}
+	if u == nil {
+		return offboardNotFound()
+	}
+	status := "启用"
+	if printFieldValue(u["status"]) == "disabled" {
+		status = "禁用"
+	}
+	return offboardFound(printFieldValue(u["name"]), printFieldValue(u["fullname"]), printFieldValue(u["email"]), status, "")
+}
+
+// vpnFindAccount serves the gateway and the firewall. Several users sharing
+// one mail are reported as an error rather than guessed.
+func vpnFindAccount(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	value, byMail, failed, ok := offboardLookup(p)
+	if !ok {
+		return failed
+	}
+	key := "name"
+	if byMail {
+		key = "mail"
+	}
+	items, out, err := vpnSearchItems(ctx, key, value, true)
+	if err != nil {
+		return projectResult{OK: false, Message: "查找账号失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
+	}
+	switch len(items) {
+	case 0:
+		return offboardNotFound()
+	case 1:
+		item := items[0]
+		return offboardFound(item.Name, item.Description, item.Mail, item.StatusText, "所属父组："+item.Group)
+	default:
+		names := make([]string, 0, len(items))
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		return projectResult{OK: false, Message: "查找账号失败", Error: fmt.Sprintf("匹配到 %d 个用户：%s", len(items), strings.Join(names, "、")), Data: map[string]interface{}{"candidates": items}}
+	}
+}
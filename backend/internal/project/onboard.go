@@ -0,0 +1,261 @@
+package project
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Onboarding creates one person on several systems from a single record:
+// username, name, email and department, plus optional per-system fields.
+// The runtime runs the add_user steps on each system's session; this file
+// maps the person record onto each system's parameters.
+
+// OnboardSystems lists the systems an onboarding can target, in the order the
+// steps run.
+var OnboardSystems = []string{"ad", "print", "vpn"}
+
+var onboardTemplate = batchTemplate{
+	Title:    "入职",
+	FileName: "入职批量模板.xlsx",
+	Fields: []batchField{
+		{Key: "username", Names: []string{"用户名", "username", "account"}},
+		{Key: "name", Names: []string{"姓名", "name", "fullname", "cn"}},
+		{Key: "email", Names: []string{"邮箱", "email", "mail"}},
+		{Key: "department", Names: []string{"部门", "department", "dept"}},
+		{Key: "sex", Names: []string{"性别", "sex", "gender"}},
+		{Key: "description", Names: []string{"描述", "description"}},
+		{Key: "password", Names: []string{"密码", "password"}},
+		{Key: "ou", Names: []string{"AD组织单位", "ou"}},
+		{Key: "print_section", Names: []string{"打印部门", "print_section"}},
+		{Key: "vpn_section", Names: []string{"VPN所属父组", "vpn_section"}},
+		{Key: "vpn_status", Names: []string{"VPN状态", "vpn_status"}},
+		{Key: "expire_date", Names: []string{"VPN到期日期", "expire_date"}},
+	},
+}
+
+// WorkflowSystemTitle is the display name of a workflow target system.
+func WorkflowSystemTitle(system string) string {
+	switch system {
+	case "ad":
+		return "AD"
+	case "print":
+		return "打印"
+	case "vpn":
+		return "VPN"
+	case "firewall":
+		return "防火墙"
+	default:
+		return system
+	}
+}
+
+// WorkflowStepError is why a workflow step's result failed, or "" when it
+// succeeded. delete_users reports per user, so any failed item fails the step.
+func WorkflowStepError(res Result) string {
+	if !res.OK {
+		if msg := strings.TrimSpace(res.Error); msg != "" {
+			return msg
+		}
+		if msg := strings.TrimSpace(res.Message); msg != "" {
+			return msg
+		}
+		return "执行失败"
+	}
+	items, _ := res.Data["items"].([]map[string]interface{})
+	for _, item := range items {
+		if item["ok"] != true {
+			if msg := strings.TrimSpace(toString(item["error"])); msg != "" {
+				return msg
+			}
+			return "执行失败"
+		}
+	}
+	return ""
+}
+
+// OnboardRecords returns the people to onboard: p["person"] for a single
+// person, otherwise the rows of p["rows"] or the uploaded sheet. Fields left
+// blank on a person are taken from p["defaults"].
+func OnboardRecords(p map[string]interface{}) ([]map[string]interface{}, Result, bool) {
+	records := make([]map[string]interface{}, 0)
+	if person, ok := p["person"].(map[string]interface{}); ok && len(person) > 0 {
+		one := make(map[string]interface{}, len(person)+1)
+		for k, v := range person {
+			one[k] = v
+		}
+		one["__row"] = 1
+		records = append(records, one)
+	} else {
+		var failRes Result
+		var ok bool
+		records, failRes, ok = batchRecordsFromParams("onboard", p, func(rows [][]string) ([]map[string]interface{}, error) {
+			return batchParseRows(rows, onboardTemplate.Fields), nil
+		})
+		if !ok {
+			return nil, failRes, false
+		}
+	}
+
+	defaults, _ := p["defaults"].(map[string]interface{})
+	for i, m := range records {
+		for k, v := range defaults {
+			if strings.TrimSpace(toString(m[k])) == "" {
+				m[k] = v
+			}
+		}
+		if toInt(m["__row"]) <= 0 {
+			m["__row"] = i + 1
+		}
+	}
+	return records, Result{}, true
+}
+
+// OnboardCheck reports what is missing from a person record before any
+// system is touched.
+func OnboardCheck(person map[string]interface{}) error {
+	if strings.TrimSpace(toString(person["username"])) == "" {
+		return errors.New("用户名不能为空")
+	}
+	if strings.TrimSpace(toString(person["name"])) == "" {
+		return errors.New("姓名不能为空")
+	}
+	email := strings.TrimSpace(toString(person["email"]))
+	if email == "" {
+		return errors.New("邮箱不能为空")
+	}
+	if !isValidEmail(email) {
+		return errors.New("邮箱格式不正确")
+	}
+	return nil
+}
+
+// OnboardPassword returns the password one person gets: the record's own
+// password, else fallback, else a generated one.
+func OnboardPassword(person map[string]interface{}, fallback string) string {
+	if pwd := strings.TrimSpace(toString(person["password"])); pwd != "" {
+		return pwd
+	}
+	if pwd := strings.TrimSpace(fallback); pwd != "" {
+		return pwd
+	}
+	return randomPassword()
+}
+
+// OnboardPasswords assigns each system its password: shared wherever the
+// system's password policy accepts it. AD and VPN require the strong policy;
+// when shared is too weak they get one generated password between them, print
+// takes any non-empty password.
+func OnboardPasswords(systems []string, shared string) map[string]string {
+	out := make(map[string]string, len(systems))
+	strong := shared
+	if !isValidStrongPassword(strong) {
+		strong = randomPassword()
+	}
+	for _, system := range systems {
+		switch system {
+		case "ad", "vpn":
+			out[system] = strong
+		default:
+			out[system] = shared
+		}
+	}
+	return out
+}
+
+// OnboardParams builds the add_user parameters of system for person.
+// department stands in for the AD OU and the print section when those are not
+// given; the VPN group has to be named explicitly and otherwise defaults.
+func OnboardParams(system string, person map[string]interface{}, password string) map[string]interface{} {
+	get := func(key string) string {
+		return strings.TrimSpace(toString(person[key]))
+	}
+	pick := func(keys ...string) string {
+		for _, key := range keys {
+			if v := get(key); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	switch system {
+	case "ad":
+		sn, given := onboardSplitName(get("name"))
+		return map[string]interface{}{
+			"sn":          sn,
+			"given_name":  given,
+			"cn":          get("name"),
+			"username":    get("username"),
+			"password":    password,
+			"email":       get("email"),
+			"description": pick("description", "department"),
+			"ou":          pick("ou", "department"),
+		}
+	case "print":
+		return map[string]interface{}{
+			"name":     get("username"),
+			"fullname": get("name"),
+			"sex":      printNormalizeSex(get("sex")),
+			"password": password,
+			"email":    get("email"),
+			"section":  pick("print_section", "department"),
+		}
+	case "vpn":
+		status := "enabled"
+		if one, ok := vpnNormalizeStatus(get("vpn_status")); ok {
+			status = one
+		}
+		return map[string]interface{}{
+			"vpn_user":    get("username"),
+			"passwd":      password,
+			"description": pick("description", "name"),
+			"mail":        get("email"),
+			"section":     get("vpn_section"),
+			"status":      status,
+			"expire_date": get("expire_date"),
+		}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// onboardSplitName splits a full name into surname and given name: the last
+// word for names written with spaces, otherwise the first character.
+func onboardSplitName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if words := strings.Fields(name); len(words) > 1 {
+		return words[len(words)-1], strings.Join(words[:len(words)-1], " ")
+	}
+	if utf8.RuneCountInString(name) < 2 {
+		return name, ""
+	}
+	_, size := utf8.DecodeRuneInString(name)
+	return name[:size], name[size:]
+}
+
+// OnboardReport saves the per-person results of an onboarding run as an
+// export file, one column per target system.
+func OnboardReport(systems []string, items []map[string]interface{}) (string, error) {
+	header := []string{"行号", "用户名", "姓名"}
+	for _, system := range systems {
+		header = append(header, WorkflowSystemTitle(system))
+	}
+	header = append(header, "结果")
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		row := []interface{}{item["row"], item["username"], item["name"]}
+		steps, _ := item["steps"].([]map[string]interface{})
+		for _, system := range systems {
+			cell := "未执行"
+			for _, step := range steps {
+				if step["system"] == system {
+					cell = toString(step["summary"])
+				}
+			}
+			row = append(row, cell)
+		}
+		row = append(row, item["status_text"])
+		rows = append(rows, row)
+	}
+	return writeExportSheet("onboard", "onboard", header, rows)
+}
@@ -0,0 +1,208 @@
+package project
+
+import (
+	"sort"
+	"strings"
+)
+
+// Person search runs each system's search_user for one keyword and folds the
+// accounts found into people. Accounts belong to the same person when their
+// usernames or emails match (case-insensitively); a shared name alone does not
+// join them, since two employees may have the same name.
+
+// PersonSearchSystems lists the systems a person search can cover, in display
+// order; PersonSearchDefault is used when the caller names none.
+var (
+	PersonSearchSystems = []string{"ad", "print", "vpn", "firewall"}
+	PersonSearchDefault = []string{"ad", "print", "vpn"}
+)
+
+// PersonAccount is one account found on one system.
+type PersonAccount struct {
+	System   string `json:"system"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Status   string `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+
+	// State is "enabled" or "disabled" when the system reports it, and
+	// Department the account's department; both are filled by list_accounts.
+	State      string `json:"state,omitempty"`
+	Department string `json:"department,omitempty"`
+}
+
+// Person groups the accounts of one person across systems. Username, Name and
+// Email are the values most of the accounts agree on.
+type Person struct {
+	Username string          `json:"username"`
+	Name     string          `json:"name"`
+	Email    string          `json:"email"`
+	Systems  []string        `json:"systems"`
+	Missing  []string        `json:"missing"`
+	Accounts []PersonAccount `json:"accounts"`
+}
+
+// PersonSearchParams returns the search_user parameters system is queried
+// with for keyword: by email when it contains "@", otherwise by username and
+// by name.
+func PersonSearchParams(system, keyword string) []map[string]interface{} {
+	byMail := strings.Contains(keyword, "@")
+	switch system {
+	case "ad":
+		return []map[string]interface{}{{"search_name": keyword}}
+	case "print":
+		if byMail {
+			return []map[string]interface{}{{"search_key": "email", "search_content": keyword}}
+		}
+		return []map[string]interface{}{
+			{"search_key": "username", "search_content": keyword},
+			{"search_key": "fullname", "search_content": keyword},
+		}
+	case "vpn", "firewall":
+		if byMail {
+			return []map[string]interface{}{{"search_key": "mail", "search_content": keyword}}
+		}
+		return []map[string]interface{}{
+			{"search_key": "name", "search_content": keyword},
+			{"search_key": "description", "search_content": keyword},
+		}
+	default:
+		return nil
+	}
+}
+
+// PersonAccounts normalizes the items of a search_user result of system.
+func PersonAccounts(system string, res Result) []PersonAccount {
+	out := make([]PersonAccount, 0)
+	switch items := res.Data["items"].(type) {
+	case []map[string]interface{}:
+		for _, m := range items {
+			out = append(out, PersonAccount{
+				System:   system,
+				Username: toString(m["account"]),
+				Name:     toString(m["displayName"]),
+				Email:    toString(m["mail"]),
+				Detail:   toString(m["dn"]),
+			})
+		}
+	case []printSearchItem:
+		for _, item := range items {
+			out = append(out, PersonAccount{System: system, Username: item.Name, Name: item.Fullname, Email: item.Email, Detail: item.Dept})
+		}
+	case []vpnSearchItem:
+		for _, item := range items {
+			out = append(out, PersonAccount{
+				System:   system,
+				Username: item.Name,
+				Name:     item.Description,
+				Email:    item.Mail,
+				Status:   item.StatusText,
+				Detail:   item.Group,
+			})
+		}
+	}
+	return out
+}
+
+// PersonMatches reports whether keyword occurs in the account's username,
+// email or name. AD also matches descriptions, so its hits are filtered here.
+func PersonMatches(account PersonAccount, keyword string) bool {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	for _, field := range []string{account.Username, account.Email, account.Name} {
+		if strings.Contains(strings.ToLower(field), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergePersons folds accounts into people and reports, for each person, which
+// of systems have no account for them. People are ordered by username.
+func MergePersons(systems []string, accounts []PersonAccount) []Person {
+	parent := make([]int, len(accounts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	owner := map[string]int{}
+	for i, one := range accounts {
+		for _, key := range []string{"u:" + strings.ToLower(strings.TrimSpace(one.Username)), "m:" + strings.ToLower(strings.TrimSpace(one.Email))} {
+			if len(key) == 2 {
+				continue
+			}
+			if j, ok := owner[key]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				owner[key] = i
+			}
+		}
+	}
+
+	groups := map[int][]PersonAccount{}
+	order := make([]int, 0)
+	for i, one := range accounts {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			order = append(order, root)
+		}
+		groups[root] = append(groups[root], one)
+	}
+	out := make([]Person, 0, len(order))
+	for _, root := range order {
+		group := groups[root]
+		sort.SliceStable(group, func(i, j int) bool { return personSystemOrder(group[i].System) < personSystemOrder(group[j].System) })
+		person := Person{Accounts: group, Systems: []string{}, Missing: []string{}}
+		person.Username = personCommon(group, func(a PersonAccount) string { return a.Username })
+		person.Name = personCommon(group, func(a PersonAccount) string { return a.Name })
+		person.Email = personCommon(group, func(a PersonAccount) string { return a.Email })
+		has := map[string]bool{}
+		for _, one := range group {
+			if !has[one.System] {
+				has[one.System] = true
+				person.Systems = append(person.Systems, one.System)
+			}
+		}
+		for _, system := range systems {
+			if !has[system] {
+				person.Missing = append(person.Missing, system)
+			}
+		}
+		out = append(out, person)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return strings.ToLower(out[i].Username) < strings.ToLower(out[j].Username) })
+	return out
+}
+
+func personSystemOrder(system string) int {
+	for i, one := range PersonSearchSystems {
+		if one == system {
+			return i
+		}
+	}
+	return len(PersonSearchSystems)
+}
+
+// personCommon returns the most frequent non-empty value of field in group,
+// the earliest one on a tie.
+func personCommon(group []PersonAccount, field func(PersonAccount) string) string {
+	counts := map[string]int{}
+	best := ""
+	for _, one := range group {
+		v := strings.TrimSpace(field(one))
+		if v == "" {
+			continue
+		}
+		counts[v]++
+		if counts[v] > counts[best] {
+			best = v
+		}
+	}
+	return best
+}
@@ -4,20 +4,37 @@ import (
 	"bytes"
 	"crypto/aes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type printCtx struct {
-	client    *http.Client
+	client   *http.Client
+	username string
+	password string
+
+	authMu    sync.Mutex
 	csrfToken string
+
+	deptMu sync.Mutex
+	depts  []printDept
+}
+
+type printDept struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id"`
+	Path     string `json:"path"`
 }
 
 type printSearchItem struct {
@@ -35,14 +52,46 @@ var printRoleNameToID = map[string]string{
 }
 
 func printLogin(username, password string) (*printCtx, error) {
+	if runtimeCfg.PrintAESKey == "" {
+		return nil, errors.New("PRINT_AES_KEY 未配置")
+	}
+	if strings.TrimSpace(runtimeCfg.PrintBootstrapCSRF) == "" {
+		return nil, errors.New("PRINT_BOOTSTRAP_CSRF 未配置")
+	}
+	switch len(runtimeCfg.PrintAESKey) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("PRINT_AES_KEY 长度必须为 16/24/32 字节")
+	}
+	ctx := &printCtx{username: username, password: password}
 	jar, _ := cookiejar.New(nil)
-	client := &http.Client{Timeout: 25 * time.Second, Jar: jar}
-	csrf0 := "cf003610-37c6-4963-916f-d9f6ecd6affd"
+	ctx.client = &http.Client{
+		Timeout:   25 * time.Second,
+		Jar:       jar,
+		Transport: &printAuthTransport{ctx: ctx},
+	}
+	csrf, err := ctx.login()
+	if err != nil {
+		return nil, err
+	}
+	ctx.csrfToken = csrf
+	return ctx, nil
+}
+
+// login runs the prelogin/login exchange on the shared cookie jar and returns
+// the new CSRF token. It bypasses printAuthTransport so a failing login is
+// never retried.
+func (ctx *printCtx) login() (string, error) {
+	csrf0 := strings.TrimSpace(runtimeCfg.PrintBootstrapCSRF)
+	if csrf0 == "" {
+		return "", errors.New("PRINT_BOOTSTRAP_CSRF 未配置")
+	}
+	client := &http.Client{Timeout: ctx.client.Timeout, Jar: ctx.client.Jar}
 	preTok, _ := printOnceToken(csrf0)
 	preURL := printEndpoint("isEnableVerifyCode")
 	preReq, err := http.NewRequest(http.MethodGet, preURL, nil)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	// Keep Python behavior: token is already quoted once and query encoding quotes it again.
 	q := preReq.URL.Query()
@@ -50,26 +99,26 @@ func printLogin(username, password string) (*printCtx, error) {
 	preReq.URL.RawQuery = q.Encode()
 	resp, err := client.Do(preReq)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if resp.StatusCode != http.StatusOK {
 		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("pre login http=%d body=%s", resp.StatusCode, truncate(string(body), 200))
+		return "", fmt.Errorf("pre login http=%d body=%s", resp.StatusCode, truncate(string(body), 200))
 	}
 	preData, err := decodeRespJSON(resp)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if toInt(preData["code"]) != 0 {
-		return nil, fmt.Errorf("pre login failed: %v", preData)
+		return "", fmt.Errorf("pre login failed: %v", preData)
 	}
 	csrf := resp.Header.Get("csrftoken")
 	if csrf == "" {
-		return nil, errors.New("missing csrf token")
+		return "", errors.New("missing csrf token")
 	}
-	nameEnc, _ := printEncryptAES(username)
-	pwdEnc, _ := printEncryptAES(password)
+	nameEnc, _ := printEncryptAES(ctx.username)
+	pwdEnc, _ := printEncryptAES(ctx.password)
 	loginTok, _ := printOnceToken(csrf)
 	payload := url.Values{}
 	payload.Set("csrftoken", loginTok)
@@ -79,21 +128,117 @@ func printLogin(username, password string) (*printCtx, error) {
 	payload.Set("forceLogin", "false")
 	lr, err := postForm(client, printEndpoint("login"), payload)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if lr.StatusCode != http.StatusOK {
 		defer lr.Body.Close()
 		body, _ := io.ReadAll(lr.Body)
-		return nil, fmt.Errorf("login http=%d body=%s", lr.StatusCode, truncate(string(body), 200))
+		return "", fmt.Errorf("login http=%d body=%s", lr.StatusCode, truncate(string(body), 200))
 	}
 	ld, err := decodeRespJSON(lr)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if toInt(ld["code"]) != 0 {
-		return nil, fmt.Errorf("login failed: %s", toString(ld["msg"]))
+		return "", fmt.Errorf("login failed: %s", toString(ld["msg"]))
+	}
+	return csrf, nil
+}
+
+// token returns the CSRF token of the current login.
+func (ctx *printCtx) token() string {
+	ctx.authMu.Lock()
+	defer ctx.authMu.Unlock()
+	return ctx.csrfToken
+}
+
+// relogin logs in again unless a concurrent request already replaced stale,
+// and returns the token to use from now on.
+func (ctx *printCtx) relogin(stale string) (string, error) {
+	ctx.authMu.Lock()
+	defer ctx.authMu.Unlock()
+	if ctx.csrfToken != stale {
+		return ctx.csrfToken, nil
+	}
+	csrf, err := ctx.login()
+	if err != nil {
+		return "", err
+	}
+	ctx.csrfToken = csrf
+	return csrf, nil
+}
+
+// printLoginTimeoutMsg is what the print server answers, with a non-zero
+// code, when the login session behind a request has timed out. The request
+// is rejected before the handler runs, so resending it is safe.
+const printLoginTimeoutMsg = "登录超时"
+
+// printAuthTransport logs in again when the print server reports a login
+// timeout and resends that one request with a fresh CSRF token. Any other
+// failure is handed back to the caller unchanged.
+type printAuthTransport struct {
+	ctx *printCtx
+}
+
+func (t *printAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	stale := t.ctx.token()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.Method != http.MethodPost || req.GetBody == nil {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if !printLoginTimedOut(body) {
+		return resp, nil
+	}
+	form, err := printRequestForm(req)
+	if err != nil || form.Get("csrftoken") == "" {
+		return resp, nil
 	}
-	return &printCtx{client: client, csrfToken: csrf}, nil
+	csrf, err := t.ctx.relogin(stale)
+	if err != nil {
+		return resp, nil
+	}
+	tok, _ := printOnceToken(csrf)
+	form.Set("csrftoken", tok)
+	encoded := form.Encode()
+	retry := req.Clone(req.Context())
+	retry.Body = io.NopCloser(strings.NewReader(encoded))
+	retry.ContentLength = int64(len(encoded))
+	retry.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(encoded)), nil
+	}
+	// The client set the cookies before the relogin; send the new session.
+	retry.Header.Del("Cookie")
+	for _, c := range t.ctx.client.Jar.Cookies(req.URL) {
+		retry.AddCookie(c)
+	}
+	return http.DefaultTransport.RoundTrip(retry)
+}
+
+func printRequestForm(req *http.Request) (url.Values, error) {
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(raw))
+}
+
+func printLoginTimedOut(body []byte) bool {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil || toInt(data["code"]) == 0 {
+		return false
+	}
+	return strings.Contains(toString(data["msg"]), printLoginTimeoutMsg)
 }
 
 func printOperate(ctx *printCtx, action string, p map[string]interface{}) projectResult {
@@ -110,78 +255,363 @@ func printOperate(ctx *printCtx, action string, p map[string]interface{}) projec
 		return printModifyUser(ctx, p)
 	case "delete_user":
 		return printDeleteUser(ctx, p)
+	case "list_departments":
+		return printListDepartments(ctx, p)
+	case "batch_add_users":
+		return printBatchAddUsers(ctx, p)
+	case "batch_delete_users":
+		return printBatchDeleteUsers(ctx, p)
+	case "batch_reset_password":
+		return printBatchResetPassword(ctx, p)
+	case "batch_assign_roles":
+		return printBatchAssignRoles(ctx, p)
+	case "set_status":
+		return printSetStatus(ctx, p)
+	case "batch_set_status":
+		return printBatchSetStatus(ctx, p)
+	case "find_account":
+		return printFindAccount(ctx, p)
+	case "list_accounts":
+		return printListAccounts(ctx, p)
 	default:
 		return projectResult{OK: false, Message: "不支持的打印管理操作", Error: "不支持的操作"}
 	}
 }
 
-func printDeptID(ctx *printCtx, section string) (string, error) {
-	tok, _ := printOnceToken(ctx.csrfToken)
-	payload := url.Values{}
-	payload.Set("csrftoken", tok)
-	payload.Set("flag", "dept")
-	payload.Set("page", "1")
-	payload.Set("pagesize", "500")
-	resp, err := postForm(ctx.client, printEndpoint("api/right/dept/queryTable"), payload)
+func printFetchDepts(ctx *printCtx) ([]printDept, error) {
+	const pageSize = 500
+	depts := make([]printDept, 0)
+	seen := make(map[string]bool)
+	for page := 1; page <= 200; page++ {
+		tok, _ := printOnceToken(ctx.token())
+		payload := url.Values{}
+		payload.Set("csrftoken", tok)
+		payload.Set("flag", "dept")
+		payload.Set("page", strconv.Itoa(page))
+		payload.Set("pagesize", strconv.Itoa(pageSize))
+		resp, err := postForm(ctx.client, printEndpoint("api/right/dept/queryTable"), payload)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("dept http=%d", resp.StatusCode)
+		}
+		data, err := decodeRespJSON(resp)
+		if err != nil {
+			return nil, err
+		}
+		rows := toSlice(data["data"])
+		// A server that ignores the page number answers with the same page
+		// again; stop once a page brings no department not seen before.
+		fresh := 0
+		for _, one := range rows {
+			m, ok := one.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id := strings.TrimSpace(toString(m["id"]))
+			if id != "" {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			fresh++
+			depts = append(depts, printDept{
+				ID:       id,
+				Name:     printNormalizePathName(toString(m["name"])),
+				ParentID: printDeptParentID(m),
+			})
+		}
+		total := printTotalCount(data)
+		if len(rows) < pageSize || fresh == 0 || (total > 0 && len(depts) >= total) {
+			break
+		}
+	}
+	printBuildDeptPaths(depts)
+	return depts, nil
+}
+
+func printDeptParentID(m map[string]interface{}) string {
+	for _, key := range []string{"parentId", "parentid", "pid", "parent.id"} {
+		if id := strings.TrimSpace(toString(m[key])); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func printTotalCount(data map[string]interface{}) int {
+	for _, key := range []string{"total", "count", "totalCount", "recordsTotal"} {
+		if n := toInt(data[key]); n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// printBuildDeptPaths fills Path with the backslash-joined names from the root
+// department down, matching the dept.name format returned by user queries.
+func printBuildDeptPaths(depts []printDept) {
+	index := make(map[string]int, len(depts))
+	for i, d := range depts {
+		if d.ID != "" {
+			index[d.ID] = i
+		}
+	}
+	var resolve func(i int, seen map[int]bool) string
+	resolve = func(i int, seen map[int]bool) string {
+		d := &depts[i]
+		if d.Path != "" {
+			return d.Path
+		}
+		if seen[i] {
+			return d.Name
+		}
+		seen[i] = true
+		path := d.Name
+		if pi, ok := index[d.ParentID]; ok && d.ParentID != d.ID && !strings.Contains(d.Name, `\`) {
+			if parent := resolve(pi, seen); parent != "" {
+				path = parent + `\` + d.Name
+			}
+		}
+		d.Path = path
+		return path
+	}
+	for i := range depts {
+		resolve(i, map[int]bool{})
+	}
+}
+
+// printDepartments returns the department tree cached on ctx, loading it on
+// first use or when refresh is set.
+func printDepartments(ctx *printCtx, refresh bool) ([]printDept, error) {
+	ctx.deptMu.Lock()
+	defer ctx.deptMu.Unlock()
+	if ctx.depts != nil && !refresh {
+		return ctx.depts, nil
+	}
+	depts, err := printFetchDepts(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("dept http=%d", resp.StatusCode)
+	ctx.depts = depts
+	return depts, nil
+}
+
+func printMatchDept(depts []printDept, deptID, section string) (printDept, error) {
+	if deptID != "" {
+		for _, d := range depts {
+			if d.ID == deptID {
+				return d, nil
+			}
+		}
+		return printDept{}, errPrintDeptNotFound
 	}
-	data, err := decodeRespJSON(resp)
+	section = printNormalizePathName(section)
+	if section == "" {
+		return printDept{}, errPrintDeptNotFound
+	}
+	matches := make([]printDept, 0, 2)
+	for _, d := range depts {
+		if d.Path == section {
+			matches = append(matches, d)
+		}
+	}
+	if len(matches) == 0 {
+		for _, d := range depts {
+			if d.Name == section || strings.HasSuffix(d.Path, `\`+section) {
+				matches = append(matches, d)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return printDept{}, errPrintDeptNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		paths := make([]string, 0, len(matches))
+		for _, d := range matches {
+			paths = append(paths, fmt.Sprintf("%s（ID：%s）", d.Path, d.ID))
+		}
+		return printDept{}, fmt.Errorf("部门名称不唯一，请使用完整路径或部门ID：%s", strings.Join(paths, "、"))
+	}
+}
+
+var errPrintDeptNotFound = errors.New("未找到对应部门")
+
+// printResolveDept locates a department by ID or full path. A miss against the
+// cached tree triggers one reload so departments created after login are found.
+func printResolveDept(ctx *printCtx, deptID, section string) (printDept, error) {
+	depts, err := printDepartments(ctx, false)
 	if err != nil {
-		return "", err
+		return printDept{}, err
 	}
-	for _, one := range toSlice(data["data"]) {
-		m, ok := one.(map[string]interface{})
-		if ok && toString(m["name"]) == section {
-			return toString(m["id"]), nil
+	d, err := printMatchDept(depts, deptID, section)
+	if !errors.Is(err, errPrintDeptNotFound) {
+		return d, err
+	}
+	if depts, err = printDepartments(ctx, true); err != nil {
+		return printDept{}, err
+	}
+	return printMatchDept(depts, deptID, section)
+}
+
+func printListDepartments(ctx *printCtx, p map[string]interface{}) projectResult {
+	depts, err := printDepartments(ctx, toBoolDefault(p["refresh"], false))
+	if err != nil {
+		return projectResult{OK: false, Message: "查询部门失败", Error: err.Error()}
+	}
+	keyword := printNormalizePathName(toString(p["keyword"]))
+	items := make([]printDept, 0, len(depts))
+	for _, d := range depts {
+		if keyword != "" && !strings.Contains(d.Path, keyword) {
+			continue
 		}
+		items = append(items, d)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	lines := make([]string, 0, len(items))
+	for _, d := range items {
+		lines = append(lines, fmt.Sprintf("%s（ID：%s）", d.Path, d.ID))
+	}
+	logText := strings.Join(lines, "\n")
+	if logText == "" {
+		logText = "未查询到部门信息"
+	}
+	return projectResult{
+		OK:      true,
+		Message: fmt.Sprintf("查询完成，共 %d 个部门", len(items)),
+		Data: map[string]interface{}{
+			"items":    items,
+			"log_text": logText,
+		},
 	}
-	return "", nil
 }
 
-func printSearchUserRaw(ctx *printCtx, key, value string) (map[string]interface{}, error) {
-	tok, _ := printOnceToken(ctx.csrfToken)
+const printUserPageSize = 500
+
+func printSearchUserPage(ctx *printCtx, key, value string, page, pageSize int) ([]map[string]interface{}, int, error) {
+	tok, _ := printOnceToken(ctx.token())
 	payload := url.Values{}
 	payload.Set("csrftoken", tok)
-	payload.Set("pageSizeNum", "500")
+	// The query endpoint pairs pageSizeNum with pageNum; page is sent as well
+	// for older server builds that only read the generic name.
+	payload.Set("pageNum", strconv.Itoa(page))
+	payload.Set("page", strconv.Itoa(page))
+	payload.Set("pageSizeNum", strconv.Itoa(pageSize))
 	if key != "email" {
 		payload.Set("userNameType", key)
 	}
 	payload.Set(key, value)
 	resp, err := postForm(ctx.client, printEndpoint("api/right/user/query"), payload)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("query http=%d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("query http=%d", resp.StatusCode)
 	}
-	return decodeRespJSON(resp)
-}
-
-func printFindUser(ctx *printCtx, key, value string) (map[string]interface{}, error) {
-	data, err := printSearchUserRaw(ctx, key, value)
+	data, err := decodeRespJSON(resp)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	rows := make([]map[string]interface{}, 0)
 	for _, one := range toSlice(data["data"]) {
-		m, ok := one.(map[string]interface{})
-		if !ok {
-			continue
+		if m, ok := one.(map[string]interface{}); ok {
+			rows = append(rows, m)
+		}
+	}
+	return rows, printTotalCount(data), nil
+}
+
+// printEachUser walks every result page of a user query, stopping early when
+// visit returns false. It returns the server-reported total. A page that
+// starts with the same user as the previous one means the server ignored the
+// page number, so the walk stops there instead of visiting the rows again.
+func printEachUser(ctx *printCtx, key, value string, visit func(m map[string]interface{}) bool) (int, error) {
+	seen := 0
+	total := 0
+	lastFirst := ""
+	for page := 1; page <= 1000; page++ {
+		rows, pageTotal, err := printSearchUserPage(ctx, key, value, page, printUserPageSize)
+		if err != nil {
+			return total, err
 		}
-		if key == "username" && toString(m["name"]) == value {
-			return m, nil
+		if pageTotal > 0 {
+			total = pageTotal
 		}
-		if key == "fullname" && toString(m["fullname"]) == value {
-			return m, nil
+		if len(rows) > 0 {
+			first := printUserKey(rows[0])
+			if page > 1 && first != "" && first == lastFirst {
+				break
+			}
+			lastFirst = first
+		}
+		for _, m := range rows {
+			if !visit(m) {
+				return total, nil
+			}
 		}
-		if key == "email" && toString(m["email"]) == value {
-			return m, nil
+		seen += len(rows)
+		if len(rows) < printUserPageSize || (total > 0 && seen >= total) {
+			break
 		}
 	}
-	return nil, nil
+	if total == 0 {
+		total = seen
+	}
+	return total, nil
+}
+
+// printUserKey identifies a user row by its id, or its login name when the
+// row has no id.
+func printUserKey(m map[string]interface{}) string {
+	if id := strings.TrimSpace(toString(m["id"])); id != "" {
+		return id
+	}
+	return strings.TrimSpace(toString(m["name"]))
+}
+
+// printFindUserByID returns the row of user id. The original email finds it
+// in one query; otherwise every user is walked.
+func printFindUserByID(ctx *printCtx, id, email string) (map[string]interface{}, error) {
+	if email != "" {
+		u, err := printFindUser(ctx, "email", email)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil && toString(u["id"]) == id {
+			return u, nil
+		}
+	}
+	var found map[string]interface{}
+	_, err := printEachUser(ctx, "fullname", "", func(m map[string]interface{}) bool {
+		if toString(m["id"]) == id {
+			found = m
+			return false
+		}
+		return true
+	})
+	return found, err
+}
+
+func printFindUser(ctx *printCtx, key, value string) (map[string]interface{}, error) {
+	field := map[string]string{"username": "name", "fullname": "fullname", "email": "email"}[key]
+	var found map[string]interface{}
+	_, err := printEachUser(ctx, key, value, func(m map[string]interface{}) bool {
+		if field != "" && toString(m[field]) == value {
+			found = m
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
 }
 
 func printAllowedSearchKey(v string) string {
@@ -216,6 +646,24 @@ func printRoleIDsFromNames(roleNames string) []string {
 	return roleIDs
 }
 
+// printUserRoleIDs prefers the role IDs reported on the user record so roles
+// missing from printRoleNameToID survive a save; names are the fallback.
+func printUserRoleIDs(u map[string]interface{}) []string {
+	if ids := printNormalizeRoleIDs(u["roleIds"]); len(ids) > 0 {
+		return ids
+	}
+	return printRoleIDsFromNames(toString(u["roleNames"]))
+}
+
+func printUserDeptID(u map[string]interface{}) string {
+	for _, key := range []string{"dept.id", "deptId", "deptid"} {
+		if id := strings.TrimSpace(toString(u[key])); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
 func printNormalizeRoleIDs(v interface{}) []string {
 	raw := make([]string, 0)
 	switch vv := v.(type) {
@@ -257,7 +705,8 @@ func printAddUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	password := strings.TrimSpace(toString(p["password"]))
 	email := strings.TrimSpace(toString(p["email"]))
 	section := strings.TrimSpace(toString(p["section"]))
-	if name == "" || fullname == "" || sex == "" || password == "" || email == "" || section == "" {
+	deptID := strings.TrimSpace(toString(p["dept_id"]))
+	if name == "" || fullname == "" || sex == "" || password == "" || email == "" || (section == "" && deptID == "") {
 		return projectResult{OK: false, Message: "新增用户失败", Error: "必填项不能为空"}
 	}
 	if sex != "male" && sex != "female" && sex != "unknown" {
@@ -266,14 +715,11 @@ func printAddUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	if !isValidEmail(email) {
 		return projectResult{OK: false, Message: "新增用户失败", Error: "邮箱格式不正确"}
 	}
-	deptID, err := printDeptID(ctx, section)
+	dept, err := printResolveDept(ctx, deptID, section)
 	if err != nil {
 		return projectResult{OK: false, Message: "新增用户失败", Error: err.Error()}
 	}
-	if deptID == "" {
-		return projectResult{OK: false, Message: "新增用户失败", Error: "未找到对应部门"}
-	}
-	tok, _ := printOnceToken(ctx.csrfToken)
+	tok, _ := printOnceToken(ctx.token())
 	nameEnc, _ := printEncryptAES(name)
 	fullEnc, _ := printEncryptAES(fullname)
 	pwdEnc, _ := printEncryptAES(password)
@@ -286,12 +732,11 @@ func printAddUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	payload.Set("pwd2", pwdEnc)
 	payload.Set("email", email)
 	payload.Set("status", "enabled")
-	payload.Set("dept", deptID)
-	payload.Set("defaultlang", "zh_CN")
-	payload.Set("docsecuritylevel", "public")
+	payload.Set("dept", dept.ID)
 	payload.Set("roleIds", "12483a1e79473e4")
-	payload.Set("isauditor", "false")
-	payload.Set("userAuthStr", "[]")
+	for _, one := range printUserSaveExtras {
+		payload.Set(one.key, one.def)
+	}
 	resp, err := postForm(ctx.client, printEndpoint("api/right/user/save"), payload)
 	if err != nil {
 		return projectResult{OK: false, Message: "新增用户失败", Error: err.Error()}
@@ -316,55 +761,75 @@ func printSearchUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	if value == "" {
 		return projectResult{OK: false, Message: "查询用户失败", Error: "查询值不能为空"}
 	}
-	data, err := printSearchUserRaw(ctx, key, value)
+	rows := make([]map[string]interface{}, 0)
+	page := toInt(p["page"])
+	pageSize := toInt(p["page_size"])
+	total := 0
+	var err error
+	if page > 0 {
+		if pageSize <= 0 || pageSize > printUserPageSize {
+			pageSize = 50
+		}
+		rows, total, err = printSearchUserPage(ctx, key, value, page, pageSize)
+		if total == 0 {
+			total = (page-1)*pageSize + len(rows)
+		}
+	} else {
+		total, err = printEachUser(ctx, key, value, func(m map[string]interface{}) bool {
+			rows = append(rows, m)
+			return true
+		})
+	}
 	if err != nil {
 		return projectResult{OK: false, Message: "查询用户失败", Error: err.Error()}
 	}
-	items := make([]printSearchItem, 0)
+	items := make([]printSearchItem, 0, len(rows))
 	var logBuilder strings.Builder
-	for _, one := range toSlice(data["data"]) {
-		if m, ok := one.(map[string]interface{}); ok {
-			item := printSearchItem{
-				Name:     toString(m["name"]),
-				Fullname: toString(m["fullname"]),
-				Email:    toString(m["email"]),
-				Dept:     printNormalizePathName(toString(m["dept.name"])),
-			}
-			items = append(items, item)
+	for _, m := range rows {
+		item := printSearchItem{
+			Name:     toString(m["name"]),
+			Fullname: toString(m["fullname"]),
+			Email:    toString(m["email"]),
+			Dept:     printNormalizePathName(toString(m["dept.name"])),
+		}
+		items = append(items, item)
 
-			roleName := strings.TrimSpace(toString(m["roleNames"]))
-			if roleName == "" {
-				roleName = "-"
-			}
+		roleName := strings.TrimSpace(toString(m["roleNames"]))
+		if roleName == "" {
+			roleName = "-"
+		}
 
-			if logBuilder.Len() > 0 {
-				logBuilder.WriteString("\n\n")
-			}
-			logBuilder.WriteString("用户名：")
-			logBuilder.WriteString(item.Name)
-			logBuilder.WriteString("\n姓名：")
-			logBuilder.WriteString(item.Fullname)
-			logBuilder.WriteString("\n邮箱：")
-			logBuilder.WriteString(item.Email)
-			logBuilder.WriteString("\n部门：")
-			logBuilder.WriteString(item.Dept)
-			logBuilder.WriteString("\n角色：")
-			logBuilder.WriteString(roleName)
-			emitProgress(p, fmt.Sprintf("匹配到打印用户：%s", item.Name), len(items), 0)
+		if logBuilder.Len() > 0 {
+			logBuilder.WriteString("\n\n")
 		}
+		logBuilder.WriteString("用户名：")
+		logBuilder.WriteString(item.Name)
+		logBuilder.WriteString("\n姓名：")
+		logBuilder.WriteString(item.Fullname)
+		logBuilder.WriteString("\n邮箱：")
+		logBuilder.WriteString(item.Email)
+		logBuilder.WriteString("\n部门：")
+		logBuilder.WriteString(item.Dept)
+		logBuilder.WriteString("\n角色：")
+		logBuilder.WriteString(roleName)
+		emitProgress(p, fmt.Sprintf("匹配到打印用户：%s", item.Name), len(items), len(rows))
 	}
 	logText := strings.TrimSpace(logBuilder.String())
 	if logText == "" {
 		logText = "未查询到相关搜索信息"
 	}
-	return projectResult{
-		OK:      true,
-		Message: fmt.Sprintf("查询完成，共 %d 条", len(items)),
-		Data: map[string]interface{}{
-			"items":    items,
-			"log_text": logText,
-		},
+	data := map[string]interface{}{
+		"items":    items,
+		"total":    total,
+		"log_text": logText,
 	}
+	message := fmt.Sprintf("查询完成，共 %d 条", len(items))
+	if page > 0 {
+		data["page"] = page
+		data["page_size"] = pageSize
+		message = fmt.Sprintf("查询完成，第 %d 页 %d 条，共 %d 条", page, len(items), total)
+	}
+	return projectResult{OK: true, Message: message, Data: data}
 }
 
 func printGetUser(ctx *printCtx, p map[string]interface{}) projectResult {
@@ -415,7 +880,7 @@ func printResetPassword(ctx *printCtx, p map[string]interface{}) projectResult {
 	if u == nil {
 		return projectResult{OK: false, Message: "重置密码失败", Error: "用户不存在"}
 	}
-	tok, _ := printOnceToken(ctx.csrfToken)
+	tok, _ := printOnceToken(ctx.token())
 	pwdEnc, _ := printEncryptAES(password)
 	payload := url.Values{}
 	payload.Set("csrftoken", tok)
@@ -439,6 +904,30 @@ func printResetPassword(ctx *printCtx, p map[string]interface{}) projectResult {
 	return projectResult{OK: false, Message: "重置密码失败", Error: toString(data["msg"]), Data: map[string]interface{}{"raw": data}}
 }
 
+// printUserSaveExtras are the save fields the forms do not edit, with the
+// values a new user gets.
+var printUserSaveExtras = []struct{ key, def string }{
+	{"defaultlang", "zh_CN"},
+	{"docsecuritylevel", "public"},
+	{"isauditor", "false"},
+	{"userAuthStr", "[]"},
+}
+
+// printSetSaveExtras copies the extras of row u into a save payload. Only a
+// row that lacks a field falls back to the new-user value.
+func printSetSaveExtras(payload url.Values, u map[string]interface{}) {
+	for _, one := range printUserSaveExtras {
+		v := printFieldValue(u[one.key])
+		if v == "" {
+			v = one.def
+		}
+		payload.Set(one.key, v)
+	}
+}
+
+// printModifyUser saves a user through api/right/user/save, which replaces the
+// whole record. The current row is always read first and every field the
+// caller does not set is sent back unchanged.
 func printModifyUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	key := printAllowedSearchKey(toStringDefault(p["search_key"], "fullname"))
 	value := strings.TrimSpace(toString(p["search_content"]))
@@ -451,48 +940,54 @@ func printModifyUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	status := strings.TrimSpace(toString(p["status"]))
 	email := strings.TrimSpace(toString(p["email"]))
 	section := strings.TrimSpace(toString(p["section"]))
+	deptID := strings.TrimSpace(toString(p["dept_id"]))
 	roleIDs := printNormalizeRoleIDs(p["roles"])
 
-	// Keep backward compatibility: if user_id is not provided, locate user by search key/value.
-	if userID == "" {
+	var u map[string]interface{}
+	var err error
+	if userID != "" {
+		u, err = printFindUserByID(ctx, userID, oriEmail)
+	} else {
 		if value == "" {
 			return projectResult{OK: false, Message: "修改用户失败", Error: "查询值不能为空"}
 		}
-		u, err := printFindUser(ctx, key, value)
-		if err != nil {
-			return projectResult{OK: false, Message: "修改用户失败", Error: err.Error()}
-		}
-		if u == nil {
-			return projectResult{OK: false, Message: "修改用户失败", Error: "用户不存在"}
-		}
-		userID = toString(u["id"])
-		if name == "" {
-			name = strings.TrimSpace(toString(u["name"]))
-		}
-		if fullname == "" {
-			fullname = strings.TrimSpace(toString(u["fullname"]))
-		}
-		if sex == "" {
-			sex = printFieldValue(u["sex"])
-		}
-		if status == "" {
-			status = printFieldValue(u["status"])
-		}
-		if email == "" {
-			email = strings.TrimSpace(toString(u["email"]))
-		}
-		if section == "" {
+		u, err = printFindUser(ctx, key, value)
+	}
+	if err != nil {
+		return projectResult{OK: false, Message: "修改用户失败", Error: err.Error()}
+	}
+	if u == nil {
+		return projectResult{OK: false, Message: "修改用户失败", Error: "用户不存在"}
+	}
+	userID = toString(u["id"])
+	if name == "" {
+		name = strings.TrimSpace(toString(u["name"]))
+	}
+	if fullname == "" {
+		fullname = strings.TrimSpace(toString(u["fullname"]))
+	}
+	if sex == "" {
+		sex = printFieldValue(u["sex"])
+	}
+	if status == "" {
+		status = printFieldValue(u["status"])
+	}
+	if email == "" {
+		email = strings.TrimSpace(toString(u["email"]))
+	}
+	if section == "" && deptID == "" {
+		if deptID = printUserDeptID(u); deptID == "" {
 			section = strings.TrimSpace(toString(u["dept.name"]))
 		}
-		if oriEmail == "" {
-			oriEmail = strings.TrimSpace(toString(u["email"]))
-		}
-		if len(roleIDs) == 0 {
-			roleIDs = printRoleIDsFromNames(toString(u["roleNames"]))
-		}
+	}
+	if oriEmail == "" {
+		oriEmail = strings.TrimSpace(toString(u["email"]))
+	}
+	if len(roleIDs) == 0 {
+		roleIDs = printUserRoleIDs(u)
 	}
 
-	if name == "" || fullname == "" || sex == "" || status == "" || section == "" {
+	if name == "" || fullname == "" || sex == "" || status == "" || (section == "" && deptID == "") {
 		return projectResult{OK: false, Message: "修改用户失败", Error: "必填项不能为空"}
 	}
 	if sex != "male" && sex != "female" && sex != "unknown" {
@@ -507,15 +1002,12 @@ func printModifyUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	if len(roleIDs) == 0 {
 		return projectResult{OK: false, Message: "修改用户失败", Error: "角色不能为空"}
 	}
-	deptID, err := printDeptID(ctx, section)
+	dept, err := printResolveDept(ctx, deptID, section)
 	if err != nil {
 		return projectResult{OK: false, Message: "修改用户失败", Error: err.Error()}
 	}
-	if deptID == "" {
-		return projectResult{OK: false, Message: "修改用户失败", Error: "未找到对应部门"}
-	}
 
-	tok, _ := printOnceToken(ctx.csrfToken)
+	tok, _ := printOnceToken(ctx.token())
 	nameEnc, _ := printEncryptAES(name)
 	fullEnc, _ := printEncryptAES(fullname)
 	payload := url.Values{}
@@ -525,14 +1017,11 @@ func printModifyUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	payload.Set("sex", sex)
 	payload.Set("email", email)
 	payload.Set("status", status)
-	payload.Set("dept", deptID)
-	payload.Set("defaultlang", "zh_CN")
-	payload.Set("docsecuritylevel", "public")
+	payload.Set("dept", dept.ID)
 	payload.Set("roleIds", strings.Join(roleIDs, ","))
-	payload.Set("isauditor", "false")
 	payload.Set("id", userID)
-	payload.Set("userAuthStr", "[]")
 	payload.Set("oriEmail", oriEmail)
+	printSetSaveExtras(payload, u)
 	resp, err := postForm(ctx.client, printEndpoint("api/right/user/save"), payload)
 	if err != nil {
 		return projectResult{OK: false, Message: "修改用户失败", Error: err.Error()}
@@ -563,7 +1052,7 @@ func printDeleteUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	if u == nil {
 		return projectResult{OK: false, Message: "删除用户失败", Error: "用户不存在"}
 	}
-	tok, _ := printOnceToken(ctx.csrfToken)
+	tok, _ := printOnceToken(ctx.token())
 	payload := url.Values{}
 	payload.Set("csrftoken", tok)
 	payload.Set("id", toString(u["id"]))
@@ -584,9 +1073,253 @@ func printDeleteUser(ctx *printCtx, p map[string]interface{}) projectResult {
 	return projectResult{OK: false, Message: "删除用户失败", Error: toString(data["msg"]), Data: map[string]interface{}{"raw": data}}
 }
 
+var printBatchTemplates = map[string]batchTemplate{
+	"batch_add_users": {
+		Title:    "批量新增",
+		FileName: "打印批量新增用户模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name"}},
+			{Key: "fullname", Names: []string{"姓名", "fullname"}},
+			{Key: "sex", Names: []string{"性别", "sex"}},
+			{Key: "email", Names: []string{"邮箱", "email"}},
+			{Key: "section", Names: []string{"部门", "section", "dept"}},
+			{Key: "password", Names: []string{"密码", "password"}},
+		},
+	},
+	"batch_delete_users": {
+		Title:    "批量删除",
+		FileName: "打印批量删除用户模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name"}},
+		},
+	},
+	"batch_reset_password": {
+		Title:    "批量重置密码",
+		FileName: "打印批量重置密码模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name"}},
+			{Key: "password", Names: []string{"新密码", "password"}},
+		},
+	},
+	"batch_set_status": {
+		Title:    "批量修改状态",
+		FileName: "打印批量修改状态模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name"}},
+			{Key: "status", Names: []string{"状态", "status"}},
+		},
+	},
+	"batch_assign_roles": {
+		Title:    "批量分配角色",
+		FileName: "打印批量分配角色模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name"}},
+			{Key: "roles", Names: []string{"角色", "roles"}},
+		},
+	},
+}
+
+func printBatchTemplate(action string) (batchTemplate, error) {
+	if tpl, ok := printBatchTemplates[strings.TrimSpace(action)]; ok {
+		return tpl, nil
+	}
+	return batchTemplate{}, fmt.Errorf("%w: %s", ErrBatchActionUnsupported, action)
+}
+
+func printNormalizeSex(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "男", "male", "m":
+		return "male"
+	case "女", "female", "f":
+		return "female"
+	default:
+		return "unknown"
+	}
+}
+
+// printBatchRun applies one to every record of the batch file and streams a
+// progress line per row. one returns the row result and the password to report.
+func printBatchRun(p map[string]interface{}, action string, one func(m map[string]interface{}) (projectResult, string)) projectResult {
+	tpl, err := printBatchTemplate(action)
+	if err != nil {
+		return projectResult{OK: false, Message: "批量操作失败", Error: err.Error()}
+	}
+	return batchRun("print", tpl, p, one)
+}
+
+func printBatchAddUsers(ctx *printCtx, p map[string]interface{}) projectResult {
+	defaultPwd := strings.TrimSpace(toString(p["default_password"]))
+	return printBatchRun(p, "batch_add_users", func(m map[string]interface{}) (projectResult, string) {
+		pwd := strings.TrimSpace(toString(m["password"]))
+		if pwd == "" {
+			pwd = defaultPwd
+		}
+		if pwd == "" {
+			pwd = randomPassword()
+		}
+		row := map[string]interface{}{
+			"name":     m["name"],
+			"fullname": m["fullname"],
+			"sex":      printNormalizeSex(toString(m["sex"])),
+			"email":    m["email"],
+			"section":  m["section"],
+			"password": pwd,
+		}
+		return printAddUser(ctx, row), pwd
+	})
+}
+
+func printBatchDeleteUsers(ctx *printCtx, p map[string]interface{}) projectResult {
+	return printBatchRun(p, "batch_delete_users", func(m map[string]interface{}) (projectResult, string) {
+		return printDeleteUser(ctx, map[string]interface{}{"search_key": "username", "search_content": m["name"]}), ""
+	})
+}
+
+func printBatchResetPassword(ctx *printCtx, p map[string]interface{}) projectResult {
+	defaultPwd := strings.TrimSpace(toString(p["default_password"]))
+	return printBatchRun(p, "batch_reset_password", func(m map[string]interface{}) (projectResult, string) {
+		pwd := strings.TrimSpace(toString(m["password"]))
+		if pwd == "" {
+			pwd = defaultPwd
+		}
+		if pwd == "" {
+			pwd = randomPassword()
+		}
+		res := printResetPassword(ctx, map[string]interface{}{"search_key": "username", "search_content": m["name"], "password": pwd})
+		return res, pwd
+	})
+}
+
+func printBatchAssignRoles(ctx *printCtx, p map[string]interface{}) projectResult {
+	return printBatchRun(p, "batch_assign_roles", func(m map[string]interface{}) (projectResult, string) {
+		roleIDs := make([]interface{}, 0, 4)
+		for _, one := range strings.FieldsFunc(toString(m["roles"]), func(r rune) bool {
+			return r == '|' || r == ',' || r == '，' || r == '、' || r == ';'
+		}) {
+			name := strings.TrimSpace(one)
+			if name == "" {
+				continue
+			}
+			if id := printRoleNameToID[name]; id != "" {
+				roleIDs = append(roleIDs, id)
+				continue
+			}
+			known := false
+			for _, id := range printRoleNameToID {
+				if id == name {
+					known = true
+				}
+			}
+			if !known {
+				return projectResult{OK: false, Message: "分配角色失败", Error: "未知角色：" + name}, ""
+			}
+			roleIDs = append(roleIDs, name)
+		}
+		if len(roleIDs) == 0 {
+			return projectResult{OK: false, Message: "分配角色失败", Error: "角色不能为空"}, ""
+		}
+		return printModifyUser(ctx, map[string]interface{}{"search_key": "username", "search_content": m["name"], "roles": roleIDs}), ""
+	})
+}
+
+func printNormalizeStatus(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "enabled", "enable", "启用", "正常":
+		return "enabled"
+	case "disabled", "disable", "禁用", "停用":
+		return "disabled"
+	default:
+		return ""
+	}
+}
+
+// printSetStatus changes only the status of a user. The server has no
+// status endpoint, so the row is saved back exactly as it was read, with the
+// new status; unlike printModifyUser nothing else is checked or defaulted, so
+// a toggle cannot fail on, or rewrite, the department or roles.
+func printSetStatus(ctx *printCtx, p map[string]interface{}) projectResult {
+	key := printAllowedSearchKey(toStringDefault(p["search_key"], "username"))
+	value := strings.TrimSpace(toString(p["search_content"]))
+	if value == "" {
+		return projectResult{OK: false, Message: "修改状态失败", Error: "查询值不能为空"}
+	}
+	status := printNormalizeStatus(toString(p["status"]))
+	if status == "" {
+		return projectResult{OK: false, Message: "修改状态失败", Error: "状态参数不正确"}
+	}
+	u, err := printFindUser(ctx, key, value)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
+	}
+	if u == nil {
+		return projectResult{OK: false, Message: "修改状态失败", Error: "用户不存在"}
+	}
+	deptID := printUserDeptID(u)
+	if deptID == "" {
+		// Rows without a department id name it by path only.
+		dept, err := printResolveDept(ctx, "", strings.TrimSpace(toString(u["dept.name"])))
+		if err != nil {
+			return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
+		}
+		deptID = dept.ID
+	}
+	name := strings.TrimSpace(toString(u["name"]))
+	email := strings.TrimSpace(toString(u["email"]))
+	tok, _ := printOnceToken(ctx.token())
+	nameEnc, _ := printEncryptAES(name)
+	fullEnc, _ := printEncryptAES(strings.TrimSpace(toString(u["fullname"])))
+	payload := url.Values{}
+	payload.Set("csrftoken", tok)
+	payload.Set("name", nameEnc)
+	payload.Set("fullname", fullEnc)
+	payload.Set("sex", printFieldValue(u["sex"]))
+	payload.Set("email", email)
+	payload.Set("status", status)
+	payload.Set("dept", deptID)
+	payload.Set("roleIds", strings.Join(printUserRoleIDs(u), ","))
+	payload.Set("id", toString(u["id"]))
+	payload.Set("oriEmail", email)
+	printSetSaveExtras(payload, u)
+	resp, err := postForm(ctx.client, printEndpoint("api/right/user/save"), payload)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return projectResult{OK: false, Message: "修改状态失败", Error: "HTTP请求失败"}
+	}
+	data, err := decodeRespJSON(resp)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
+	}
+	if toInt(data["code"]) != 0 {
+		return projectResult{OK: false, Message: "修改状态失败", Error: toString(data["msg"]), Data: map[string]interface{}{"raw": data}}
+	}
+	statusText := "启用"
+	if status == "disabled" {
+		statusText = "禁用"
+	}
+	return projectResult{OK: true, Message: "修改状态成功", Data: map[string]interface{}{
+		"raw":      data,
+		"status":   status,
+		"log_text": fmt.Sprintf("用户：%s\n状态：%s", name, statusText),
+	}}
+}
+
+func printBatchSetStatus(ctx *printCtx, p map[string]interface{}) projectResult {
+	defaultStatus := toString(p["status"])
+	return printBatchRun(p, "batch_set_status", func(m map[string]interface{}) (projectResult, string) {
+		status := toString(m["status"])
+		if strings.TrimSpace(status) == "" {
+			status = defaultStatus
+		}
+		return printSetStatus(ctx, map[string]interface{}{"search_key": "username", "search_content": m["name"], "status": status}), ""
+	})
+}
+
 func printOnceToken(csrf string) (string, error) {
 	ts := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
-	enc, err := aesECBEncryptBase64(csrf+"_"+ts, "abcdefgabcdefg12")
+	enc, err := aesECBEncryptBase64(csrf+"_"+ts, runtimeCfg.PrintAESKey)
 	if err != nil {
 		return "", err
 	}
@@ -594,7 +1327,7 @@ func printOnceToken(csrf string) (string, error) {
 }
 
 func printEncryptAES(text string) (string, error) {
-	return aesECBEncryptBase64(text, "abcdefgabcdefg12")
+	return aesECBEncryptBase64(text, runtimeCfg.PrintAESKey)
 }
 
 func aesECBEncryptBase64(plaintext, key string) (string, error) {
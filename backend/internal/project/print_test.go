@@ -0,0 +1,271 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sync"
+	"testing"
+
+	"ops-admin-backend/internal/simulate"
+)
+
+// newTestPrintCtx points the print client at handler and returns a context
+// logged in with a test key.
+func newTestPrintCtx(t *testing.T, handler http.Handler) *printCtx {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	saved := runtimeCfg
+	runtimeCfg.PrintAPIURL = srv.URL
+	runtimeCfg.PrintAESKey = "0123456789abcdef"
+	t.Cleanup(func() { runtimeCfg = saved })
+	return &printCtx{client: srv.Client(), csrfToken: "test-csrf"}
+}
+
+func printTestUsers(from, n int) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, n)
+	for i := from; i < from+n; i++ {
+		rows = append(rows, map[string]interface{}{"id": fmt.Sprintf("id%03d", i), "name": fmt.Sprintf("user%03d", i)})
+	}
+	return rows
+}
+
+func TestPrintEachUserStopsOnRepeatedPage(t *testing.T) {
+	queries := 0
+	ctx := newTestPrintCtx(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		// The server ignores pageNum and keeps answering with the first page.
+		writeTestJSON(w, map[string]interface{}{"data": printTestUsers(0, printUserPageSize)})
+	}))
+	visited := 0
+	total, err := printEachUser(ctx, "username", "", func(map[string]interface{}) bool {
+		visited++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 || visited != printUserPageSize || total != printUserPageSize {
+		t.Fatalf("queries=%d visited=%d total=%d, want 2/%d/%d", queries, visited, total, printUserPageSize, printUserPageSize)
+	}
+}
+
+func TestPrintEachUserStopsAtServerTotal(t *testing.T) {
+	queries := 0
+	ctx := newTestPrintCtx(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		_ = r.ParseForm()
+		page := 1
+		fmt.Sscan(r.PostForm.Get("pageNum"), &page)
+		writeTestJSON(w, map[string]interface{}{"total": 2 * printUserPageSize, "data": printTestUsers((page-1)*printUserPageSize, printUserPageSize)})
+	}))
+	visited := 0
+	if _, err := printEachUser(ctx, "username", "", func(map[string]interface{}) bool {
+		visited++
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 || visited != 2*printUserPageSize {
+		t.Fatalf("queries=%d visited=%d, want 2/%d", queries, visited, 2*printUserPageSize)
+	}
+}
+
+func TestPrintFetchDeptsStopsOnRepeatedPage(t *testing.T) {
+	queries := 0
+	ctx := newTestPrintCtx(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		// The server ignores page and keeps answering with the first page.
+		rows := make([]map[string]interface{}, 0, 500)
+		for i := 0; i < 500; i++ {
+			rows = append(rows, map[string]interface{}{"id": fmt.Sprintf("d%03d", i), "name": fmt.Sprintf("部门%03d", i)})
+		}
+		writeTestJSON(w, map[string]interface{}{"data": rows})
+	}))
+	depts, err := printFetchDepts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 || len(depts) != 500 {
+		t.Fatalf("queries=%d depts=%d, want 2/500", queries, len(depts))
+	}
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestPrintSetStatusKeepsUntouchedFields(t *testing.T) {
+	row := map[string]interface{}{
+		"id":               "7",
+		"name":             "lisi",
+		"fullname":         "李四",
+		"email":            "lisi@example.com",
+		"sex":              map[string]interface{}{"value": "female"},
+		"status":           map[string]interface{}{"value": "enabled"},
+		"dept.id":          "d2",
+		"roleIds":          "13a8c61c6888a4c,36b238261872cd10208",
+		"defaultlang":      "en_US",
+		"docsecuritylevel": map[string]interface{}{"value": "secret"},
+		"isauditor":        "true",
+		"userAuthStr":      `[{"auth":"scan"}]`,
+	}
+	var saved url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/right/user/query", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{"data": []interface{}{row}, "total": 1})
+	})
+	mux.HandleFunc("/api/right/user/save", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		saved = r.PostForm
+		writeTestJSON(w, map[string]interface{}{"code": 0})
+	})
+	ctx := newTestPrintCtx(t, mux)
+	ctx.depts = []printDept{{ID: "d2", Name: "研发部", Path: `总部\研发部`}}
+
+	res := printSetStatus(ctx, map[string]interface{}{"search_key": "username", "search_content": "lisi", "status": "disabled"})
+	if !res.OK {
+		t.Fatalf("set_status failed: %s", res.Error)
+	}
+	if saved == nil {
+		t.Fatal("no save request")
+	}
+	want := map[string]string{
+		"id":               "7",
+		"sex":              "female",
+		"email":            "lisi@example.com",
+		"oriEmail":         "lisi@example.com",
+		"status":           "disabled",
+		"dept":             "d2",
+		"roleIds":          "13a8c61c6888a4c,36b238261872cd10208",
+		"defaultlang":      "en_US",
+		"docsecuritylevel": "secret",
+		"isauditor":        "true",
+		"userAuthStr":      `[{"auth":"scan"}]`,
+	}
+	for key, v := range want {
+		if got := saved.Get(key); got != v {
+			t.Errorf("%s = %q, want %q", key, got, v)
+		}
+	}
+	for key, v := range map[string]string{"name": "lisi", "fullname": "李四"} {
+		enc, _ := printEncryptAES(v)
+		if saved.Get(key) != enc {
+			t.Errorf("%s not sent back unchanged", key)
+		}
+	}
+}
+
+func TestPrintModifyUserDefaultsOnlyMissingExtras(t *testing.T) {
+	row := map[string]interface{}{
+		"id": "7", "name": "lisi", "fullname": "李四", "email": "lisi@example.com",
+		"sex": "male", "status": "enabled", "dept.id": "d2", "roleIds": "12483a1e79473e4",
+		"docsecuritylevel": "internal",
+	}
+	var saved url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/right/user/query", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{"data": []interface{}{row}, "total": 1})
+	})
+	mux.HandleFunc("/api/right/user/save", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		saved = r.PostForm
+		writeTestJSON(w, map[string]interface{}{"code": 0})
+	})
+	ctx := newTestPrintCtx(t, mux)
+	ctx.depts = []printDept{{ID: "d2", Name: "研发部", Path: `总部\研发部`}}
+
+	res := printModifyUser(ctx, map[string]interface{}{"user_id": "7", "ori_email": "lisi@example.com", "email": "li.si@example.com"})
+	if !res.OK {
+		t.Fatalf("modify_user failed: %s", res.Error)
+	}
+	for key, v := range map[string]string{"email": "li.si@example.com", "status": "enabled", "docsecuritylevel": "internal", "defaultlang": "zh_CN", "isauditor": "false"} {
+		if got := saved.Get(key); got != v {
+			t.Errorf("%s = %q, want %q", key, got, v)
+		}
+	}
+}
+
+func TestPrintRetriesOnlyTheTimedOutRequest(t *testing.T) {
+	sim, err := simulate.NewPrintServer("0123456789abcdef", "test-bootstrap", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[path.Base(r.URL.Path)]++
+		first := path.Base(r.URL.Path) == "setDefPwd" && hits["setDefPwd"] == 1
+		mu.Unlock()
+		if first {
+			// The login times out between looking the user up and saving.
+			sim.ExpireSessions()
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	saved := runtimeCfg
+	runtimeCfg.PrintAPIURL = srv.URL + "/printhub"
+	runtimeCfg.PrintAESKey = "0123456789abcdef"
+	runtimeCfg.PrintBootstrapCSRF = "test-bootstrap"
+	t.Cleanup(func() { runtimeCfg = saved })
+
+	ctx, err := printLogin("admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := printOperate(ctx, "reset_password", map[string]interface{}{"search_key": "username", "search_content": "zhangsan", "password": "new-pass"})
+	if !res.OK {
+		t.Fatalf("reset_password failed: %+v", res)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["login"] != 2 || hits["query"] != 1 || hits["setDefPwd"] != 2 {
+		t.Fatalf("hits=%v, want one relogin, one query and setDefPwd sent twice", hits)
+	}
+	for _, u := range sim.Users() {
+		if u.Name == "zhangsan" && u.Password != "new-pass" {
+			t.Fatalf("password = %q, want new-pass", u.Password)
+		}
+	}
+}
+
+func TestPrintDoesNotReloginOnOtherErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"code":1,"msg":"csrf token invalid"}`,
+		`{"code":1,"msg":"没有权限，请联系管理员"}`,
+		`<html><body>please login</body></html>`,
+	} {
+		logins := 0
+		ctx := newTestPrintCtx(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if path.Base(r.URL.Path) == "login" {
+				logins++
+			}
+			_, _ = w.Write([]byte(body))
+		}))
+		ctx.client.Transport = &printAuthTransport{ctx: ctx}
+		_, _ = printFindUser(ctx, "username", "zhangsan")
+		if logins != 0 {
+			t.Fatalf("body %s: logged in %d times, want 0", body, logins)
+		}
+	}
+}
+
+func TestPrintLoginRequiresConfiguredSecrets(t *testing.T) {
+	saved := runtimeCfg
+	t.Cleanup(func() { runtimeCfg = saved })
+	runtimeCfg.PrintAESKey, runtimeCfg.PrintBootstrapCSRF = "", "bootstrap"
+	if _, err := printLogin("admin", "secret"); err == nil || err.Error() != "PRINT_AES_KEY 未配置" {
+		t.Fatalf("empty key: err = %v", err)
+	}
+	runtimeCfg.PrintAESKey, runtimeCfg.PrintBootstrapCSRF = "0123456789abcdef", ""
+	if _, err := printLogin("admin", "secret"); err == nil || err.Error() != "PRINT_BOOTSTRAP_CSRF 未配置" {
+		t.Fatalf("empty bootstrap: err = %v", err)
+	}
+}
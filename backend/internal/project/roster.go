@@ -0,0 +1,101 @@
+package project
+
+import (
+	"fmt"
+)
+
+// An HR roster lists the active employees. Planning compares it with the full
+// account lists of AD, print and VPN: employees without an account get a
+// create item, accounts nobody on the roster owns get the offboarding policy
+// (disable, delete or keep), and accounts whose name, email or department
+// drifted from the roster get an update item. Items are approved one by one
+// and AccountPlanStep turns each into an ordinary project action.
+
+// RosterSystems lists the systems a roster is planned against.
+var RosterSystems = AccountPlanSystems
+
+var rosterTemplate = batchTemplate{
+	Title:    "花名册",
+	FileName: "HR花名册模板.xlsx",
+	Fields: []batchField{
+		{Key: "username", Names: []string{"用户名", "username", "account"}},
+		{Key: "name", Names: []string{"姓名", "name", "fullname", "cn"}},
+		{Key: "email", Names: []string{"邮箱", "email", "mail"}},
+		{Key: "department", Names: []string{"部门", "department", "dept"}},
+	},
+}
+
+// RosterRecords reads the roster and checks every row. Rows that cannot be
+// planned (missing fields, a bad email, a username or email already used by
+// an earlier row) are left out and described in problems.
+func RosterRecords(p map[string]interface{}) ([]map[string]interface{}, []string, Result, bool) {
+	records, failRes, ok := batchRecordsFromParams("roster", p, func(rows [][]string) ([]map[string]interface{}, error) {
+		return batchParseRows(rows, rosterTemplate.Fields), nil
+	})
+	if !ok {
+		return nil, nil, failRes, false
+	}
+	valid := make([]map[string]interface{}, 0, len(records))
+	problems := make([]string, 0)
+	seen := map[string]int{}
+	for i, m := range records {
+		row := toInt(m["__row"])
+		if row <= 0 {
+			row = i + 1
+			m["__row"] = row
+		}
+		if err := OnboardCheck(m); err != nil {
+			problems = append(problems, fmt.Sprintf("第 %d 行：%s", row, err.Error()))
+			continue
+		}
+		dup := 0
+		for _, key := range []string{"u:" + accountKey(m["username"]), "m:" + accountKey(m["email"])} {
+			if prev, ok := seen[key]; ok && dup == 0 {
+				dup = prev
+			}
+		}
+		if dup > 0 {
+			problems = append(problems, fmt.Sprintf("第 %d 行：用户名或邮箱与第 %d 行重复", row, dup))
+			continue
+		}
+		seen["u:"+accountKey(m["username"])] = row
+		seen["m:"+accountKey(m["email"])] = row
+		valid = append(valid, m)
+	}
+	return valid, problems, Result{}, true
+}
+
+// RosterPlan compares roster with the account lists of systems. policy maps
+// a system to the offboarding action applied to accounts not on the roster;
+// protected accounts are kept whatever the policy.
+func RosterPlan(roster []map[string]interface{}, systems map[string][]PersonAccount, policy map[string]string, protected ProtectedAccounts) []AccountPlanItem {
+	desired := make([]DesiredAccount, 0, len(roster))
+	for _, m := range roster {
+		desired = append(desired, DesiredAccount{
+			Row:        toInt(m["__row"]),
+			Username:   toString(m["username"]),
+			Name:       toString(m["name"]),
+			Email:      toString(m["email"]),
+			Department: toString(m["department"]),
+		})
+	}
+	unwanted := make(map[string]string, len(RosterSystems))
+	for _, system := range RosterSystems {
+		unwanted[system] = policy[system]
+	}
+	items := PlanAccounts(desired, systems, unwanted, protected)
+	for i := range items {
+		if items[i].Row > 0 {
+			continue
+		}
+		switch {
+		case items[i].Protected:
+			items[i].Note = "不在花名册中，受保护账号保留"
+		case items[i].Op == AccountKeep:
+			items[i].Note = "不在花名册中，离职策略为保留"
+		default:
+			items[i].Note = "不在花名册中"
+		}
+	}
+	return items
+}
@@ -5,8 +5,6 @@ import (
 	"net/http"
 	"strings"
 	"sync"
-
-	"golang.org/x/crypto/ssh"
 )
 
 type Session interface {
@@ -26,6 +24,8 @@ func (s *adSession) Close() error {
 	return nil
 }
 
+// printSession holds one print server login. The transport logs in again
+// when the server reports a timeout, so an action never has to be replayed.
 type printSession struct {
 	ctx *printCtx
 }
@@ -38,74 +38,52 @@ func (s *printSession) Close() error {
 	return nil
 }
 
+// vpnSession keeps one connection and one interactive shell to an SSH device
+// (the gateway or the firewall); actions run their commands on it one after
+// another.
 type vpnSession struct {
 	mu       sync.Mutex
-	username string
-	password string
-	host     string
-	client   *ssh.Client
+	ctx      *vpnCtx
+	dispatch vpnDispatchFunc
 }
 
-func newVPNSession(username, password, host string) (*vpnSession, error) {
-	client, err := vpnLogin(username, password, host, 22)
+func newVPNSession(cred Credential, dev sshDevice, dispatch vpnDispatchFunc) (*vpnSession, error) {
+	ctx, err := newVPNCtx(dev, cred)
 	if err != nil {
 		return nil, err
 	}
-	return &vpnSession{
-		username: username,
-		password: password,
-		host:     host,
-		client:   client,
-	}, nil
+	return &vpnSession{ctx: ctx, dispatch: dispatch}, nil
 }
 
+// Operate runs action once. When the connection broke underneath it, the
+// session reconnects for the next action but does not run this one again:
+// part of it may already have reached the device, and read-only commands
+// are already resent by vpnCtx.exec.
 func (s *vpnSession) Operate(action string, params map[string]interface{}) (projectResult, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := s.ensureClientLocked(); err != nil {
-		return projectResult{}, err
-	}
-
-	result := vpnOperate(s.client, action, params)
-	if !shouldReconnectVPNResult(result) {
-		return result, nil
+	result := runVPNAction(s.ctx, action, params, s.dispatch)
+	if shouldReconnectVPNResult(result) {
+		_ = s.reconnectLocked()
 	}
-
-	if err := s.reconnectLocked(); err != nil {
-		return result, nil
-	}
-	return vpnOperate(s.client, action, params), nil
+	return result, nil
 }
 
 func (s *vpnSession) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.client == nil {
-		return nil
-	}
-	err := s.client.Close()
-	s.client = nil
-	return err
-}
-
-func (s *vpnSession) ensureClientLocked() error {
-	if s.client != nil {
-		return nil
-	}
-	return s.reconnectLocked()
+	return s.ctx.close()
 }
 
+// reconnectLocked swaps in a new SSH client; the cached section list is kept.
 func (s *vpnSession) reconnectLocked() error {
-	if s.client != nil {
-		_ = s.client.Close()
-		s.client = nil
-	}
-	client, err := vpnLogin(s.username, s.password, s.host, 22)
+	_ = s.ctx.close()
+	client, err := s.ctx.dial()
 	if err != nil {
 		return err
 	}
-	s.client = client
+	s.ctx.client = client
 	return nil
 }
 
@@ -136,7 +114,8 @@ func shouldReconnectVPNResult(res projectResult) bool {
 	return false
 }
 
-func OpenSession(projectType, username, password string) (Session, string, error) {
+func OpenSession(projectType string, cred Credential) (Session, string, error) {
+	username, password := cred.Account, cred.Password
 	switch projectType {
 	case "ad":
 		client, err := adLogin(username, password)
@@ -151,11 +130,17 @@ func OpenSession(projectType, username, password string) (Session, string, error
 		}
 		return &printSession{ctx: ctx}, "打印管理登录成功", nil
 	case "vpn":
-		session, err := newVPNSession(username, password, runtimeCfg.VPNSshAddr)
+		session, err := newVPNSession(cred, vpnDevice(), vpnDispatch)
 		if err != nil {
 			return nil, "VPN 登录失败", err
 		}
 		return session, "VPN 登录成功", nil
+	case "firewall":
+		session, err := newVPNSession(cred, firewallDevice(), firewallDispatch)
+		if err != nil {
+			return nil, "防火墙登录失败", err
+		}
+		return session, "防火墙登录成功", nil
 	default:
 		return nil, "", fmt.Errorf("unknown project type: %s", projectType)
 	}
@@ -0,0 +1,206 @@
+package project
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"ops-admin-backend/internal/simulate"
+)
+
+// These tests drive the real sessions end to end against the in-process
+// simulators, the same fakes SIMULATE=true starts.
+
+var simCred = Credential{Account: "ops", Password: "Ops-pass1"}
+
+func startTestSimulators(t *testing.T) *simulate.Env {
+	t.Helper()
+	env, err := simulate.Start(simulate.Options{
+		PrintAESKey:        "0123456789abcdef",
+		PrintBootstrapCSRF: "sim-bootstrap",
+		Account:            simCred.Account,
+		Password:           simCred.Password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(env.Close)
+	saved := runtimeCfg
+	t.Cleanup(func() { runtimeCfg = saved })
+	runtimeCfg.ADAPIURL = env.ADURL + "/"
+	runtimeCfg.PrintAPIURL = env.PrintURL + "/"
+	runtimeCfg.PrintAESKey = "0123456789abcdef"
+	runtimeCfg.PrintBootstrapCSRF = "sim-bootstrap"
+	runtimeCfg.VPNSshAddr, runtimeCfg.VPNSSHPort = env.VPNAddr, env.VPNPort
+	runtimeCfg.FirewallSSHAddr, runtimeCfg.FirewallSSHPort = env.FirewallAddr, env.FirewallPort
+	useHostKeyStore(t, map[string]string{
+		net.JoinHostPort(env.VPNAddr, strconv.Itoa(env.VPNPort)):           env.HostKeyFingerprint,
+		net.JoinHostPort(env.FirewallAddr, strconv.Itoa(env.FirewallPort)): env.HostKeyFingerprint,
+	})
+	return env
+}
+
+func openTestSession(t *testing.T, projectType string) Session {
+	t.Helper()
+	s, msg, err := OpenSession(projectType, simCred)
+	if err != nil {
+		t.Fatalf("%s: %s: %v", projectType, msg, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func mustOperate(t *testing.T, s Session, action string, params map[string]interface{}) projectResult {
+	t.Helper()
+	res, err := s.Operate(action, params)
+	if err != nil {
+		t.Fatalf("%s: %v", action, err)
+	}
+	if !res.OK {
+		t.Fatalf("%s: %s %s", action, res.Message, res.Error)
+	}
+	return res
+}
+
+func TestSimulatedADSession(t *testing.T) {
+	env := startTestSimulators(t)
+	if _, _, err := OpenSession("ad", Credential{Account: "ops", Password: "wrong"}); err == nil {
+		t.Fatal("AD login with a wrong password succeeded")
+	}
+	s := openTestSession(t, "ad")
+
+	mustOperate(t, s, "add_user", map[string]interface{}{
+		"username": "newhire", "cn": "新人", "sn": "新", "given_name": "人",
+		"email": "newhire@example.com", "ou": "研发部", "password": "Start-pass1",
+	})
+	res := mustOperate(t, s, "search_user", map[string]interface{}{"search_name": "newhire"})
+	if res.Data == nil {
+		t.Fatal("search_user returned no data")
+	}
+	mustOperate(t, s, "reset_password", map[string]interface{}{"name": "newhire", "password": "Next-pass2"})
+	found := false
+	for _, u := range env.AD.Users() {
+		if u.SAMAccountName == "newhire" {
+			found = true
+			if u.Password != "Next-pass2" {
+				t.Fatalf("password = %q, want Next-pass2", u.Password)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("newhire was not created in AD")
+	}
+	mustOperate(t, s, "delete_user", map[string]interface{}{"name": "newhire"})
+	for _, u := range env.AD.Users() {
+		if u.SAMAccountName == "newhire" {
+			t.Fatal("newhire is still in AD after delete_user")
+		}
+	}
+}
+
+func TestSimulatedPrintSession(t *testing.T) {
+	env := startTestSimulators(t)
+	s := openTestSession(t, "print")
+
+	mustOperate(t, s, "add_user", map[string]interface{}{
+		"name": "newhire", "fullname": "新人", "sex": "male", "password": "123",
+		"email": "newhire@example.com", "section": "研发部",
+	})
+	// The login times out between two actions; the session carries on.
+	env.Print.ExpireSessions()
+	mustOperate(t, s, "reset_password", map[string]interface{}{"search_key": "username", "search_content": "newhire", "password": "456"})
+	var created *simulate.PrintUser
+	for _, u := range env.Print.Users() {
+		if u.Name == "newhire" {
+			created = &u
+		}
+	}
+	if created == nil || created.Fullname != "新人" || created.DeptID != "2" || created.Password != "456" {
+		t.Fatalf("print user = %+v", created)
+	}
+	mustOperate(t, s, "delete_user", map[string]interface{}{"search_key": "username", "search_content": "newhire"})
+	for _, u := range env.Print.Users() {
+		if u.Name == "newhire" {
+			t.Fatal("newhire is still on the print server after delete_user")
+		}
+	}
+}
+
+func TestSimulatedVPNSession(t *testing.T) {
+	env := startTestSimulators(t)
+	s := openTestSession(t, "vpn")
+
+	add := map[string]interface{}{
+		"vpn_user": "newhire", "passwd": "Start-pass1", "description": "新人",
+		"mail": "newhire@example.com", "status": "enabled", "section": "dev^it^root",
+		"remote_firewall": true, "__vpn_fw_configured": true,
+	}
+	SSHCredentialParams(add, "__vpn_fw_", simCred)
+	mustOperate(t, s, "add_user", add)
+	for name, users := range map[string][]simulate.CLIUser{"gateway": env.VPN.Users(), "firewall": env.Firewall.Users()} {
+		if one := findCLIUser(users, "newhire"); one == nil || one.Group != "dev^it^root" || one.Password != "Start-pass1" {
+			t.Fatalf("%s user = %+v", name, one)
+		}
+	}
+
+	mustOperate(t, s, "modify_status", map[string]interface{}{"vpn_user": "newhire", "status": "disabled"})
+	if one := findCLIUser(env.VPN.Users(), "newhire"); one == nil || !one.Invalid {
+		t.Fatalf("gateway user after disable = %+v", one)
+	}
+
+	// delete asks for confirmation on the device; the shell must answer it.
+	mustOperate(t, s, "delete_users", map[string]interface{}{"vpn_users": []interface{}{"newhire"}})
+	if one := findCLIUser(env.VPN.Users(), "newhire"); one != nil {
+		t.Fatalf("newhire is still on the gateway: %+v", one)
+	}
+
+	fw := openTestSession(t, "firewall")
+	res := mustOperate(t, fw, "search_user", map[string]interface{}{"search_key": "name", "search_content": "newhire"})
+	if res.Data == nil {
+		t.Fatal("firewall search returned no data")
+	}
+}
+
+func findCLIUser(users []simulate.CLIUser, name string) *simulate.CLIUser {
+	for i := range users {
+		if users[i].Name == name {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+func TestSimulatedVPNDisableKicksForAnyDisabledStatus(t *testing.T) {
+	env := startTestSimulators(t)
+	s := openTestSession(t, "vpn")
+
+	res := mustOperate(t, s, "modify_status", map[string]interface{}{"vpn_user": "zhangsan", "status": "禁用", "kick_online": true})
+	if res.Data["kick_ok"] != true {
+		t.Fatalf("kick_ok = %v, error %v", res.Data["kick_ok"], res.Data["kick_error"])
+	}
+	if one := findCLIUser(env.VPN.Users(), "zhangsan"); one == nil || !one.Invalid {
+		t.Fatalf("gateway user after disable = %+v", one)
+	}
+	for _, one := range env.VPN.Sessions() {
+		if one.User == "zhangsan" {
+			t.Fatalf("zhangsan is still online: %+v", one)
+		}
+	}
+}
+
+func TestSimulatedPrintSetStatusKeepsDeptAndRoles(t *testing.T) {
+	env := startTestSimulators(t)
+	s := openTestSession(t, "print")
+
+	mustOperate(t, s, "set_status", map[string]interface{}{"search_key": "username", "search_content": "lisi", "status": "禁用"})
+	var lisi *simulate.PrintUser
+	for _, u := range env.Print.Users() {
+		if u.Name == "lisi" {
+			lisi = &u
+		}
+	}
+	if lisi == nil || lisi.Status != "disabled" || lisi.Fullname != "李四" || lisi.Email != "lisi@old.example.com" || lisi.DeptID != "3" ||
+		len(lisi.RoleIDs) != 2 || lisi.RoleIDs[0] != "12483a1e79473e4" || lisi.RoleIDs[1] != "13a8c61c6888a4c" {
+		t.Fatalf("print user after set_status = %+v", lisi)
+	}
+}
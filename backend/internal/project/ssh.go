@@ -0,0 +1,147 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	SSHAuthPassword            = "password"
+	SSHAuthPublicKey           = "publickey"
+	SSHAuthKeyboardInteractive = "keyboard-interactive"
+)
+
+// NormalizeSSHAuthMethod maps a stored or submitted auth method to one of the
+// SSHAuth* constants. An empty value means password authentication.
+func NormalizeSSHAuthMethod(method string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(method)) {
+	case "", "password":
+		return SSHAuthPassword, true
+	case "publickey", "key", "private_key":
+		return SSHAuthPublicKey, true
+	case "keyboard-interactive", "keyboard_interactive", "interactive":
+		return SSHAuthKeyboardInteractive, true
+	default:
+		return "", false
+	}
+}
+
+type sshDevice struct {
+	Host string
+	Port int
+}
+
+func (d sshDevice) addr() string {
+	port := d.Port
+	if port <= 0 {
+		port = 22
+	}
+	return net.JoinHostPort(d.Host, strconv.Itoa(port))
+}
+
+func vpnDevice() sshDevice {
+	return sshDevice{Host: runtimeCfg.VPNSshAddr, Port: runtimeCfg.VPNSSHPort}
+}
+
+func firewallDevice() sshDevice {
+	return sshDevice{Host: runtimeCfg.FirewallSSHAddr, Port: runtimeCfg.FirewallSSHPort}
+}
+
+// sshAuthMethods builds the client auth for cred. Keyboard-interactive answers
+// every challenge with the stored password, which is what the gateways' PAM
+// prompts expect.
+func sshAuthMethods(cred Credential) ([]ssh.AuthMethod, error) {
+	method, ok := NormalizeSSHAuthMethod(cred.AuthMethod)
+	if !ok {
+		return nil, fmt.Errorf("不支持的 SSH 认证方式：%s", cred.AuthMethod)
+	}
+	switch method {
+	case SSHAuthPublicKey:
+		if strings.TrimSpace(cred.PrivateKey) == "" {
+			return nil, errors.New("SSH 私钥未配置")
+		}
+		var signer ssh.Signer
+		var err error
+		if cred.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(cred.PrivateKey), []byte(cred.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(cred.PrivateKey))
+		}
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, errors.New("SSH 私钥已加密，请填写私钥口令")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析 SSH 私钥失败：%w", err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	case SSHAuthKeyboardInteractive:
+		password := cred.Password
+		return []ssh.AuthMethod{ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answers[i] = password
+			}
+			return answers, nil
+		})}, nil
+	default:
+		return []ssh.AuthMethod{ssh.Password(cred.Password)}, nil
+	}
+}
+
+func sshDial(dev sshDevice, cred Credential) (*ssh.Client, error) {
+	if strings.TrimSpace(dev.Host) == "" {
+		return nil, errors.New("SSH 主机地址未配置")
+	}
+	auth, err := sshAuthMethods(cred)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ssh.ClientConfig{
+		User:            cred.Account,
+		Auth:            auth,
+		HostKeyCallback: sshHostKeyCallback,
+		Timeout:         10 * time.Second,
+	}
+	return ssh.Dial("tcp", dev.addr(), cfg)
+}
+
+// sshCredentialFromParams reads a credential the runtime injected into the
+// action params under prefix (e.g. "__vpn_fw_").
+func sshCredentialFromParams(p map[string]interface{}, prefix string) Credential {
+	return Credential{
+		Account:    strings.TrimSpace(toString(p[prefix+"account"])),
+		Password:   strings.TrimSpace(toString(p[prefix+"password"])),
+		PrivateKey: toString(p[prefix+"private_key"]),
+		Passphrase: toString(p[prefix+"passphrase"]),
+		AuthMethod: toString(p[prefix+"auth_method"]),
+	}
+}
+
+// SSHCredentialParams is the inverse of sshCredentialFromParams, used by the
+// runtime to pass SSH credentials to an action.
+func SSHCredentialParams(params map[string]interface{}, prefix string, cred Credential) {
+	params[prefix+"account"] = cred.Account
+	params[prefix+"password"] = cred.Password
+	params[prefix+"private_key"] = cred.PrivateKey
+	params[prefix+"passphrase"] = cred.Passphrase
+	params[prefix+"auth_method"] = cred.AuthMethod
+}
+
+// Usable reports whether cred holds enough material to log in with its auth
+// method.
+func (c Credential) Usable() bool {
+	if strings.TrimSpace(c.Account) == "" {
+		return false
+	}
+	if method, _ := NormalizeSSHAuthMethod(c.AuthMethod); method == SSHAuthPublicKey {
+		return strings.TrimSpace(c.PrivateKey) != ""
+	}
+	return strings.TrimSpace(c.Password) != ""
+}
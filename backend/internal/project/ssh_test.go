@@ -0,0 +1,228 @@
+package project
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// memHostKeyStore is an in-memory HostKeyStore that records what the
+// callback reports.
+type memHostKeyStore struct {
+	mu       sync.Mutex
+	trusted  map[string]string
+	observed []hostKeyObservation
+}
+
+type hostKeyObservation struct {
+	host, fingerprint string
+	changed           bool
+}
+
+func (st *memHostKeyStore) TrustedHostKey(host string) (string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.trusted[host], nil
+}
+
+func (st *memHostKeyStore) ObserveHostKey(host, _, fingerprint string, changed bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.observed = append(st.observed, hostKeyObservation{host, fingerprint, changed})
+	return nil
+}
+
+func useHostKeyStore(t *testing.T, trusted map[string]string) *memHostKeyStore {
+	t.Helper()
+	st := &memHostKeyStore{trusted: trusted}
+	saved := hostKeyStore
+	hostKeyStore = st
+	t.Cleanup(func() { hostKeyStore = saved })
+	return st
+}
+
+func newTestSigner(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, signer
+}
+
+// startTestSSHServer accepts "ops" with password or keyboard-interactive
+// answer "secret", or with the authorized key. It only completes the
+// handshake; sshDial needs nothing more.
+func startTestSSHServer(t *testing.T, hostKey ssh.Signer, authorized ssh.PublicKey) sshDevice {
+	t.Helper()
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pwd []byte) (*ssh.Permissions, error) {
+			if c.User() == "ops" && string(pwd) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err == nil && c.User() == "ops" && len(answers) == 1 && answers[0] == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized != nil && c.User() == "ops" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					_ = ch.Reject(ssh.Prohibited, "no sessions")
+				}
+				sc.Close()
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return sshDevice{Host: "127.0.0.1", Port: addr.Port}
+}
+
+func TestSSHDialPasswordAuth(t *testing.T) {
+	_, hostKey := newTestSigner(t)
+	dev := startTestSSHServer(t, hostKey, nil)
+	useHostKeyStore(t, map[string]string{dev.addr(): ssh.FingerprintSHA256(hostKey.PublicKey())})
+
+	for _, tc := range []struct {
+		name string
+		cred Credential
+		ok   bool
+	}{
+		{"password", Credential{Account: "ops", Password: "secret"}, true},
+		{"wrong password", Credential{Account: "ops", Password: "nope"}, false},
+		{"keyboard-interactive", Credential{Account: "ops", Password: "secret", AuthMethod: "interactive"}, true},
+		{"keyboard-interactive wrong", Credential{Account: "ops", Password: "nope", AuthMethod: "keyboard-interactive"}, false},
+	} {
+		client, err := sshDial(dev, tc.cred)
+		if client != nil {
+			client.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestSSHDialKeyAuth(t *testing.T) {
+	_, hostKey := newTestSigner(t)
+	userKey, userSigner := newTestSigner(t)
+	otherKey, _ := newTestSigner(t)
+	dev := startTestSSHServer(t, hostKey, userSigner.PublicKey())
+	useHostKeyStore(t, map[string]string{dev.addr(): ssh.FingerprintSHA256(hostKey.PublicKey())})
+
+	plain, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(userKey, "", []byte("phrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainPEM := string(pem.EncodeToMemory(plain))
+	encryptedPEM := string(pem.EncodeToMemory(encrypted))
+
+	client, err := sshDial(dev, Credential{Account: "ops", AuthMethod: "key", PrivateKey: plainPEM})
+	if err != nil {
+		t.Fatalf("plain key: %v", err)
+	}
+	client.Close()
+	client, err = sshDial(dev, Credential{Account: "ops", AuthMethod: "publickey", PrivateKey: encryptedPEM, Passphrase: "phrase"})
+	if err != nil {
+		t.Fatalf("encrypted key: %v", err)
+	}
+	client.Close()
+
+	if _, err = sshDial(dev, Credential{Account: "ops", AuthMethod: "publickey", PrivateKey: encryptedPEM}); err == nil || err.Error() != "SSH 私钥已加密，请填写私钥口令" {
+		t.Fatalf("missing passphrase: err = %v", err)
+	}
+	if _, err = sshDial(dev, Credential{Account: "ops", AuthMethod: "publickey"}); err == nil || err.Error() != "SSH 私钥未配置" {
+		t.Fatalf("empty key: err = %v", err)
+	}
+	other, err := ssh.MarshalPrivateKey(otherKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sshDial(dev, Credential{Account: "ops", AuthMethod: "publickey", PrivateKey: string(pem.EncodeToMemory(other))}); err == nil {
+		t.Fatal("unauthorized key was accepted")
+	}
+}
+
+func TestSSHHostKeyTrustOnFirstUse(t *testing.T) {
+	_, hostKey := newTestSigner(t)
+	dev := startTestSSHServer(t, hostKey, nil)
+	host := dev.addr()
+	fingerprint := ssh.FingerprintSHA256(hostKey.PublicKey())
+	cred := Credential{Account: "ops", Password: "secret"}
+	st := useHostKeyStore(t, map[string]string{})
+
+	// First contact: refused and recorded for confirmation.
+	if _, err := sshDial(dev, cred); !errors.Is(err, ErrHostKeyUnconfirmed) {
+		t.Fatalf("first use: err = %v, want ErrHostKeyUnconfirmed", err)
+	}
+	if len(st.observed) != 1 || st.observed[0] != (hostKeyObservation{host, fingerprint, false}) {
+		t.Fatalf("observed = %+v", st.observed)
+	}
+
+	// Once confirmed, the same key is accepted without another record.
+	st.trusted[host] = fingerprint
+	client, err := sshDial(dev, cred)
+	if err != nil {
+		t.Fatalf("confirmed: %v", err)
+	}
+	client.Close()
+	if len(st.observed) != 1 {
+		t.Fatalf("confirmed key observed again: %+v", st.observed)
+	}
+
+	// A different trusted key means the host key changed: refused, reported
+	// as changed, and the trusted fingerprint is left alone.
+	stale := "SHA256:previous-key"
+	st.trusted[host] = stale
+	if _, err := sshDial(dev, cred); !errors.Is(err, ErrHostKeyChanged) {
+		t.Fatalf("mismatch: err = %v, want ErrHostKeyChanged", err)
+	}
+	if len(st.observed) != 2 || st.observed[1] != (hostKeyObservation{host, fingerprint, true}) {
+		t.Fatalf("observed = %+v", st.observed)
+	}
+	if st.trusted[host] != stale {
+		t.Fatalf("trusted key replaced: %s", st.trusted[host])
+	}
+}
@@ -2,116 +2,19 @@ package project
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
+	"sort"
 	"strings"
-	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 )
 
-func vpnLogin(username, password, host string, port int) (*ssh.Client, error) {
-	cfg := &ssh.ClientConfig{
-		User:            username,
-		Auth:            []ssh.AuthMethod{ssh.Password(password)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-	return ssh.Dial("tcp", fmt.Sprintf("%s:%d", host, port), cfg)
-}
-
-func vpnLoginFromParams(p map[string]interface{}, host string) (*ssh.Client, error) {
-	account := strings.TrimSpace(toString(p["__vpn_account"]))
-	password := strings.TrimSpace(toString(p["__vpn_password"]))
-	if account == "" || password == "" {
-		return nil, fmt.Errorf("VPN 账号或密码未配置")
-	}
-	return vpnLogin(account, password, host, 22)
-}
-
-func vpnRun(client *ssh.Client, command string) (string, error) {
-	s, err := client.NewSession()
-	if err != nil {
-		return "", err
-	}
-	defer s.Close()
-
-	if err = s.RequestPty("vt100", 40, 120, ssh.TerminalModes{}); err != nil {
-		return "", err
-	}
-	stdin, err := s.StdinPipe()
-	if err != nil {
-		return "", err
-	}
-	stdout, err := s.StdoutPipe()
-	if err != nil {
-		return "", err
-	}
-	stderr, err := s.StderrPipe()
-	if err != nil {
-		return "", err
-	}
-	if err = s.Shell(); err != nil {
-		return "", err
-	}
-
-	chunkCh := make(chan []byte, 128)
-	readPipe := func(r io.Reader) {
-		buf := make([]byte, 4096)
-		for {
-			n, er := r.Read(buf)
-			if n > 0 {
-				one := make([]byte, n)
-				copy(one, buf[:n])
-				chunkCh <- one
-			}
-			if er != nil {
-				return
-			}
-		}
-	}
-	go readPipe(stdout)
-	go readPipe(stderr)
-
-	collect := func(quiet, maxWait time.Duration, handlePager bool) []byte {
-		var out bytes.Buffer
-		quietTimer := time.NewTimer(quiet)
-		defer quietTimer.Stop()
-		deadline := time.Now().Add(maxWait)
-		for {
-			if time.Now().After(deadline) {
-				return out.Bytes()
-			}
-			select {
-			case chunk := <-chunkCh:
-				if handlePager && bytes.Contains(chunk, []byte("--More--")) {
-					chunk = bytes.ReplaceAll(chunk, []byte("--More--"), []byte(""))
-					_, _ = stdin.Write([]byte(" "))
-				}
-				_, _ = out.Write(chunk)
-				if !quietTimer.Stop() {
-					select {
-					case <-quietTimer.C:
-					default:
-					}
-				}
-				quietTimer.Reset(quiet)
-			case <-quietTimer.C:
-				return out.Bytes()
-			}
-		}
-	}
-
-	_ = collect(200*time.Millisecond, 1500*time.Millisecond, false)
-	if _, err = stdin.Write([]byte(command + "\n")); err != nil {
-		return "", err
-	}
-	raw := collect(1200*time.Millisecond, 20*time.Second, true)
-	return vpnDecodeOutput(raw), nil
-}
+var vpnANSIRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
 
 func vpnDecodeOutput(raw []byte) string {
 	if len(raw) == 0 {
@@ -138,77 +41,170 @@ type vpnSearchItem struct {
 	StatusText  string `json:"status_text"`
 }
 
-var vpnErrorCodeRegex = regexp.MustCompile(`-\d{4,}`)
+// vpnErrorCodeRegex matches the device's error line, "% Error(-24501): ...".
+// It is anchored to that line so dates, phone numbers or IDs in ordinary
+// output such as a description are not taken for error codes.
+var vpnErrorCodeRegex = regexp.MustCompile(`(?mi)^\s*%?\s*error\s*\(\s*(-\d{4,})\s*\)`)
 var vpnNameRegex = regexp.MustCompile(`name\s+(\S+)`)
 var vpnDescRegex = regexp.MustCompile(`invalid\s+\S+\s+description\s+(.*?)\s+group`)
 var vpnGroupRegex = regexp.MustCompile(`group\s+(\S+)\^`)
 var vpnMailRegex = regexp.MustCompile(`mail\s+(\S+)`)
 var vpnInvalidRegex = regexp.MustCompile(`invalid\s+(\S+)`)
 
-var vpnSectionAll = []string{
-	"default^root",
-	"数金总部^root",
-	"数据总部^root",
-	"长亮控股^root",
-	"长亮合度^root",
-	"长亮金服^root",
-	"长亮智能^root",
-	"Manager^root",
-	"temp^root",
-	"长亮科技^root",
-	"共享服务中心^长亮科技^root",
-	"集团解决方案部^长亮科技^root",
-	"人力资源中心^长亮科技^root",
-	"信息服务中心^长亮科技^root",
-	"财务中心^长亮科技^root",
-	"运营中心^长亮科技^root",
-	"咨询业务中心^长亮科技^root",
-	"集团产品发展部^长亮科技^root",
-	"干部部^长亮科技^root",
-	"北京运营中心^长亮科技^root",
-	"市场部^长亮科技^root",
-	"董事会^长亮科技^root",
-	"集团总裁办^长亮科技^root",
-	"总裁办公室^长亮科技^root",
-	"研发中心^长亮科技^root",
-	"销售总部^长亮科技^root",
-	"内部审计部^长亮科技^root",
-	"公共关系部^长亮科技^root",
-	"战略规划部^长亮科技^root",
-	"健康督导办公室^长亮科技^root",
-	"税务部^长亮科技^root",
-	"集团项目管理部^长亮科技^root",
-	"研发体系^长亮科技^root",
-	"董事会办公室^长亮科技^root",
-	"战略发展部^长亮科技^root",
-	"浙农信项目^root",
-}
-
-var vpnSectionSet = func() map[string]struct{} {
-	m := make(map[string]struct{}, len(vpnSectionAll))
-	for _, one := range vpnSectionAll {
-		m[one] = struct{}{}
-	}
-	return m
-}()
-
-func vpnSectionExists(section string) bool {
-	_, ok := vpnSectionSet[strings.TrimSpace(section)]
-	return ok
-}
-
-func vpnNormalizeSection(section string) string {
+// vpnSectionListCommand prints the user group tree. Groups appear either as
+// full "leaf^parent^root" paths or as "name X ... parent-group Y" records.
+const vpnSectionListCommand = "aaaa user group show"
+
+const vpnDefaultSection = "default^root"
+
+var vpnSectionPathRegex = regexp.MustCompile(`[^\s'"]+\^root\b`)
+var vpnSectionRecordRegex = regexp.MustCompile(`name\s+(\S+)\s+.*?parent(?:-group)?\s+(\S+)`)
+
+var errVPNSectionNotFound = errors.New("未知的VPN用户组")
+
+// vpnCtx is one connection to the gateway (or firewall) with its long-lived
+// shell. dial, when set, lets exec replace a dropped connection.
+type vpnCtx struct {
+	client   *ssh.Client
+	dial     func() (*ssh.Client, error)
+	shell    *vpnShell
+	stats    vpnCmdStats
+	sections []string
+}
+
+func newVPNCtx(dev sshDevice, cred Credential) (*vpnCtx, error) {
+	dial := func() (*ssh.Client, error) {
+		return sshDial(dev, cred)
+	}
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &vpnCtx{client: client, dial: dial}, nil
+}
+
+func vpnParseSections(out string) []string {
+	seen := make(map[string]struct{})
+	sections := make([]string, 0)
+	add := func(sec string) {
+		sec = strings.TrimSpace(sec)
+		if sec == "" || sec == "root" {
+			return
+		}
+		if _, ok := seen[sec]; ok {
+			return
+		}
+		seen[sec] = struct{}{}
+		sections = append(sections, sec)
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(out, "\r", ""), "\n") {
+		if m := vpnSectionRecordRegex.FindStringSubmatch(line); m != nil {
+			parent := strings.TrimSpace(m[2])
+			switch {
+			case parent == "root":
+				add(m[1] + "^root")
+			case strings.HasSuffix(parent, "^root"):
+				add(m[1] + "^" + parent)
+			default:
+				add(m[1] + "^" + parent + "^root")
+			}
+			continue
+		}
+		for _, one := range vpnSectionPathRegex.FindAllString(line, -1) {
+			add(one)
+		}
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		if (sections[i] == vpnDefaultSection) != (sections[j] == vpnDefaultSection) {
+			return sections[i] == vpnDefaultSection
+		}
+		di, dj := strings.Count(sections[i], "^"), strings.Count(sections[j], "^")
+		if di != dj {
+			return di < dj
+		}
+		return sections[i] < sections[j]
+	})
+	return sections
+}
+
+func vpnFetchSections(ctx *vpnCtx) ([]string, error) {
+	out, err := ctx.run(vpnSectionListCommand)
+	if err != nil && strings.TrimSpace(out) == "" {
+		return nil, err
+	}
+	sections := vpnParseSections(out)
+	if len(sections) == 0 {
+		if vpnOutputLooksError(out) {
+			return nil, fmt.Errorf("读取VPN用户组失败：%s", truncate(strings.TrimSpace(out), 200))
+		}
+		return nil, errors.New("VPN 设备未返回任何用户组")
+	}
+	return sections, nil
+}
+
+// vpnSections returns the group list cached on ctx, reading it from the device
+// on first use or when refresh is set.
+func vpnSections(ctx *vpnCtx, refresh bool) ([]string, error) {
+	if ctx.sections != nil && !refresh {
+		return ctx.sections, nil
+	}
+	sections, err := vpnFetchSections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.sections = sections
+	return sections, nil
+}
+
+// vpnMatchSection accepts a full group path, a path without the trailing
+// "^root", or the display form shown in search results.
+func vpnMatchSection(sections []string, section string) (string, bool) {
+	sec := strings.TrimSpace(section)
+	for _, one := range sections {
+		if one == sec || one == sec+"^root" || vpnDisplayGroup(one) == sec {
+			return one, true
+		}
+	}
+	return "", false
+}
+
+// vpnResolveSection maps section to a group on the device. An empty value
+// means the default group; an unknown one is an error after one refresh.
+func vpnResolveSection(ctx *vpnCtx, section string) (string, error) {
 	sec := strings.TrimSpace(section)
 	if sec == "" {
-		return "default^root"
+		sec = vpnDefaultSection
+	}
+	sections, err := vpnSections(ctx, false)
+	if err != nil {
+		return "", err
 	}
-	if vpnSectionExists(sec) {
-		return sec
+	if one, ok := vpnMatchSection(sections, sec); ok {
+		return one, nil
+	}
+	if sections, err = vpnSections(ctx, true); err != nil {
+		return "", err
 	}
-	if vpnSectionExists(sec + "^root") {
-		return sec + "^root"
+	if one, ok := vpnMatchSection(sections, sec); ok {
+		return one, nil
 	}
-	return "default^root"
+	return "", fmt.Errorf("%w：%s", errVPNSectionNotFound, sec)
+}
+
+func vpnListSections(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	sections, err := vpnSections(ctx, toBoolDefault(p["refresh"], false))
+	if err != nil {
+		return projectResult{OK: false, Message: "读取VPN用户组失败", Error: err.Error()}
+	}
+	keyword := strings.TrimSpace(toString(p["keyword"]))
+	items := make([]map[string]interface{}, 0, len(sections))
+	for _, one := range sections {
+		if keyword != "" && !strings.Contains(one, keyword) {
+			continue
+		}
+		items = append(items, map[string]interface{}{"value": one, "label": vpnDisplayGroup(one)})
+	}
+	return projectResult{OK: true, Message: fmt.Sprintf("共 %d 个用户组", len(items)), Data: map[string]interface{}{"items": items}}
 }
 
 func vpnStatusToInvalid(status string) string {
@@ -220,6 +216,19 @@ func vpnStatusToInvalid(status string) string {
 	}
 }
 
+// vpnNormalizeStatus accepts the status spellings used in batch sheets. An
+// empty value means enabled.
+func vpnNormalizeStatus(status string) (string, bool) {
+	switch strings.TrimSpace(strings.ToLower(status)) {
+	case "", "enabled", "enable", "启用":
+		return "enabled", true
+	case "disabled", "disable", "禁用":
+		return "disabled", true
+	default:
+		return "", false
+	}
+}
+
 func vpnInvalidToStatus(invalid string) (string, string) {
 	if strings.TrimSpace(strings.ToLower(invalid)) == "no" {
 		return "enabled", "启用"
@@ -283,24 +292,12 @@ func vpnFormatSearchItemLog(item vpnSearchItem) string {
 	)
 }
 
-func vpnDeleteOneUser(client *ssh.Client, username string, p map[string]interface{}) (bool, bool, string, error) {
-	cmd := fmt.Sprintf("aaaa user user delete index-key name index-value %s", username)
-	tryDelete := func(cli *ssh.Client) (string, error) {
-		return vpnRun(cli, cmd)
-	}
-
-	out, err := tryDelete(client)
-	if err != nil && strings.TrimSpace(out) == "" {
-		out, err = tryDelete(client)
-	}
-	if err != nil && strings.TrimSpace(out) == "" {
-		reopenCli, loginErr := vpnLoginFromParams(p, runtimeCfg.VPNSshAddr)
-		if loginErr == nil {
-			out, err = tryDelete(reopenCli)
-			_ = reopenCli.Close()
-		}
+func vpnDeleteOneUser(ctx *vpnCtx, username string) (bool, bool, string, error) {
+	cmd, err := vpnCmdDeleteUser(username)
+	if err != nil {
+		return false, false, "", err
 	}
-
+	out, err := ctx.run(cmd)
 	if vpnIsUserNotFound(out) {
 		return false, true, out, err
 	}
@@ -348,28 +345,75 @@ func vpnBuildSearchResult(out string) ([]vpnSearchItem, string) {
 	return items, b.String()
 }
 
-func vpnOperate(client *ssh.Client, action string, p map[string]interface{}) projectResult {
+// vpnOperate runs action and reports the latency of the device commands it
+// issued under command_stats.
+func vpnOperate(ctx *vpnCtx, action string, p map[string]interface{}) projectResult {
+	return runVPNAction(ctx, action, p, vpnDispatch)
+}
+
+// runVPNAction runs action through dispatch with fresh command stats. The
+// gateway and the firewall share it since both speak the same CLI.
+func runVPNAction(ctx *vpnCtx, action string, p map[string]interface{}, dispatch vpnDispatchFunc) projectResult {
+	ctx.stats = vpnCmdStats{}
+	res := dispatch(ctx, action, p)
+	if ctx.stats.Count > 0 {
+		if res.Data == nil {
+			res.Data = map[string]interface{}{}
+		}
+		res.Data["command_stats"] = ctx.stats.data()
+	}
+	return res
+}
+
+type vpnDispatchFunc func(ctx *vpnCtx, action string, p map[string]interface{}) projectResult
+
+func vpnDispatch(ctx *vpnCtx, action string, p map[string]interface{}) projectResult {
 	switch action {
+	case "list_sections":
+		return vpnListSections(ctx, p)
 	case "add_user":
-		return vpnAddUser(client, p)
+		return vpnAddUser(ctx, p)
 	case "search_user":
-		return vpnSearchUser(client, p)
+		return vpnSearchUser(ctx, p)
 	case "modify_password":
-		return vpnModifyPassword(client, p)
+		return vpnModifyPassword(ctx, p)
 	case "modify_status":
-		return vpnModifyStatus(client, p)
+		return vpnModifyStatus(ctx, p)
 	case "delete_users":
-		return vpnDeleteUsers(client, p)
+		return vpnDeleteUsers(ctx, p)
 	case "export_excel":
-		return projectResult{OK: false, Message: "VPN 功能暂不支持导出 Excel", Error: "暂不支持导出功能"}
+		return vpnExportExcel(ctx, p)
+	case "batch_add_users":
+		return vpnBatchAddUsers(ctx, p)
+	case "batch_reset_password":
+		return vpnBatchResetPassword(ctx, p)
+	case "reconcile":
+		return vpnReconcile(ctx, p)
+	case "list_online":
+		return vpnListOnline(ctx, p)
+	case "kick_online":
+		return vpnKickOnline(ctx, p)
+	case "extend_expiry":
+		return vpnExtendExpiry(ctx, p)
+	case "find_account":
+		return vpnFindAccount(ctx, p)
+	case "list_accounts":
+		return vpnListAccounts(ctx, p)
 	default:
 		return projectResult{OK: false, Message: "不支持的VPN操作", Error: "不支持的操作"}
 	}
 }
 
-func vpnAddUser(client *ssh.Client, p map[string]interface{}) projectResult {
+func vpnAddUser(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	fw := vpnFirewallFromParams(p)
+	defer fw.close()
+	return vpnAddUserWith(ctx, p, fw)
+}
+
+// vpnAddUserWith adds the user on the gateway and, when fw is set, repeats the
+// same command on the firewall.
+func vpnAddUserWith(ctx *vpnCtx, p map[string]interface{}, fw *vpnFirewall) projectResult {
 	n := strings.TrimSpace(toString(p["vpn_user"]))
-	sec := vpnNormalizeSection(toString(p["section"]))
 	pwd := strings.TrimSpace(toString(p["passwd"]))
 	desc := strings.TrimSpace(toString(p["description"]))
 	mail := strings.TrimSpace(toString(p["mail"]))
@@ -387,10 +431,22 @@ func vpnAddUser(client *ssh.Client, p map[string]interface{}) projectResult {
 	if !isValidEmail(mail) {
 		return projectResult{OK: false, Message: "新增用户失败", Error: "邮箱格式不正确"}
 	}
+	expire, err := vpnExpiryParam(p)
+	if err != nil {
+		return projectResult{OK: false, Message: "新增用户失败", Error: err.Error()}
+	}
+
+	sec, err := vpnResolveSection(ctx, toString(p["section"]))
+	if err != nil {
+		return projectResult{OK: false, Message: "新增用户失败", Error: err.Error()}
+	}
 
 	invalid := vpnStatusToInvalid(status)
-	cmd := fmt.Sprintf("aaaa user user add name %s invalid %s group %s passwd %s description '%s' mail %s inherit-role yes", n, invalid, sec, pwd, vpnCleanDescription(desc), mail)
-	out, err := vpnRun(client, cmd)
+	cmd, err := vpnCmdAddUser(n, invalid, sec, pwd, vpnCleanDescription(desc), mail, vpnDeviceExpiry(expire))
+	if err != nil {
+		return projectResult{OK: false, Message: "新增用户失败", Error: err.Error()}
+	}
+	out, err := ctx.run(cmd)
 	if err != nil && strings.TrimSpace(out) == "" {
 		return projectResult{OK: false, Message: "新增用户失败", Error: err.Error()}
 	}
@@ -402,23 +458,94 @@ func vpnAddUser(client *ssh.Client, p map[string]interface{}) projectResult {
 	}
 
 	logText := fmt.Sprintf("用户名：%s\n初始密码：%s", n, pwd)
-	return projectResult{OK: true, Message: "新增用户成功", Data: map[string]interface{}{"vpn_user": n, "passwd": pwd, "output": out, "log_text": logText}}
+	if expire != "" {
+		logText += "\n到期日期：" + expire
+	}
+	res := projectResult{OK: true, Message: "新增用户成功", Data: map[string]interface{}{"vpn_user": n, "passwd": pwd, "expire_date": expire, "output": out, "log_text": logText}}
+	fw.mirror(&res, "新增", n, cmd)
+	return res
 }
 
-func vpnSearchUser(client *ssh.Client, p map[string]interface{}) projectResult {
-	desc := strings.TrimSpace(toString(p["description"]))
-	if desc == "" {
-		return projectResult{OK: false, Message: "查询用户失败", Error: "描述不能为空"}
+var vpnSearchKeyLabels = map[string]string{
+	"name":        "用户名",
+	"mail":        "邮箱",
+	"description": "描述",
+}
+
+// vpnSearchParams reads search_key/search_content, falling back to the older
+// description parameter. The key defaults to description.
+func vpnSearchParams(p map[string]interface{}) (string, string) {
+	key := strings.ToLower(strings.TrimSpace(toStringDefault(p["search_key"], "description")))
+	switch key {
+	case "vpn_user", "username", "user":
+		key = "name"
+	case "email":
+		key = "mail"
+	}
+	value := strings.TrimSpace(toString(p["search_content"]))
+	if value == "" && key == "description" {
+		value = strings.TrimSpace(toString(p["description"]))
+	}
+	if key == "description" {
+		value = vpnCleanDescription(value)
 	}
-	out, err := vpnRun(client, fmt.Sprintf("aaaa user user search key-word description show-type page key-value '%s'", vpnCleanDescription(desc)))
+	return key, value
+}
+
+func vpnSearchItemField(item vpnSearchItem, key string) string {
+	switch key {
+	case "name":
+		return item.Name
+	case "mail":
+		return item.Mail
+	default:
+		return item.Description
+	}
+}
+
+// vpnSearchItems runs one search on the gateway. The CLI matches substrings,
+// so exact mode keeps only items whose field equals value (names and mails
+// compared case-insensitively).
+func vpnSearchItems(ctx *vpnCtx, key, value string, exact bool) ([]vpnSearchItem, string, error) {
+	label, ok := vpnSearchKeyLabels[key]
+	if !ok {
+		return nil, "", fmt.Errorf("不支持的查询字段：%s", key)
+	}
+	if value == "" {
+		return nil, "", fmt.Errorf("%s不能为空", label)
+	}
+	cmd, err := vpnCmdSearch(key, value)
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := ctx.run(cmd)
 	if err != nil && strings.TrimSpace(out) == "" {
-		return projectResult{OK: false, Message: "查询用户失败", Error: err.Error()}
+		return nil, out, err
 	}
 	if vpnOutputLooksError(out) && !strings.Contains(out, "name") {
-		return projectResult{OK: false, Message: "查询用户失败", Error: "命令执行失败", Data: map[string]interface{}{"output": out}}
+		return nil, out, errors.New("命令执行失败")
 	}
-
 	items, _ := vpnBuildSearchResult(out)
+	if !exact {
+		return items, out, nil
+	}
+	matched := make([]vpnSearchItem, 0, len(items))
+	for _, item := range items {
+		field := strings.TrimSpace(vpnSearchItemField(item, key))
+		if field == value || (key != "description" && strings.EqualFold(field, value)) {
+			matched = append(matched, item)
+		}
+	}
+	return matched, out, nil
+}
+
+func vpnSearchUser(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	key, value := vpnSearchParams(p)
+	exact := strings.EqualFold(strings.TrimSpace(toString(p["match"])), "exact")
+	items, out, err := vpnSearchItems(ctx, key, value, exact)
+	if err != nil {
+		return projectResult{OK: false, Message: "查询用户失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
+	}
 	if len(items) == 0 {
 		return projectResult{OK: true, Message: "未查询到记录", Data: map[string]interface{}{"items": []vpnSearchItem{}, "raw": out, "log_text": "未查询到该用户的VPN信息"}}
 	}
@@ -432,46 +559,60 @@ func vpnSearchUser(client *ssh.Client, p map[string]interface{}) projectResult {
 	return projectResult{OK: true, Message: fmt.Sprintf("查询完成，共 %d 条", len(items)), Data: map[string]interface{}{"items": items, "raw": out, "log_text": logText}}
 }
 
-func vpnFindUserByDescription(client *ssh.Client, description string) (string, string, error) {
-	desc := strings.TrimSpace(description)
-	if desc == "" {
-		return "", "", fmt.Errorf("描述不能为空")
+// vpnResolveTarget picks the user a modify action applies to: vpn_user when no
+// search condition is given, otherwise the single exact match of the search.
+// Zero or several matches end the action with a failed result; several
+// matches are returned as candidates so the caller can choose by name.
+func vpnResolveTarget(ctx *vpnCtx, p map[string]interface{}, failMsg string) (string, string, projectResult) {
+	key, value := vpnSearchParams(p)
+	if value == "" {
+		n := strings.TrimSpace(toString(p["vpn_user"]))
+		if n == "" {
+			return "", "", projectResult{OK: false, Message: failMsg, Error: "用户名或查询条件不能为空"}
+		}
+		return n, "", projectResult{}
 	}
-	out, err := vpnRun(client, fmt.Sprintf("aaaa user user search key-word description show-type page key-value '%s'", vpnCleanDescription(desc)))
-	if err != nil && strings.TrimSpace(out) == "" {
-		return "", out, err
+	items, out, err := vpnSearchItems(ctx, key, value, true)
+	if err != nil {
+		return "", out, projectResult{OK: false, Message: failMsg, Error: err.Error(), Data: map[string]interface{}{"output": out}}
 	}
-	items, _ := vpnBuildSearchResult(out)
+	switch len(items) {
+	case 0:
+		return "", out, projectResult{OK: false, Message: failMsg, Error: "未找到匹配" + vpnSearchKeyLabels[key] + "的用户", Data: map[string]interface{}{"output": out}}
+	case 1:
+		return strings.TrimSpace(items[0].Name), out, projectResult{}
+	}
+	logEntries := make([]string, 0, len(items))
 	for _, item := range items {
-		if strings.TrimSpace(item.Description) == desc {
-			return strings.TrimSpace(item.Name), out, nil
-		}
+		logEntries = append(logEntries, vpnFormatSearchItemLog(item))
+	}
+	return "", out, projectResult{
+		OK:      false,
+		Message: failMsg,
+		Error:   fmt.Sprintf("匹配到 %d 个用户，请按用户名指定", len(items)),
+		Data: map[string]interface{}{
+			"candidates": items,
+			"items":      items,
+			"output":     out,
+			"log_text":   strings.Join(logEntries, "\n\n"),
+		},
 	}
-	return "", out, fmt.Errorf("未找到匹配描述的用户")
 }
 
-func vpnModifyPassword(client *ssh.Client, p map[string]interface{}) projectResult {
-	n := strings.TrimSpace(toString(p["vpn_user"]))
-	desc := strings.TrimSpace(toString(p["description"]))
-	searchOut := ""
-	execClient := client
-
-	if desc != "" {
-		resolved, out, err := vpnFindUserByDescription(client, desc)
-		searchOut = out
-		if err != nil {
-			return projectResult{OK: false, Message: "修改密码失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
-		}
-		n = resolved
-		reopenCli, err := vpnLoginFromParams(p, runtimeCfg.VPNSshAddr)
-		if err != nil {
-			return projectResult{OK: false, Message: "VPN 重新登录失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
-		}
-		defer reopenCli.Close()
-		execClient = reopenCli
+func vpnModifyPassword(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	fw := vpnFirewallFromParams(p)
+	defer fw.close()
+	return vpnModifyPasswordWith(ctx, p, fw)
+}
+
+func vpnModifyPasswordWith(ctx *vpnCtx, p map[string]interface{}, fw *vpnFirewall) projectResult {
+	expire, err := vpnExpiryParam(p)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改密码失败", Error: err.Error()}
 	}
+	n, searchOut, failed := vpnResolveTarget(ctx, p, "修改密码失败")
 	if n == "" {
-		return projectResult{OK: false, Message: "修改密码失败", Error: "描述不能为空"}
+		return failed
 	}
 
 	pwd := strings.TrimSpace(toString(p["passwd"]))
@@ -482,71 +623,83 @@ func vpnModifyPassword(client *ssh.Client, p map[string]interface{}) projectResu
 		return projectResult{OK: false, Message: "密码格式不符合要求", Error: "密码至少8位，且包含大小写字母和数字"}
 	}
 
-	out, err := vpnRun(execClient, fmt.Sprintf("aaaa user user modify-info passwd %s index-key name index-value %s", pwd, n))
+	cmd, err := vpnCmdModifyPassword(n, pwd)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改密码失败", Error: err.Error()}
+	}
+	ret, err := ctx.exec(cmd, vpnCmdTimeout, nil)
+	out := ret.Output
 	if err != nil && strings.TrimSpace(out) == "" {
 		return projectResult{OK: false, Message: "修改密码失败", Error: err.Error()}
 	}
-	if strings.Contains(out, "-24501") {
+	if ret.hasCode("-24501") {
 		return projectResult{OK: false, Message: "修改失败", Error: "用户不存在", Data: map[string]interface{}{"output": out}}
 	}
-	if strings.Contains(out, "-24316") {
+	if ret.hasCode("-24316") {
 		return projectResult{OK: false, Message: "修改失败", Error: "新旧密码相同", Data: map[string]interface{}{"output": out}}
 	}
-	if strings.Contains(out, "-23204") {
+	if ret.hasCode("-23204") {
 		return projectResult{OK: false, Message: "修改失败", Error: "密码长度不足8位", Data: map[string]interface{}{"output": out}}
 	}
-	if vpnOutputLooksError(out) {
+	if ret.Status != 0 {
 		return projectResult{OK: false, Message: "修改密码失败", Error: "命令执行失败", Data: map[string]interface{}{"output": out}}
 	}
 
 	logText := fmt.Sprintf("用户名：%s\n新密码：%s", n, pwd)
-	return projectResult{OK: true, Message: "修改密码成功", Data: map[string]interface{}{"vpn_user": n, "passwd": pwd, "output": out, "search_output": searchOut, "log_text": logText}}
+	res := projectResult{OK: true, Message: "修改密码成功", Data: map[string]interface{}{"vpn_user": n, "passwd": pwd, "output": out, "search_output": searchOut, "log_text": logText}}
+	fw.mirror(&res, "修改密码", n, cmd)
+	vpnApplyExpiry(ctx, &res, n, expire)
+	return res
 }
 
-func vpnModifyStatus(client *ssh.Client, p map[string]interface{}) projectResult {
-	n := strings.TrimSpace(toString(p["vpn_user"]))
-	desc := strings.TrimSpace(toString(p["description"]))
-	searchOut := ""
-	execClient := client
-
-	if desc != "" {
-		resolved, out, err := vpnFindUserByDescription(client, desc)
-		searchOut = out
-		if err != nil {
-			return projectResult{OK: false, Message: "修改状态失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
-		}
-		n = resolved
-		reopenCli, err := vpnLoginFromParams(p, runtimeCfg.VPNSshAddr)
-		if err != nil {
-			return projectResult{OK: false, Message: "VPN 重新登录失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
-		}
-		defer reopenCli.Close()
-		execClient = reopenCli
+func vpnModifyStatus(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	expire, err := vpnExpiryParam(p)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
 	}
+	n, searchOut, failed := vpnResolveTarget(ctx, p, "修改状态失败")
 	if n == "" {
-		return projectResult{OK: false, Message: "修改状态失败", Error: "描述不能为空"}
+		return failed
 	}
 
 	status := strings.TrimSpace(toStringDefault(p["status"], "enabled"))
 	invalid := vpnStatusToInvalid(status)
 	_, statusText := vpnInvalidToStatus(invalid)
 
-	out, err := vpnRun(execClient, fmt.Sprintf("aaaa user user modify-info invalid %s index-key name index-value %s", invalid, n))
+	cmd, err := vpnCmdModifyInvalid(n, invalid)
+	if err != nil {
+		return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
+	}
+	ret, err := ctx.exec(cmd, vpnCmdTimeout, nil)
+	out := ret.Output
 	if err != nil && strings.TrimSpace(out) == "" {
 		return projectResult{OK: false, Message: "修改状态失败", Error: err.Error()}
 	}
-	if strings.Contains(out, "-24501") {
+	if ret.hasCode("-24501") {
 		return projectResult{OK: false, Message: "修改状态失败", Error: "用户不存在", Data: map[string]interface{}{"output": out}}
 	}
-	if vpnOutputLooksError(out) {
+	if ret.Status != 0 {
 		return projectResult{OK: false, Message: "修改状态失败", Error: "命令执行失败", Data: map[string]interface{}{"output": out}}
 	}
 
 	logText := fmt.Sprintf("用户名：%s\n状态：%s", n, statusText)
-	return projectResult{OK: true, Message: "修改状态成功", Data: map[string]interface{}{"vpn_user": n, "status": status, "output": out, "search_output": searchOut, "log_text": logText}}
+	res := projectResult{OK: true, Message: "修改状态成功", Data: map[string]interface{}{"vpn_user": n, "status": status, "output": out, "search_output": searchOut, "log_text": logText}}
+	fw := vpnFirewallFromParams(p)
+	defer fw.close()
+	fw.mirror(&res, "修改状态", n, cmd)
+	vpnApplyExpiry(ctx, &res, n, expire)
+	if invalid == "yes" {
+		if kick, line := vpnKickAfterChange(ctx, p, n); kick != nil {
+			for k, v := range kick {
+				res.Data[k] = v
+			}
+			res.Data["log_text"] = toString(res.Data["log_text"]) + "\n" + line
+		}
+	}
+	return res
 }
 
-func vpnDeleteUsers(client *ssh.Client, p map[string]interface{}) projectResult {
+func vpnDeleteUsers(ctx *vpnCtx, p map[string]interface{}) projectResult {
 	users := normalizeUsers(p["vpn_users"])
 	if len(users) == 0 {
 		users = normalizeUsers(p["vpn_user"])
@@ -565,62 +718,62 @@ func vpnDeleteUsers(client *ssh.Client, p map[string]interface{}) projectResult
 	progressStep := 0
 
 	for _, u := range users {
-		ok, notFound, finalOut, finalErr := vpnDeleteOneUser(client, u, p)
+		ok, notFound, finalOut, finalErr := vpnDeleteOneUser(ctx, u)
+		var kick map[string]interface{}
 		if ok {
 			okCount++
 			logs = append(logs, fmt.Sprintf("用户 %s 删除成功！", u))
+			var line string
+			if kick, line = vpnKickAfterChange(ctx, p, u); kick != nil {
+				logs = append(logs, line)
+			}
 		} else if notFound {
 			logs = append(logs, fmt.Sprintf("删除失败！用户 %s 不存在！", u))
 		} else {
 			logs = append(logs, fmt.Sprintf("删除失败！用户 %s 删除异常！", u))
 		}
-		items = append(items, map[string]interface{}{"vpn_user": u, "ok": ok, "output": finalOut, "error": errString(finalErr)})
+		item := map[string]interface{}{"vpn_user": u, "ok": ok, "output": finalOut, "error": errString(finalErr)}
+		for k, v := range kick {
+			item[k] = v
+		}
+		items = append(items, item)
 		progressStep++
 		emitProgress(p, fmt.Sprintf("处理VPN用户：%s", u), progressStep, totalSteps)
 	}
 
 	data := map[string]interface{}{"items": items}
 
-	if toBoolDefault(p["remote_firewall"], false) {
-		fwConfigured := toBoolDefault(p["__vpn_fw_configured"], false)
-		fwAccount := strings.TrimSpace(toString(p["__vpn_fw_account"]))
-		fwPassword := strings.TrimSpace(toString(p["__vpn_fw_password"]))
+	if fw := vpnFirewallFromParams(p); fw != nil {
+		defer fw.close()
 		ritems := make([]map[string]interface{}, 0, len(users))
 		rlogs := make([]string, 0, len(users)+4)
 
-		if !fwConfigured || fwAccount == "" || fwPassword == "" {
-			msg := "未配置防火墙账号密码，无法同步删除防火墙上的VPN账户"
-			if strings.TrimSpace(toString(p["__vpn_fw_error"])) != "" {
-				msg += "，原因：" + strings.TrimSpace(toString(p["__vpn_fw_error"]))
-			}
+		fwCtx, loginErr := fw.dial()
+		if loginErr != nil {
+			msg := "无法同步删除防火墙上的VPN账户：" + loginErr.Error()
 			for _, u := range users {
-				ritems = append(ritems, map[string]interface{}{"vpn_user": u, "ok": false, "output": "", "error": "防火墙凭据未配置"})
+				ritems = append(ritems, map[string]interface{}{"vpn_user": u, "ok": false, "output": "", "error": errString(loginErr)})
 			}
 			rlogs = append(rlogs, msg)
 			data["remote_error"] = msg
 		} else {
 			rlogs = append(rlogs, "", "正在前往防火墙系统执行删除vpn用户....", "")
-			rcli, loginErr := vpnLogin(fwAccount, fwPassword, runtimeCfg.FirewallSSHAddr, 22)
-			if loginErr != nil {
-				rlogs = append(rlogs, "远程防火墙系统失败！请检查用户名密码或访问权限！")
-				for _, u := range users {
-					ritems = append(ritems, map[string]interface{}{"vpn_user": u, "ok": false, "output": "", "error": errString(loginErr)})
+			for _, u := range users {
+				var out string
+				cmd, err := vpnCmdDeleteUser(u)
+				if err == nil {
+					out, err = fwCtx.run(cmd)
 				}
-			} else {
-				for _, u := range users {
-					out, err := vpnRun(rcli, fmt.Sprintf("aaaa user user delete index-key name index-value %s", u))
-					rok := vpnDeleteLooksSuccess(out)
-					notFound := vpnIsUserNotFound(out)
-					if rok {
-						rlogs = append(rlogs, fmt.Sprintf("用户 %s 删除成功！", u))
-					} else if notFound {
-						rlogs = append(rlogs, fmt.Sprintf("删除失败！用户 %s 不存在！", u))
-					} else {
-						rlogs = append(rlogs, fmt.Sprintf("删除失败！用户 %s 删除异常！", u))
-					}
-					ritems = append(ritems, map[string]interface{}{"vpn_user": u, "ok": rok, "output": out, "error": errString(err)})
+				rok := vpnDeleteLooksSuccess(out)
+				notFound := vpnIsUserNotFound(out)
+				if rok {
+					rlogs = append(rlogs, fmt.Sprintf("用户 %s 删除成功！", u))
+				} else if notFound {
+					rlogs = append(rlogs, fmt.Sprintf("删除失败！用户 %s 不存在！", u))
+				} else {
+					rlogs = append(rlogs, fmt.Sprintf("删除失败！用户 %s 删除异常！", u))
 				}
-				_ = rcli.Close()
+				ritems = append(ritems, map[string]interface{}{"vpn_user": u, "ok": rok, "output": out, "error": errString(err)})
 			}
 		}
 
@@ -635,3 +788,149 @@ func vpnDeleteUsers(client *ssh.Client, p map[string]interface{}) projectResult
 	data["log_text"] = strings.Join(logs, "\n")
 	return projectResult{OK: true, Message: fmt.Sprintf("删除完成 %d/%d", okCount, len(users)), Data: data}
 }
+
+// vpnExportExcel reads every user from the gateway and writes them to an xlsx
+// file, optionally keeping only one section (with its sub-groups) or status.
+func vpnExportExcel(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	status := strings.ToLower(strings.TrimSpace(toString(p["status"])))
+	switch status {
+	case "", "all":
+		status = ""
+	case "enabled", "disabled":
+	default:
+		return projectResult{OK: false, Message: "导出失败", Error: "状态取值无效"}
+	}
+	group := vpnDisplayGroup(toString(p["section"]))
+
+	items, out, err := vpnListAllUsers(ctx, p, "VPN")
+	if err != nil {
+		return projectResult{OK: false, Message: "导出失败", Error: err.Error(), Data: map[string]interface{}{"output": truncate(out, 2000)}}
+	}
+
+	filtered := make([]vpnSearchItem, 0, len(items))
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		if group != "" && item.Group != group && !strings.HasSuffix(item.Group, "^"+group) {
+			continue
+		}
+		if status != "" && item.Status != status {
+			continue
+		}
+		filtered = append(filtered, item)
+		rows = append(rows, []interface{}{item.Name, item.Description, item.Group, item.Mail, item.StatusText})
+	}
+	emitProgress(p, fmt.Sprintf("共读取 %d 个用户，符合条件 %d 个，正在生成Excel...", len(items), len(filtered)), len(filtered), len(filtered))
+
+	name, err := writeExportSheet("vpn", "vpn_users", []string{"用户名", "描述", "所属父组", "邮箱", "状态"}, rows)
+	if err != nil {
+		return projectResult{OK: false, Message: "导出失败", Error: err.Error()}
+	}
+	logText := fmt.Sprintf("共读取 %d 个VPN用户，导出 %d 个\n文件：%s", len(items), len(filtered), name)
+	return projectResult{OK: true, Message: fmt.Sprintf("导出完成，共 %d 条", len(filtered)), Data: map[string]interface{}{"items": filtered, "export_file": name, "log_text": logText}}
+}
+
+var vpnBatchTemplates = map[string]batchTemplate{
+	"batch_add_users": {
+		Title:    "批量新增",
+		FileName: "VPN批量新增用户模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name", "vpn_user"}},
+			{Key: "section", Names: []string{"所属父组", "section", "group"}},
+			{Key: "description", Names: []string{"描述", "description"}},
+			{Key: "mail", Names: []string{"邮箱", "mail", "email"}},
+			{Key: "status", Names: []string{"状态", "status"}},
+			{Key: "password", Names: []string{"密码", "password", "passwd"}},
+			{Key: "expire_date", Names: []string{"到期日期", "expire_date"}},
+		},
+	},
+	"batch_reset_password": {
+		Title:    "批量重置密码",
+		FileName: "VPN批量重置密码模板.xlsx",
+		Fields: []batchField{
+			{Key: "name", Names: []string{"用户名", "username", "name", "vpn_user"}},
+			{Key: "password", Names: []string{"新密码", "password", "passwd"}},
+		},
+	},
+}
+
+func vpnBatchTemplate(action string) (batchTemplate, error) {
+	if tpl, ok := vpnBatchTemplates[strings.TrimSpace(action)]; ok {
+		return tpl, nil
+	}
+	return batchTemplate{}, fmt.Errorf("%w: %s", ErrBatchActionUnsupported, action)
+}
+
+// vpnBatchRun runs a batch action and saves the per-row results as an export
+// file. Passwords are only returned in the items, never written to the file.
+func vpnBatchRun(p map[string]interface{}, action string, one func(m map[string]interface{}) (projectResult, string)) projectResult {
+	tpl, err := vpnBatchTemplate(action)
+	if err != nil {
+		return projectResult{OK: false, Message: "批量操作失败", Error: err.Error()}
+	}
+	res := batchRun("vpn", tpl, p, one)
+	if !res.OK || res.Data == nil {
+		return res
+	}
+	items, _ := res.Data["items"].([]map[string]interface{})
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		result := "失败"
+		if item["ok"] == true {
+			result = "成功"
+		}
+		rows = append(rows, []interface{}{item["row"], item["username"], result, item["error_reason"], toString(item["remote_log_text"])})
+	}
+	name, err := writeExportSheet("vpn", "vpn_"+action, []string{"行号", "用户名", "结果", "错误原因", "防火墙同步"}, rows)
+	if err != nil {
+		res.Data["log_text"] = fmt.Sprintf("%s\n保存结果文件失败：%s", toString(res.Data["log_text"]), err.Error())
+		return res
+	}
+	res.Data["export_file"] = name
+	return res
+}
+
+func vpnBatchAddUsers(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	defaultPwd := strings.TrimSpace(toString(p["default_password"]))
+	defaultExpire := strings.TrimSpace(toString(p["expire_date"]))
+	fw := vpnFirewallFromParams(p)
+	defer fw.close()
+	return vpnBatchRun(p, "batch_add_users", func(m map[string]interface{}) (projectResult, string) {
+		status, ok := vpnNormalizeStatus(toString(m["status"]))
+		if !ok {
+			return projectResult{OK: false, Message: "新增用户失败", Error: "状态取值无效：" + toString(m["status"])}, ""
+		}
+		pwd := strings.TrimSpace(toString(m["password"]))
+		if pwd == "" {
+			pwd = defaultPwd
+		}
+		if pwd == "" {
+			pwd = randomPassword()
+		}
+		row := map[string]interface{}{
+			"vpn_user":    m["name"],
+			"passwd":      pwd,
+			"description": m["description"],
+			"mail":        m["mail"],
+			"section":     m["section"],
+			"status":      status,
+			"expire_date": toStringDefault(m["expire_date"], defaultExpire),
+		}
+		return vpnAddUserWith(ctx, row, fw), pwd
+	})
+}
+
+func vpnBatchResetPassword(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	defaultPwd := strings.TrimSpace(toString(p["default_password"]))
+	fw := vpnFirewallFromParams(p)
+	defer fw.close()
+	return vpnBatchRun(p, "batch_reset_password", func(m map[string]interface{}) (projectResult, string) {
+		pwd := strings.TrimSpace(toString(m["password"]))
+		if pwd == "" {
+			pwd = defaultPwd
+		}
+		if pwd == "" {
+			pwd = randomPassword()
+		}
+		return vpnModifyPasswordWith(ctx, map[string]interface{}{"vpn_user": m["name"], "passwd": pwd}, fw), pwd
+	})
+}
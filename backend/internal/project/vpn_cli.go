@@ -0,0 +1,223 @@
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The gateway CLI splits a command line on whitespace and only understands
+// single quotes; there is no escape character. Outside quotes "?" opens the
+// inline help and ";" "|" "&" act as separators on some firmware versions, so
+// unquoted values (names, groups) must not contain them. Passwords with such
+// characters and all free text are single-quoted; a quote itself is rejected.
+
+var vpnCLIIdentRegex = regexp.MustCompile(`^[\p{L}\p{N}._@-]{1,64}$`)
+var vpnCLIGroupRegex = regexp.MustCompile(`^[^\s'"\\^;?|&]+(\^[^\s'"\\^;?|&]+)*$`)
+var vpnCLIPasswordRegex = regexp.MustCompile(`^[\x21-\x7e]{1,64}$`)
+var vpnCLIPlainRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+var vpnCLIDateRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// vpnCLI builds one gateway command line. Each value goes through a setter
+// that validates it for its kind and applies the matching quoting rule; the
+// first violation is kept and returned by build.
+type vpnCLI struct {
+	words []string
+	err   error
+}
+
+func newVPNCLI(words ...string) *vpnCLI {
+	return &vpnCLI{words: append([]string(nil), words...)}
+}
+
+func (c *vpnCLI) fail(label, value string) *vpnCLI {
+	if c.err == nil {
+		c.err = fmt.Errorf("%s包含非法字符：%q", label, value)
+	}
+	return c
+}
+
+func (c *vpnCLI) add(key, value string) *vpnCLI {
+	c.words = append(c.words, key, value)
+	return c
+}
+
+func vpnCLIHasControl(value string) bool {
+	return strings.IndexFunc(value, unicode.IsControl) >= 0
+}
+
+// ident accepts user names and index values: letters, digits and "._@-".
+func (c *vpnCLI) ident(key, label, value string) *vpnCLI {
+	if !vpnCLIIdentRegex.MatchString(value) {
+		return c.fail(label, value)
+	}
+	return c.add(key, value)
+}
+
+func (c *vpnCLI) enum(key, label, value string, allowed ...string) *vpnCLI {
+	for _, one := range allowed {
+		if value == one {
+			return c.add(key, value)
+		}
+	}
+	if c.err == nil {
+		c.err = fmt.Errorf("%s取值无效：%q", label, value)
+	}
+	return c
+}
+
+// group accepts a "leaf^parent^root" path without whitespace or quotes.
+func (c *vpnCLI) group(key, label, value string) *vpnCLI {
+	if vpnCLIHasControl(value) || !vpnCLIGroupRegex.MatchString(value) {
+		return c.fail(label, value)
+	}
+	return c.add(key, value)
+}
+
+func (c *vpnCLI) mail(key, label, value string) *vpnCLI {
+	if !isValidEmail(value) || !vpnCLIIdentRegex.MatchString(value) {
+		return c.fail(label, value)
+	}
+	return c.add(key, value)
+}
+
+// passwd accepts printable ASCII without spaces, quotes or "?". Anything
+// beyond letters and digits is single-quoted.
+func (c *vpnCLI) passwd(key, label, value string) *vpnCLI {
+	if !vpnCLIPasswordRegex.MatchString(value) || strings.ContainsAny(value, `'"?`) {
+		return c.fail(label, value)
+	}
+	if vpnCLIPlainRegex.MatchString(value) {
+		return c.add(key, value)
+	}
+	return c.add(key, "'"+value+"'")
+}
+
+// text always single-quotes free-form values such as descriptions.
+func (c *vpnCLI) text(key, label, value string) *vpnCLI {
+	if value == "" || vpnCLIHasControl(value) || strings.Contains(value, "'") {
+		return c.fail(label, value)
+	}
+	return c.add(key, "'"+value+"'")
+}
+
+// date accepts a YYYY-MM-DD day; an empty value leaves the key out.
+func (c *vpnCLI) date(key, label, value string) *vpnCLI {
+	if value == "" {
+		return c
+	}
+	if !vpnCLIDateRegex.MatchString(value) {
+		return c.fail(label, value)
+	}
+	return c.add(key, value)
+}
+
+func (c *vpnCLI) build() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	return strings.Join(c.words, " "), nil
+}
+
+// vpnCmdAddUser adds a user; expire, when not empty, is sent as the account's
+// expire-date.
+func vpnCmdAddUser(name, invalid, group, passwd, description, mail, expire string) (string, error) {
+	return newVPNCLI("aaaa", "user", "user", "add").
+		ident("name", "用户名", name).
+		enum("invalid", "状态", invalid, "yes", "no").
+		group("group", "所属父组", group).
+		passwd("passwd", "密码", passwd).
+		text("description", "描述", description).
+		mail("mail", "邮箱", mail).
+		enum("inherit-role", "继承角色", "yes", "yes", "no").
+		date("expire-date", "到期日期", expire).
+		build()
+}
+
+func vpnCmdDeleteUser(name string) (string, error) {
+	return newVPNCLI("aaaa", "user", "user", "delete", "index-key", "name").
+		ident("index-value", "用户名", name).
+		build()
+}
+
+func vpnCmdSearch(key, value string) (string, error) {
+	return newVPNCLI("aaaa", "user", "user", "search").
+		enum("key-word", "查询字段", key, "name", "mail", "description").
+		add("show-type", "page").
+		text("key-value", "查询值", value).
+		build()
+}
+
+// vpnCmdListUsers searches by name with an empty key-value, which the gateway
+// treats as "match everything" and answers page by page.
+func vpnCmdListUsers() (string, error) {
+	return newVPNCLI("aaaa", "user", "user", "search", "key-word", "name", "show-type", "page", "key-value", "''").build()
+}
+
+func vpnCmdModifyPassword(name, passwd string) (string, error) {
+	return newVPNCLI("aaaa", "user", "user", "modify-info").
+		passwd("passwd", "密码", passwd).
+		add("index-key", "name").
+		ident("index-value", "用户名", name).
+		build()
+}
+
+func vpnCmdModifyInvalid(name, invalid string) (string, error) {
+	return newVPNCLI("aaaa", "user", "user", "modify-info").
+		enum("invalid", "状态", invalid, "yes", "no").
+		add("index-key", "name").
+		ident("index-value", "用户名", name).
+		build()
+}
+
+// vpnCmdListOnline shows the connected sessions, all of them or only name's.
+func vpnCmdListOnline(name string) (string, error) {
+	c := newVPNCLI("aaaa", "online-user", "show")
+	if name != "" {
+		c.add("index-key", "name").ident("index-value", "用户名", name)
+	}
+	return c.build()
+}
+
+// vpnCmdKickOnline ends every session of a user (key "name") or a single
+// session (key "session-id").
+func vpnCmdKickOnline(key, value string) (string, error) {
+	label := "用户名"
+	if key == "session-id" {
+		label = "会话编号"
+	}
+	return newVPNCLI("aaaa", "online-user", "kick").
+		enum("index-key", "下线方式", key, "name", "session-id").
+		ident("index-value", label, value).
+		build()
+}
+
+func vpnCmdModifyExpiry(name, expire string) (string, error) {
+	if expire == "" {
+		return "", fmt.Errorf("到期日期不能为空")
+	}
+	return newVPNCLI("aaaa", "user", "user", "modify-info").
+		date("expire-date", "到期日期", expire).
+		add("index-key", "name").
+		ident("index-value", "用户名", name).
+		build()
+}
+
+// vpnCmdConfirms reports whether the device asks to confirm command before
+// running it. Only these commands get a yes; a question on any other command
+// is declined so nothing destructive is accepted by accident.
+func vpnCmdConfirms(command string) bool {
+	return strings.HasPrefix(command, "aaaa user user delete ") || strings.HasPrefix(command, "aaaa online-user kick ")
+}
+
+// vpnCmdReadOnly reports whether command only reads from the device, so it
+// is safe to send again after the connection dropped.
+func vpnCmdReadOnly(command string) bool {
+	for _, prefix := range []string{"aaaa user user search ", "aaaa user group show", "aaaa online-user show"} {
+		if strings.HasPrefix(command, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package project
+
+import "testing"
+
+// Each case renders one value through one setter on a "cmd" prefix and
+// expects either the exact command line or the exact error.
+func TestVPNCLIQuotesHostileValues(t *testing.T) {
+	ident := func(v string) *vpnCLI { return newVPNCLI("cmd").ident("name", "用户名", v) }
+	enum := func(v string) *vpnCLI { return newVPNCLI("cmd").enum("invalid", "状态", v, "yes", "no") }
+	group := func(v string) *vpnCLI { return newVPNCLI("cmd").group("group", "所属父组", v) }
+	mail := func(v string) *vpnCLI { return newVPNCLI("cmd").mail("mail", "邮箱", v) }
+	passwd := func(v string) *vpnCLI { return newVPNCLI("cmd").passwd("passwd", "密码", v) }
+	text := func(v string) *vpnCLI { return newVPNCLI("cmd").text("description", "描述", v) }
+	date := func(v string) *vpnCLI { return newVPNCLI("cmd").date("expire-date", "到期日期", v) }
+
+	cases := []struct {
+		name    string
+		cli     *vpnCLI
+		want    string
+		wantErr string
+	}{
+		{"ident plain", ident("alice.w-1@corp"), "cmd name alice.w-1@corp", ""},
+		{"ident non-ascii", ident("张三"), "cmd name 张三", ""},
+		// The CLI has no option flags, so a leading dash is just a character.
+		{"ident leading dash", ident("-rf"), "cmd name -rf", ""},
+		{"ident semicolon", ident("a;reboot"), "", `用户名包含非法字符："a;reboot"`},
+		{"ident pipe", ident("a|b"), "", `用户名包含非法字符："a|b"`},
+		{"ident newline", ident("a\nreboot"), "", `用户名包含非法字符："a\nreboot"`},
+		{"ident single quote", ident("a'b"), "", `用户名包含非法字符："a'b"`},
+		{"ident double quote", ident(`a"b`), "", `用户名包含非法字符："a\"b"`},
+		{"ident space", ident("a b"), "", `用户名包含非法字符："a b"`},
+		{"ident empty", ident(""), "", `用户名包含非法字符：""`},
+
+		{"enum allowed", enum("yes"), "cmd invalid yes", ""},
+		{"enum semicolon", enum("yes;no"), "", `状态取值无效："yes;no"`},
+		{"enum space", enum("yes no"), "", `状态取值无效："yes no"`},
+		{"enum case", enum("Yes"), "", `状态取值无效："Yes"`},
+
+		{"group path", group("dev^it^root"), "cmd group dev^it^root", ""},
+		{"group non-ascii", group("研发部^root"), "cmd group 研发部^root", ""},
+		{"group leading dash", group("-x^root"), "cmd group -x^root", ""},
+		{"group semicolon", group("it;reboot^root"), "", `所属父组包含非法字符："it;reboot^root"`},
+		{"group pipe", group("it|x"), "", `所属父组包含非法字符："it|x"`},
+		{"group newline", group("it\n^root"), "", `所属父组包含非法字符："it\n^root"`},
+		{"group single quote", group("it'^root"), "", `所属父组包含非法字符："it'^root"`},
+		{"group double quote", group(`it"^root`), "", `所属父组包含非法字符："it\"^root"`},
+		{"group space", group("it dept^root"), "", `所属父组包含非法字符："it dept^root"`},
+		{"group empty segment", group("it^^root"), "", `所属父组包含非法字符："it^^root"`},
+
+		{"mail plain", mail("a.b@example.com"), "cmd mail a.b@example.com", ""},
+		{"mail leading dash", mail("-a@example.com"), "cmd mail -a@example.com", ""},
+		{"mail semicolon", mail("a@example.com;reboot"), "", `邮箱包含非法字符："a@example.com;reboot"`},
+		{"mail pipe", mail("a|b@example.com"), "", `邮箱包含非法字符："a|b@example.com"`},
+		{"mail newline", mail("a@example.com\n"), "", `邮箱包含非法字符："a@example.com\n"`},
+		{"mail quote", mail("a'b@example.com"), "", `邮箱包含非法字符："a'b@example.com"`},
+		{"mail space", mail(" a@example.com"), "", `邮箱包含非法字符：" a@example.com"`},
+		{"mail non-ascii", mail("张三@example.com"), "", `邮箱包含非法字符："张三@example.com"`},
+
+		{"passwd plain", passwd("Abc123"), "cmd passwd Abc123", ""},
+		{"passwd separators quoted", passwd("p@ss;w|rd&"), "cmd passwd 'p@ss;w|rd&'", ""},
+		{"passwd leading dash quoted", passwd("-Abc123"), "cmd passwd '-Abc123'", ""},
+		{"passwd space", passwd("pass word"), "", `密码包含非法字符："pass word"`},
+		{"passwd single quote", passwd("pa'ss"), "", `密码包含非法字符："pa'ss"`},
+		{"passwd double quote", passwd(`pa"ss`), "", `密码包含非法字符："pa\"ss"`},
+		{"passwd help mark", passwd("pass?"), "", `密码包含非法字符："pass?"`},
+		{"passwd newline", passwd("pass\nreboot"), "", `密码包含非法字符："pass\nreboot"`},
+		{"passwd non-ascii", passwd("密码123"), "", `密码包含非法字符："密码123"`},
+
+		{"text spaces quoted", text("new hire"), "cmd description 'new hire'", ""},
+		{"text separators quoted", text("a;b|c&d"), "cmd description 'a;b|c&d'", ""},
+		{"text double quote quoted", text(`say "hi"`), `cmd description 'say "hi"'`, ""},
+		{"text leading dash quoted", text("-x"), "cmd description '-x'", ""},
+		{"text non-ascii quoted", text("张三 研发部"), "cmd description '张三 研发部'", ""},
+		{"text single quote", text("it's"), "", `描述包含非法字符："it's"`},
+		{"text newline", text("a\nreboot"), "", `描述包含非法字符："a\nreboot"`},
+		{"text empty", text(""), "", `描述包含非法字符：""`},
+
+		{"date day", date("2026-01-31"), "cmd expire-date 2026-01-31", ""},
+		{"date empty omitted", date(""), "cmd", ""},
+		{"date semicolon", date("2026-01-31;reboot"), "", `到期日期包含非法字符："2026-01-31;reboot"`},
+		{"date short", date("2026-1-1"), "", `到期日期包含非法字符："2026-1-1"`},
+	}
+	for _, tc := range cases {
+		got, err := tc.cli.build()
+		if tc.wantErr != "" {
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("%s: got %q, %v; want error %s", tc.name, got, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestVPNCLIKeepsFirstError(t *testing.T) {
+	_, err := newVPNCLI("cmd").
+		ident("name", "用户名", "ok").
+		passwd("passwd", "密码", "bad pass").
+		text("description", "描述", "bad'text").
+		build()
+	if err == nil || err.Error() != `密码包含非法字符："bad pass"` {
+		t.Fatalf("err = %v, want the password error", err)
+	}
+}
+
+func TestVPNCmdAddUserRendersFullLine(t *testing.T) {
+	got, err := vpnCmdAddUser("zhangsan", "no", "dev^it^root", "P@ss;1", "张三 研发", "zhangsan@example.com", "2026-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "aaaa user user add name zhangsan invalid no group dev^it^root passwd 'P@ss;1' description '张三 研发' mail zhangsan@example.com inherit-role yes expire-date 2026-12-31"
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+	if _, err := vpnCmdAddUser("zhangsan\naaaa user user delete", "no", "root", "x", "d", "zhangsan@example.com", ""); err == nil {
+		t.Fatal("injected user name was accepted")
+	}
+}
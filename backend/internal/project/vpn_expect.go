@@ -0,0 +1,478 @@
+package project
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// vpnCmdTimeout is the overall limit for a single non-paged command.
+	vpnCmdTimeout = 20 * time.Second
+	// vpnExpectIdle bounds the silence while waiting for a known prompt. It
+	// only guards against a hung device; a command is normally finished as
+	// soon as the prompt comes back.
+	vpnExpectIdle = 30 * time.Second
+	// vpnExpectQuiet is the fallback when no prompt could be learned: the
+	// command counts as finished after this much silence.
+	vpnExpectQuiet = 1200 * time.Millisecond
+	// vpnBannerQuiet is the silence that ends the login banner.
+	vpnBannerQuiet = 300 * time.Millisecond
+)
+
+var (
+	vpnPagerRegex   = regexp.MustCompile(`-{2,}\s*\(?More\)?\s*(\(\d+%\))?\s*-{2,}`)
+	vpnConfirmRegex = regexp.MustCompile(`(?i)(\(y/n\)|\[y/n\]|\(yes/no\)|\[yes/no\]|\(y/n/q\)|\[y\]|确认.*[?？])\s*:?\s*$`)
+	vpnPromptRegex  = regexp.MustCompile(`^\S.{0,63}[#>$\]]$`)
+)
+
+var (
+	errVPNShellClosed      = errors.New("设备会话已断开")
+	errVPNPromptTimeout    = errors.New("等待设备提示符超时")
+	errVPNUnexpectedPrompt = errors.New("设备要求确认，已回答否")
+	errVPNWriteUnconfirmed = errors.New("连接中断，无法确认设备是否已执行该命令，请核对后再操作")
+)
+
+// vpnCmdResult is the outcome of one command. Status is 0 on success, the
+// first device error code (e.g. -24501) when the output carries one, and 1
+// when the output only reads like an error.
+type vpnCmdResult struct {
+	Output  string
+	Codes   []string
+	Status  int
+	Pages   int
+	Elapsed time.Duration
+}
+
+// vpnCmdStats accumulates command latency for one action.
+type vpnCmdStats struct {
+	Count int
+	Total time.Duration
+	Max   time.Duration
+}
+
+func (st *vpnCmdStats) add(d time.Duration) {
+	st.Count++
+	st.Total += d
+	st.Max = max(st.Max, d)
+}
+
+func (st vpnCmdStats) data() map[string]interface{} {
+	avg := time.Duration(0)
+	if st.Count > 0 {
+		avg = st.Total / time.Duration(st.Count)
+	}
+	return map[string]interface{}{
+		"count":    st.Count,
+		"total_ms": st.Total.Milliseconds(),
+		"avg_ms":   avg.Milliseconds(),
+		"max_ms":   st.Max.Milliseconds(),
+	}
+}
+
+func (r vpnCmdResult) hasCode(code string) bool {
+	for _, one := range r.Codes {
+		if one == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctx *vpnCtx) run(command string) (string, error) {
+	res, err := ctx.exec(command, vpnCmdTimeout, nil)
+	return res.Output, err
+}
+
+// runPaged is run for commands with long, paged output. onPage, when set, is
+// called with the number of pages read so far.
+func (ctx *vpnCtx) runPaged(command string, maxWait time.Duration, onPage func(int)) (string, error) {
+	res, err := ctx.exec(command, maxWait, onPage)
+	return res.Output, err
+}
+
+// exec runs command on the context's shell, opening it on first use. When the
+// shell turns out to be gone before the command produced any output, it is
+// replaced (redialing the connection if needed). A read-only command, or one
+// that never reached the device, is then sent once more; a write that was
+// sent is not, since the device may have run it before the connection
+// dropped, and the failure says so.
+func (ctx *vpnCtx) exec(command string, maxWait time.Duration, onPage func(int)) (vpnCmdResult, error) {
+	start := time.Now()
+	res, sent, err := ctx.execOnce(command, maxWait, onPage)
+	shellGone := func() bool {
+		return err != nil && strings.TrimSpace(res.Output) == "" && !errors.Is(err, errVPNPromptTimeout)
+	}
+	readOnly := vpnCmdReadOnly(command)
+	if shellGone() && (!sent || readOnly) {
+		res, sent, err = ctx.execOnce(command, maxWait, onPage)
+	}
+	if shellGone() && sent && !readOnly {
+		err = fmt.Errorf("%w：%w", errVPNWriteUnconfirmed, err)
+	}
+	res.Elapsed = time.Since(start)
+	ctx.stats.add(res.Elapsed)
+	return res, err
+}
+
+// execOnce runs command once; sent reports whether the command was written
+// to the device at all.
+func (ctx *vpnCtx) execOnce(command string, maxWait time.Duration, onPage func(int)) (res vpnCmdResult, sent bool, err error) {
+	if ctx.shell == nil {
+		sh, err := ctx.openShell()
+		if err != nil {
+			return vpnCmdResult{}, false, err
+		}
+		ctx.shell = sh
+	}
+	res, sent, err = ctx.shell.run(command, maxWait, onPage)
+	if err != nil {
+		// The shell may be mid-output or dead; start the next command on a
+		// fresh one.
+		ctx.closeShell()
+	}
+	return res, sent, err
+}
+
+func (ctx *vpnCtx) openShell() (*vpnShell, error) {
+	err := errors.New("SSH 连接未建立")
+	if ctx.client != nil {
+		var sh *vpnShell
+		if sh, err = vpnOpenShell(ctx.client); err == nil {
+			return sh, nil
+		}
+	}
+	if ctx.dial == nil {
+		return nil, err
+	}
+	client, dialErr := ctx.dial()
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	if ctx.client != nil {
+		_ = ctx.client.Close()
+	}
+	ctx.client = client
+	return vpnOpenShell(client)
+}
+
+func (ctx *vpnCtx) closeShell() {
+	if ctx.shell != nil {
+		ctx.shell.close()
+		ctx.shell = nil
+	}
+}
+
+// close ends the shell and the connection under it.
+func (ctx *vpnCtx) close() error {
+	ctx.closeShell()
+	if ctx.client == nil {
+		return nil
+	}
+	err := ctx.client.Close()
+	ctx.client = nil
+	return err
+}
+
+// vpnShell is an interactive shell on the gateway or firewall driven by
+// prompt matching rather than by waiting for the output to go quiet.
+type vpnShell struct {
+	session   *ssh.Session
+	stdin     io.Writer
+	chunks    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	prompt    []byte
+}
+
+func vpnOpenShell(client *ssh.Client) (*vpnShell, error) {
+	s, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	// A wide terminal keeps long commands from being wrapped in the echo.
+	if err = s.RequestPty("vt100", 40, 512, ssh.TerminalModes{}); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	stderr, err := s.StderrPipe()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	if err = s.Shell(); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	sh := newVPNShell(stdin, stdout, stderr)
+	sh.session = s
+	if err = sh.learnPrompt(); err != nil {
+		sh.close()
+		return nil, err
+	}
+	return sh, nil
+}
+
+// newVPNShell wires the engine to a shell's streams. The chunk channel is
+// closed once every reader hit EOF or the shell was closed; a closed shell's
+// readers stop even if nobody drains the channel any more.
+func newVPNShell(stdin io.Writer, readers ...io.Reader) *vpnShell {
+	sh := &vpnShell{stdin: stdin, chunks: make(chan []byte, 128), done: make(chan struct{})}
+	var wg sync.WaitGroup
+	for _, r := range readers {
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+			buf := make([]byte, 4096)
+			for {
+				select {
+				case <-sh.done:
+					return
+				default:
+				}
+				n, err := r.Read(buf)
+				if n > 0 {
+					one := make([]byte, n)
+					copy(one, buf[:n])
+					select {
+					case sh.chunks <- one:
+					case <-sh.done:
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(r)
+	}
+	go func() {
+		wg.Wait()
+		close(sh.chunks)
+	}()
+	return sh
+}
+
+func (sh *vpnShell) close() {
+	if sh == nil {
+		return
+	}
+	sh.closeOnce.Do(func() { close(sh.done) })
+	if sh.session != nil {
+		_ = sh.session.Close()
+	}
+}
+
+// learnPrompt reads the login banner and takes its last line as the prompt,
+// poking the device with an empty line once if the banner did not end in
+// one. Without a prompt the shell falls back to quiet-timer reads.
+func (sh *vpnShell) learnPrompt() error {
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			if _, err := sh.stdin.Write([]byte("\n")); err != nil {
+				return err
+			}
+		}
+		raw, err := sh.read(vpnBannerQuiet, 5*time.Second, false, false, nil)
+		if err != nil && !errors.Is(err, errVPNPromptTimeout) {
+			return err
+		}
+		if prompt := vpnLastLine(raw); vpnPromptRegex.Match(prompt) {
+			sh.prompt = prompt
+			return nil
+		}
+	}
+	return nil
+}
+
+// run sends command and collects its output up to the next prompt; sent is
+// false when the command could not be written. onPage, when set, is called
+// with the number of pager prompts answered so far. A confirmation question
+// is only answered with yes for commands that vpnCmdConfirms lists; any other
+// command gets no and fails.
+func (sh *vpnShell) run(command string, maxWait time.Duration, onPage func(int)) (res vpnCmdResult, sent bool, err error) {
+	if n, err := sh.stdin.Write([]byte(command + "\n")); err != nil {
+		// Part of a write may have reached the device.
+		return vpnCmdResult{}, n > 0, err
+	}
+	pages := 0
+	raw, err := sh.read(0, maxWait, true, vpnCmdConfirms(command), func() {
+		pages++
+		if onPage != nil {
+			onPage(pages)
+		}
+	})
+	out := vpnCleanOutput(raw, command, sh.prompt)
+	return vpnParseResult(out, pages), true, err
+}
+
+// read collects output until the prompt comes back (or, without a known
+// prompt or when quiet is set, until quiet elapses without new bytes).
+// Pager prompts are answered with a space and cut from the output where they
+// occurred. Confirmation questions are answered with yes when confirm is set
+// and with no otherwise; a declined question is reported once the prompt is
+// back.
+func (sh *vpnShell) read(quiet, maxWait time.Duration, interactive, confirm bool, onPage func()) ([]byte, error) {
+	idle := quiet
+	if idle <= 0 {
+		idle = vpnExpectIdle
+		if sh.prompt == nil {
+			idle = vpnExpectQuiet
+		}
+	}
+	idleTimer := time.NewTimer(idle)
+	defer idleTimer.Stop()
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+
+	var out []byte
+	var declined []byte
+	scanned, answered := 0, 0
+	for {
+		select {
+		case chunk, ok := <-sh.chunks:
+			if !ok {
+				return out, errVPNShellClosed
+			}
+			out = append(out, chunk...)
+			if interactive {
+				for {
+					loc := vpnPagerRegex.FindIndex(out[scanned:])
+					if loc == nil {
+						// Keep a tail so a marker split across chunks is
+						// still found on the next read.
+						scanned = max(scanned, len(out)-32)
+						break
+					}
+					start, end := scanned+loc[0], scanned+loc[1]
+					out = append(out[:start], out[end:]...)
+					scanned = start
+					if _, err := sh.stdin.Write([]byte(" ")); err != nil {
+						return out, err
+					}
+					if onPage != nil {
+						onPage()
+					}
+				}
+				if len(out) > answered {
+					if tail := vpnLastLine(out[answered:]); vpnConfirmRegex.Match(tail) {
+						answered = len(out)
+						answer := "y\n"
+						if bytes.Contains(bytes.ToLower(tail), []byte("yes")) {
+							answer = "yes\n"
+						}
+						if !confirm {
+							declined = tail
+							answer = "n\n"
+							if bytes.Contains(bytes.ToLower(tail), []byte("yes")) {
+								answer = "no\n"
+							}
+						}
+						if _, err := sh.stdin.Write([]byte(answer)); err != nil {
+							return out, err
+						}
+					}
+				}
+			}
+			if quiet <= 0 && sh.prompt != nil && bytes.Equal(vpnLastLine(out), sh.prompt) {
+				return out, vpnDeclinedError(declined)
+			}
+			if !idleTimer.Stop() {
+				select {
+				case <-idleTimer.C:
+				default:
+				}
+			}
+			idleTimer.Reset(idle)
+		case <-idleTimer.C:
+			if quiet > 0 || sh.prompt == nil {
+				return out, vpnDeclinedError(declined)
+			}
+			return out, errVPNPromptTimeout
+		case <-deadline.C:
+			if quiet > 0 || sh.prompt == nil {
+				return out, vpnDeclinedError(declined)
+			}
+			return out, errVPNPromptTimeout
+		}
+	}
+}
+
+func vpnDeclinedError(question []byte) error {
+	if question == nil {
+		return nil
+	}
+	return fmt.Errorf("%w：%s", errVPNUnexpectedPrompt, vpnDecodeOutput(question))
+}
+
+// vpnLastLine returns the last line of raw with escapes and surrounding
+// blanks removed, which is where a prompt or a question waits for input.
+func vpnLastLine(raw []byte) []byte {
+	clean := bytes.TrimRight(vpnStripTerminal(raw), " \t\r\n")
+	if i := bytes.LastIndexAny(clean, "\r\n"); i >= 0 {
+		clean = clean[i+1:]
+	}
+	return bytes.TrimSpace(clean)
+}
+
+func vpnStripTerminal(raw []byte) []byte {
+	return bytes.ReplaceAll(vpnANSIRegex.ReplaceAll(raw, nil), []byte("\b"), nil)
+}
+
+// vpnCleanOutput decodes raw and drops the command echo and the trailing
+// prompt, leaving only what the device printed for the command.
+func vpnCleanOutput(raw []byte, command string, prompt []byte) string {
+	out := vpnDecodeOutput(vpnStripTerminal(raw))
+	if len(prompt) > 0 {
+		trimmed := strings.TrimRight(out, " \t\r\n")
+		if strings.HasSuffix(trimmed, string(prompt)) {
+			out = strings.TrimSuffix(trimmed, string(prompt))
+		}
+	}
+	if i := strings.IndexByte(out, '\n'); i >= 0 {
+		echo := strings.Join(strings.Fields(out[:i]), "")
+		cmd := strings.Join(strings.Fields(command), "")
+		if echo != "" && (strings.Contains(echo, cmd) || strings.Contains(cmd, echo)) {
+			out = out[i+1:]
+		}
+	}
+	return out
+}
+
+func vpnParseResult(out string, pages int) vpnCmdResult {
+	res := vpnCmdResult{Output: out, Codes: vpnErrorCodes(out), Pages: pages}
+	switch {
+	case len(res.Codes) > 0:
+		res.Status, _ = strconv.Atoi(res.Codes[0])
+	case vpnOutputLooksError(out):
+		res.Status = 1
+	}
+	return res
+}
+
+// vpnErrorCodes returns the codes of the device error lines in out, in order.
+func vpnErrorCodes(out string) []string {
+	codes := make([]string, 0)
+	for _, m := range vpnErrorCodeRegex.FindAllStringSubmatch(out, -1) {
+		codes = append(codes, m[1])
+	}
+	return codes
+}
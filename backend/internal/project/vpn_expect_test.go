@@ -0,0 +1,219 @@
+package project
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeDevice plays the device side of a shell: it reads what the engine
+// types and writes the device's output.
+type fakeDevice struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (d *fakeDevice) write(t *testing.T, s string) {
+	if _, err := io.WriteString(d.out, s); err != nil {
+		t.Errorf("device write: %v", err)
+	}
+}
+
+func (d *fakeDevice) readLine() string {
+	line, _ := d.in.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// newFakeShell starts a shell whose device prints a banner ending in the
+// "DEV#" prompt and then hands every command line to serve.
+func newFakeShell(t *testing.T, serve func(d *fakeDevice, line string)) *vpnShell {
+	t.Helper()
+	stdinR, stdinW := io.Pipe()
+	outR, outW := io.Pipe()
+	t.Cleanup(func() {
+		stdinR.Close()
+		outW.Close()
+	})
+	sh := newVPNShell(stdinW, outR)
+	t.Cleanup(sh.close)
+	d := &fakeDevice{in: bufio.NewReader(stdinR), out: outW}
+	go func() {
+		d.write(t, "Welcome to the gateway\r\nDEV# ")
+		for {
+			line, err := d.in.ReadString('\n')
+			if err != nil {
+				return
+			}
+			serve(d, strings.TrimRight(line, "\r\n"))
+		}
+	}()
+	if err := sh.learnPrompt(); err != nil {
+		t.Fatal(err)
+	}
+	if string(sh.prompt) != "DEV#" {
+		t.Fatalf("prompt = %q, want DEV#", sh.prompt)
+	}
+	return sh
+}
+
+func TestVPNShellAnswersPager(t *testing.T) {
+	sh := newFakeShell(t, func(d *fakeDevice, line string) {
+		d.write(t, "user1\r\nuser2\r\n --More-- ")
+		if b, _ := d.in.ReadByte(); b != ' ' {
+			d.write(t, "\r\nunexpected key\r\nDEV# ")
+			return
+		}
+		d.write(t, "\r\nuser3\r\nDEV# ")
+	})
+	pages := 0
+	res, _, err := sh.run("aaaa user user search key-word name show-type page key-value ''", 5*time.Second, func(n int) { pages = n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 1 || res.Pages != 1 {
+		t.Fatalf("pages = %d/%d, want 1", pages, res.Pages)
+	}
+	if strings.Contains(res.Output, "More") || !strings.Contains(res.Output, "user1") || !strings.Contains(res.Output, "user3") {
+		t.Fatalf("output = %q", res.Output)
+	}
+}
+
+// confirmDevice asks before running any command and reports the answer.
+func confirmDevice(d *fakeDevice, t *testing.T, line string) {
+	d.write(t, "Are you sure? (y/n):")
+	d.write(t, "\r\nanswer="+d.readLine()+"\r\nDEV# ")
+}
+
+func TestVPNShellConfirmsOnlyListedCommands(t *testing.T) {
+	sh := newFakeShell(t, func(d *fakeDevice, line string) { confirmDevice(d, t, line) })
+
+	res, _, err := sh.run("aaaa user user delete index-key name index-value bob", 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(res.Output, "answer=y") {
+		t.Fatalf("delete output = %q, want answer=y", res.Output)
+	}
+
+	res, _, err = sh.run("aaaa user user modify-info invalid yes index-key name index-value bob", 5*time.Second, nil)
+	if !errors.Is(err, errVPNUnexpectedPrompt) {
+		t.Fatalf("modify err = %v, want errVPNUnexpectedPrompt", err)
+	}
+	if !strings.Contains(res.Output, "answer=n") {
+		t.Fatalf("modify output = %q, want answer=n", res.Output)
+	}
+}
+
+func TestVPNShellPromptTimeout(t *testing.T) {
+	sh := newFakeShell(t, func(d *fakeDevice, line string) {
+		d.write(t, "working...\r\n")
+	})
+	start := time.Now()
+	res, _, err := sh.run("aaaa user group show", 200*time.Millisecond, nil)
+	if !errors.Is(err, errVPNPromptTimeout) {
+		t.Fatalf("err = %v, want errVPNPromptTimeout", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("took %s, the deadline was not honoured", time.Since(start))
+	}
+	if !strings.Contains(res.Output, "working") {
+		t.Fatalf("output = %q", res.Output)
+	}
+}
+
+// endlessReader never runs dry, like a device that keeps printing.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestVPNShellCloseStopsReaders(t *testing.T) {
+	sh := newVPNShell(io.Discard, endlessReader{})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sh.chunks) < cap(sh.chunks) {
+		if time.Now().After(deadline) {
+			t.Fatal("reader never filled the buffer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The buffer is full, so the reader is parked on a send nobody takes.
+	sh.close()
+	closed := make(chan struct{})
+	go func() {
+		for range sh.chunks {
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader kept running after close")
+	}
+}
+
+func TestVPNExecResendsOnlyReadOnlyCommands(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		dials   int
+	}{
+		{"aaaa user user search key-word name show-type page key-value 'bob'", 1},
+		{"aaaa user user delete index-key name index-value bob", 0},
+		{"aaaa user user modify-info passwd Abc123 index-key name index-value bob", 0},
+	} {
+		// The connection drops as soon as the command arrives.
+		sh := newFakeShell(t, func(d *fakeDevice, line string) {
+			d.out.(io.Closer).Close()
+		})
+		dials := 0
+		ctx := &vpnCtx{shell: sh, dial: func() (*ssh.Client, error) {
+			dials++
+			return nil, errors.New("device offline")
+		}}
+		_, err := ctx.exec(tc.command, 5*time.Second, nil)
+		if err == nil {
+			t.Fatalf("%s: no error", tc.command)
+		}
+		if dials != tc.dials {
+			t.Fatalf("%s: redialed %d times, want %d", tc.command, dials, tc.dials)
+		}
+		if tc.dials == 0 && !errors.Is(err, errVPNWriteUnconfirmed) {
+			t.Fatalf("%s: err = %v, want errVPNWriteUnconfirmed", tc.command, err)
+		}
+	}
+}
+
+func TestVPNExecDoesNotFlagAWriteThatWasNeverSent(t *testing.T) {
+	dials := 0
+	ctx := &vpnCtx{dial: func() (*ssh.Client, error) {
+		dials++
+		return nil, errors.New("device offline")
+	}}
+	_, err := ctx.exec("aaaa user user delete index-key name index-value bob", 5*time.Second, nil)
+	if err == nil || errors.Is(err, errVPNWriteUnconfirmed) {
+		t.Fatalf("err = %v, want the dial error alone", err)
+	}
+	// Nothing reached the device, so the write is tried once more.
+	if dials != 2 {
+		t.Fatalf("dialed %d times, want 2", dials)
+	}
+}
+
+func TestVPNParseResultReadsOnlyErrorLines(t *testing.T) {
+	res := vpnParseResult("name bob invalid no description 电话 138-00138000 工号 -20240001 group dev^root expire-date 2026-12-31", 0)
+	if res.Status != 0 || len(res.Codes) != 0 {
+		t.Fatalf("plain output: status %d, codes %v", res.Status, res.Codes)
+	}
+	res = vpnParseResult("% Error(-24501): user not exist\r\n", 0)
+	if res.Status != -24501 || len(res.Codes) != 1 || !res.hasCode("-24501") {
+		t.Fatalf("error line: status %d, codes %v", res.Status, res.Codes)
+	}
+}
@@ -0,0 +1,125 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VPN accounts may carry an expiry date (expire_date, YYYY-MM-DD): the
+// account is valid through that day. The console keeps the dates itself and
+// disables expired accounts; the device only gets them when VPNDeviceExpiry
+// is on.
+
+const vpnExpiryLayout = "2006-01-02"
+
+// vpnExpiryParam reads expire_date. Empty means no expiry; a day already past
+// is rejected.
+func vpnExpiryParam(p map[string]interface{}) (string, error) {
+	raw := strings.TrimSpace(toString(p["expire_date"]))
+	if raw == "" {
+		return "", nil
+	}
+	day, err := time.ParseInLocation(vpnExpiryLayout, raw, time.Local)
+	if err != nil {
+		return "", errors.New("到期日期格式应为 YYYY-MM-DD")
+	}
+	if day.Format(vpnExpiryLayout) < time.Now().Format(vpnExpiryLayout) {
+		return "", errors.New("到期日期不能早于今天")
+	}
+	return day.Format(vpnExpiryLayout), nil
+}
+
+// vpnDeviceExpiry is the expire-date to put on a device command: expire when
+// the device keeps validity itself, otherwise nothing.
+func vpnDeviceExpiry(expire string) string {
+	if !runtimeCfg.VPNDeviceExpiry {
+		return ""
+	}
+	return expire
+}
+
+// vpnSetDeviceExpiry writes expire to the device when it keeps validity.
+func vpnSetDeviceExpiry(ctx *vpnCtx, name, expire string) (string, error) {
+	if vpnDeviceExpiry(expire) == "" {
+		return "", nil
+	}
+	cmd, err := vpnCmdModifyExpiry(name, expire)
+	if err != nil {
+		return "", err
+	}
+	ret, err := ctx.exec(cmd, vpnCmdTimeout, nil)
+	if err != nil && strings.TrimSpace(ret.Output) == "" {
+		return "", err
+	}
+	if ret.Status != 0 {
+		return ret.Output, errors.New("设备拒绝设置到期日期")
+	}
+	return ret.Output, nil
+}
+
+// vpnApplyExpiry records a new expiry on a successful modify result. A device
+// failure is reported in the log but does not undo the modification; the
+// console still tracks the date.
+func vpnApplyExpiry(ctx *vpnCtx, res *projectResult, name, expire string) {
+	if expire == "" {
+		return
+	}
+	res.Data["expire_date"] = expire
+	line := "到期日期：" + expire
+	if _, err := vpnSetDeviceExpiry(ctx, name, expire); err != nil {
+		line += "（设备未同步：" + err.Error() + "）"
+		res.Data["expire_error"] = err.Error()
+	}
+	res.Data["log_text"] = toString(res.Data["log_text"]) + "\n" + line
+}
+
+// vpnExtendExpiry moves a user's expiry to expire_date. With enable set the
+// account is also re-enabled, which is what an extension after an automatic
+// disable needs.
+func vpnExtendExpiry(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	expire, err := vpnExpiryParam(p)
+	if err != nil {
+		return projectResult{OK: false, Message: "延期失败", Error: err.Error()}
+	}
+	if expire == "" {
+		return projectResult{OK: false, Message: "延期失败", Error: "到期日期不能为空"}
+	}
+	n, searchOut, failed := vpnResolveTarget(ctx, p, "延期失败")
+	if n == "" {
+		return failed
+	}
+
+	out, err := vpnSetDeviceExpiry(ctx, n, expire)
+	if err != nil {
+		return projectResult{OK: false, Message: "延期失败", Error: err.Error(), Data: map[string]interface{}{"output": out}}
+	}
+	lines := []string{fmt.Sprintf("用户名：%s", n), "到期日期：" + expire}
+	enabled := false
+	if toBoolDefault(p["enable"], false) {
+		cmd, err := vpnCmdModifyInvalid(n, "no")
+		if err != nil {
+			return projectResult{OK: false, Message: "延期失败", Error: err.Error()}
+		}
+		ret, err := ctx.exec(cmd, vpnCmdTimeout, nil)
+		switch {
+		case err != nil && strings.TrimSpace(ret.Output) == "":
+			lines = append(lines, "启用账户失败："+err.Error())
+		case ret.Status != 0:
+			lines = append(lines, "启用账户失败：命令执行失败")
+		default:
+			enabled = true
+			lines = append(lines, "状态：启用")
+		}
+		out += ret.Output
+	}
+	return projectResult{OK: true, Message: "延期成功", Data: map[string]interface{}{
+		"vpn_user":      n,
+		"expire_date":   expire,
+		"enabled":       enabled,
+		"output":        out,
+		"search_output": searchOut,
+		"log_text":      strings.Join(lines, "\n"),
+	}}
+}
@@ -0,0 +1,236 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// vpnFirewallActions are the VPN actions that mirror their change to the
+// firewall's local user table when remote_firewall is set.
+var vpnFirewallActions = map[string]bool{
+	"add_user":             true,
+	"modify_password":      true,
+	"modify_status":        true,
+	"delete_users":         true,
+	"batch_add_users":      true,
+	"batch_reset_password": true,
+}
+
+// VPNActionUsesFirewall reports whether a VPN action needs the firewall
+// credential injected into its params.
+func VPNActionUsesFirewall(action string, params map[string]interface{}) bool {
+	if action == "reconcile" {
+		return true
+	}
+	return vpnFirewallActions[action] && toBoolDefault(params["remote_firewall"], false)
+}
+
+// vpnFirewall is a lazily opened connection to the firewall, shared by every
+// row of a batch so the firewall is dialed at most once per action.
+type vpnFirewall struct {
+	p   map[string]interface{}
+	ctx *vpnCtx
+	err error
+}
+
+// vpnFirewallFromParams returns nil unless the action asked for the firewall
+// to be kept in sync.
+func vpnFirewallFromParams(p map[string]interface{}) *vpnFirewall {
+	if !toBoolDefault(p["remote_firewall"], false) {
+		return nil
+	}
+	return &vpnFirewall{p: p}
+}
+
+func (fw *vpnFirewall) dial() (*vpnCtx, error) {
+	if fw.ctx != nil || fw.err != nil {
+		return fw.ctx, fw.err
+	}
+	cred := sshCredentialFromParams(fw.p, "__vpn_fw_")
+	if !toBoolDefault(fw.p["__vpn_fw_configured"], false) || !cred.Usable() {
+		msg := "未配置防火墙账号密码"
+		if reason := strings.TrimSpace(toString(fw.p["__vpn_fw_error"])); reason != "" {
+			msg += "，原因：" + reason
+		}
+		fw.err = errors.New(msg)
+		return nil, fw.err
+	}
+	ctx, err := newVPNCtx(firewallDevice(), cred)
+	if err != nil {
+		fw.err = fmt.Errorf("登录防火墙失败：%w", err)
+		return nil, fw.err
+	}
+	fw.ctx = ctx
+	return ctx, nil
+}
+
+func (fw *vpnFirewall) close() {
+	if fw != nil && fw.ctx != nil {
+		fw.ctx.close()
+		fw.ctx = nil
+	}
+}
+
+// mirror runs cmd on the firewall after the same change succeeded on the
+// gateway. The outcome is recorded under remote_* in res.Data and appended to
+// its log; a firewall failure never turns a gateway success into a failure.
+func (fw *vpnFirewall) mirror(res *projectResult, title, user, cmd string) {
+	if fw == nil || !res.OK {
+		return
+	}
+	if res.Data == nil {
+		res.Data = map[string]interface{}{}
+	}
+	var ret vpnCmdResult
+	reason := ""
+	fwCtx, err := fw.dial()
+	if err == nil {
+		ret, err = fwCtx.exec(cmd, vpnCmdTimeout, nil)
+	}
+	out := ret.Output
+	switch {
+	case err != nil && strings.TrimSpace(out) == "":
+		reason = err.Error()
+	case vpnIsUserNotFound(out):
+		reason = "用户不存在"
+	case vpnIsUserExists(out):
+		reason = "用户名已存在"
+	case ret.Status != 0:
+		reason = "命令执行失败"
+	}
+	line := fmt.Sprintf("防火墙同步%s：用户 %s 成功", title, user)
+	if reason != "" {
+		line = fmt.Sprintf("防火墙同步%s：用户 %s 失败：%s", title, user, reason)
+	}
+	res.Data["remote_ok"] = reason == ""
+	res.Data["remote_output"] = out
+	res.Data["remote_error"] = reason
+	res.Data["remote_log_text"] = line
+	if logText := strings.TrimSpace(toString(res.Data["log_text"])); logText != "" {
+		line = logText + "\n\n" + line
+	}
+	res.Data["log_text"] = line
+}
+
+// vpnListAllUsers pages through every user on a device, reporting progress
+// with label ("VPN" or "防火墙").
+func vpnListAllUsers(ctx *vpnCtx, p map[string]interface{}, label string) ([]vpnSearchItem, string, error) {
+	cmd, err := vpnCmdListUsers()
+	if err != nil {
+		return nil, "", err
+	}
+	emitProgress(p, fmt.Sprintf("正在读取%s用户列表...", label), 0, 0)
+	out, err := ctx.runPaged(cmd, 10*time.Minute, func(pages int) {
+		emitProgress(p, fmt.Sprintf("%s用户列表：已读取第 %d 页", label, pages), 0, 0)
+	})
+	if err != nil && strings.TrimSpace(out) == "" {
+		return nil, out, err
+	}
+	items, _ := vpnBuildSearchResult(out)
+	if len(items) == 0 && vpnOutputLooksError(out) {
+		return nil, out, errors.New("命令执行失败")
+	}
+	return items, out, nil
+}
+
+// vpnReconcile lists the users on the gateway and on the firewall and reports
+// accounts that exist on only one side or whose status differs.
+func vpnReconcile(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	fw := &vpnFirewall{p: p}
+	defer fw.close()
+	fwCtx, err := fw.dial()
+	if err != nil {
+		return projectResult{OK: false, Message: "对账失败", Error: err.Error()}
+	}
+
+	gwItems, out, err := vpnListAllUsers(ctx, p, "VPN")
+	if err != nil {
+		return projectResult{OK: false, Message: "对账失败", Error: "读取VPN用户失败：" + err.Error(), Data: map[string]interface{}{"output": truncate(out, 2000)}}
+	}
+	fwItems, out, err := vpnListAllUsers(fwCtx, p, "防火墙")
+	if err != nil {
+		return projectResult{OK: false, Message: "对账失败", Error: "读取防火墙用户失败：" + err.Error(), Data: map[string]interface{}{"output": truncate(out, 2000)}}
+	}
+
+	gw := make(map[string]vpnSearchItem, len(gwItems))
+	for _, item := range gwItems {
+		gw[item.Name] = item
+	}
+	fwUsers := make(map[string]vpnSearchItem, len(fwItems))
+	for _, item := range fwItems {
+		fwUsers[item.Name] = item
+	}
+	names := make([]string, 0, len(gw)+len(fwUsers))
+	for name := range gw {
+		names = append(names, name)
+	}
+	for name := range fwUsers {
+		if _, ok := gw[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	items := make([]map[string]interface{}, 0)
+	rows := make([][]interface{}, 0)
+	logs := make([]string, 0)
+	missingFW, missingGW, mismatch := 0, 0, 0
+	for _, name := range names {
+		g, inGW := gw[name]
+		f, inFW := fwUsers[name]
+		issue, issueText := "", ""
+		switch {
+		case !inFW:
+			missingFW++
+			issue, issueText = "missing_on_firewall", "防火墙缺少该用户"
+		case !inGW:
+			missingGW++
+			issue, issueText = "missing_on_gateway", "VPN缺少该用户"
+		case g.Status != f.Status:
+			mismatch++
+			issue, issueText = "status_mismatch", "状态不一致"
+		default:
+			continue
+		}
+		items = append(items, map[string]interface{}{
+			"vpn_user":        name,
+			"issue":           issue,
+			"issue_text":      issueText,
+			"gateway_status":  g.Status,
+			"firewall_status": f.Status,
+		})
+		rows = append(rows, []interface{}{name, issueText, g.StatusText, f.StatusText, g.Description, g.Group})
+		logs = append(logs, fmt.Sprintf("%s：%s（VPN：%s，防火墙：%s）", name, issueText, vpnStatusLabel(g), vpnStatusLabel(f)))
+	}
+
+	summary := fmt.Sprintf("VPN用户 %d 个，防火墙用户 %d 个；防火墙缺少 %d 个，VPN缺少 %d 个，状态不一致 %d 个", len(gwItems), len(fwItems), missingFW, missingGW, mismatch)
+	emitProgress(p, summary, len(names), len(names))
+	data := map[string]interface{}{
+		"items":               items,
+		"gateway_total":       len(gwItems),
+		"firewall_total":      len(fwItems),
+		"missing_on_firewall": missingFW,
+		"missing_on_gateway":  missingGW,
+		"status_mismatch":     mismatch,
+	}
+	if len(rows) > 0 {
+		name, err := writeExportSheet("vpn", "vpn_reconcile", []string{"用户名", "差异", "VPN状态", "防火墙状态", "描述", "所属父组"}, rows)
+		if err != nil {
+			logs = append(logs, "保存对账报告失败："+err.Error())
+		} else {
+			data["export_file"] = name
+		}
+	}
+	data["log_text"] = strings.TrimSpace(summary + "\n\n" + strings.Join(logs, "\n"))
+	return projectResult{OK: true, Message: fmt.Sprintf("对账完成，差异 %d 个", len(items)), Data: data}
+}
+
+func vpnStatusLabel(item vpnSearchItem) string {
+	if item.Name == "" {
+		return "不存在"
+	}
+	return item.StatusText
+}
@@ -0,0 +1,179 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vpnOnlineItem is one connected session as listed by "aaaa online-user show".
+type vpnOnlineItem struct {
+	SessionID string `json:"session_id"`
+	User      string `json:"user"`
+	ClientIP  string `json:"client_ip"`
+	LoginTime string `json:"login_time"`
+	BytesIn   int64  `json:"bytes_in"`
+	BytesOut  int64  `json:"bytes_out"`
+}
+
+// vpnErrNotOnline is what the gateway answers when a kick finds no session.
+const vpnErrNotOnline = "-24801"
+
+var vpnOnlineRegex = regexp.MustCompile(`session-id\s+(\S+)\s+name\s+(\S+)\s+client-ip\s+(\S+)\s+login-time\s+(.+?)\s+bytes-in\s+(\d+)\s+bytes-out\s+(\d+)`)
+
+func vpnParseOnline(out string) []vpnOnlineItem {
+	items := make([]vpnOnlineItem, 0)
+	for _, line := range strings.Split(strings.ReplaceAll(out, "\r", ""), "\n") {
+		m := vpnOnlineRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		in, _ := strconv.ParseInt(m[5], 10, 64)
+		outBytes, _ := strconv.ParseInt(m[6], 10, 64)
+		items = append(items, vpnOnlineItem{
+			SessionID: m[1],
+			User:      m[2],
+			ClientIP:  m[3],
+			LoginTime: strings.TrimSpace(m[4]),
+			BytesIn:   in,
+			BytesOut:  outBytes,
+		})
+	}
+	return items
+}
+
+func vpnFormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit && exp < 3; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func vpnFetchOnline(ctx *vpnCtx, name string) ([]vpnOnlineItem, string, error) {
+	cmd, err := vpnCmdListOnline(name)
+	if err != nil {
+		return nil, "", err
+	}
+	ret, err := ctx.exec(cmd, 2*time.Minute, nil)
+	out := ret.Output
+	if err != nil && strings.TrimSpace(out) == "" {
+		return nil, out, err
+	}
+	if ret.hasCode(vpnErrNotOnline) {
+		return []vpnOnlineItem{}, out, nil
+	}
+	items := vpnParseOnline(out)
+	if len(items) == 0 && ret.Status != 0 {
+		return nil, out, errors.New("命令执行失败")
+	}
+	return items, out, nil
+}
+
+func vpnListOnline(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	name := strings.TrimSpace(toString(p["vpn_user"]))
+	items, out, err := vpnFetchOnline(ctx, name)
+	if err != nil {
+		return projectResult{OK: false, Message: "查询在线用户失败", Error: err.Error(), Data: map[string]interface{}{"output": truncate(out, 2000)}}
+	}
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, fmt.Sprintf("用户：%s\n客户端IP：%s\n登录时间：%s\n流量：接收 %s / 发送 %s\n会话编号：%s",
+			item.User, item.ClientIP, item.LoginTime, vpnFormatBytes(item.BytesIn), vpnFormatBytes(item.BytesOut), item.SessionID))
+	}
+	msg := fmt.Sprintf("当前在线 %d 个会话", len(items))
+	logText := strings.Join(lines, "\n\n")
+	if len(items) == 0 {
+		msg = "当前无在线会话"
+		logText = msg
+		if name != "" {
+			logText = fmt.Sprintf("用户 %s 当前不在线", name)
+		}
+	}
+	return projectResult{OK: true, Message: msg, Data: map[string]interface{}{"items": items, "total": len(items), "output": out, "log_text": logText}}
+}
+
+// vpnKickOne ends the sessions selected by key/value. notOnline is set when
+// there was nothing to end, which callers do not treat as an error.
+func vpnKickOne(ctx *vpnCtx, key, value string) (bool, bool, string, error) {
+	cmd, err := vpnCmdKickOnline(key, value)
+	if err != nil {
+		return false, false, "", err
+	}
+	ret, err := ctx.exec(cmd, vpnCmdTimeout, nil)
+	out := ret.Output
+	if ret.hasCode(vpnErrNotOnline) {
+		return false, true, out, nil
+	}
+	if err != nil && strings.TrimSpace(out) == "" {
+		return false, false, out, err
+	}
+	if ret.Status != 0 {
+		return false, false, out, errors.New("命令执行失败")
+	}
+	return true, false, out, nil
+}
+
+func vpnKickLog(target string, ok, notOnline bool, err error) string {
+	switch {
+	case ok:
+		return fmt.Sprintf("%s 已强制下线", target)
+	case notOnline:
+		return fmt.Sprintf("%s 当前不在线", target)
+	default:
+		return fmt.Sprintf("%s 强制下线失败：%s", target, errString(err))
+	}
+}
+
+func vpnKickOnline(ctx *vpnCtx, p map[string]interface{}) projectResult {
+	if sid := strings.TrimSpace(toString(p["session_id"])); sid != "" {
+		ok, notOnline, out, err := vpnKickOne(ctx, "session-id", sid)
+		logText := vpnKickLog("会话 "+sid, ok, notOnline, err)
+		if !ok && !notOnline {
+			return projectResult{OK: false, Message: "强制下线失败", Error: errString(err), Data: map[string]interface{}{"output": out, "log_text": logText}}
+		}
+		item := map[string]interface{}{"session_id": sid, "ok": ok, "not_online": notOnline, "output": out}
+		return projectResult{OK: true, Message: logText, Data: map[string]interface{}{"items": []map[string]interface{}{item}, "log_text": logText}}
+	}
+
+	users := normalizeUsers(p["vpn_users"])
+	if len(users) == 0 {
+		users = normalizeUsers(p["vpn_user"])
+	}
+	if len(users) == 0 {
+		return projectResult{OK: false, Message: "强制下线失败", Error: "用户名或会话编号不能为空"}
+	}
+	items := make([]map[string]interface{}, 0, len(users))
+	logs := make([]string, 0, len(users))
+	okCount := 0
+	for i, u := range users {
+		ok, notOnline, out, err := vpnKickOne(ctx, "name", u)
+		if ok {
+			okCount++
+		}
+		logs = append(logs, vpnKickLog("用户 "+u, ok, notOnline, err))
+		items = append(items, map[string]interface{}{"vpn_user": u, "ok": ok, "not_online": notOnline, "output": out, "error": errString(err)})
+		emitProgress(p, fmt.Sprintf("强制下线：%s", u), i+1, len(users))
+	}
+	return projectResult{OK: true, Message: fmt.Sprintf("强制下线完成 %d/%d", okCount, len(users)), Data: map[string]interface{}{"items": items, "log_text": strings.Join(logs, "\n")}}
+}
+
+// vpnKickAfterChange ends user's sessions once an account was disabled or
+// deleted, when the caller asked for it with kick_online. The returned line is
+// meant for the action's log; it is empty when nothing was requested.
+func vpnKickAfterChange(ctx *vpnCtx, p map[string]interface{}, user string) (map[string]interface{}, string) {
+	if !toBoolDefault(p["kick_online"], false) {
+		return nil, ""
+	}
+	ok, notOnline, out, err := vpnKickOne(ctx, "name", user)
+	data := map[string]interface{}{"kick_ok": ok, "kick_not_online": notOnline, "kick_output": out, "kick_error": errString(err)}
+	return data, vpnKickLog("用户 "+user, ok, notOnline, err)
+}
@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"ops-admin-backend/internal/project"
+)
+
+// Steps shared by the roster and desired-state workflows, which both plan
+// through project.PlanAccounts and apply the items the operator approved.
+
+// listPlanAccounts reads the account lists of systems for plan job jobID,
+// logging one line per system. A system that cannot be read is left out of
+// the lists and its title returned in failed.
+func (s *server) listPlanAccounts(jobID string, u authedUser, systems []string) (map[string][]project.PersonAccount, []string) {
+	lists := make(map[string][]project.PersonAccount, len(systems))
+	failed := make([]string, 0)
+	for idx, system := range systems {
+		title := project.WorkflowSystemTitle(system)
+		accounts, err := s.listAccounts(u, system)
+		line := fmt.Sprintf("%s：读取 %d 个账号", title, len(accounts))
+		if err != nil {
+			failed = append(failed, title)
+			line = fmt.Sprintf("%s：读取失败，未纳入计划：%s", title, strings.TrimSpace(err.Error()))
+		} else {
+			lists[system] = accounts
+		}
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, line)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = idx + 1
+			job.Total = len(systems)
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+	return lists, failed
+}
+
+// planReportOnlyNote counts the items that carry a change their system
+// cannot make, for the plan summary; empty when there are none.
+func planReportOnlyNote(items []project.AccountPlanItem) string {
+	n := 0
+	for _, one := range items {
+		for _, change := range one.Changes {
+			if !change.Supported {
+				n++
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("；%d 项含系统无法修改的属性（如 AD 部门与组织单位），仅报告，需手动处理", n)
+}
+
+// planSelection picks the items of plan named by keys. Every key must name
+// an actionable item of the plan, once.
+func planSelection(plan []project.AccountPlanItem, keys []string) ([]project.AccountPlanItem, error) {
+	byKey := make(map[string]project.AccountPlanItem, len(plan))
+	for _, one := range plan {
+		byKey[one.Key] = one
+	}
+	picked := make([]project.AccountPlanItem, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		item, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("计划项 %s 不在计划中", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("计划项 %s 重复提交", key)
+		}
+		seen[key] = true
+		if !item.Actionable {
+			return nil, fmt.Errorf("计划项 %s 仅报告，不能执行", key)
+		}
+		picked = append(picked, item)
+	}
+	return picked, nil
+}
+
+// applyPlanItem carries out one plan item of workflow. A new account's
+// password is returned in the item so it can be handed over.
+func (s *server) applyPlanItem(u authedUser, workflow string, plan project.AccountPlanItem) map[string]interface{} {
+	item := map[string]interface{}{
+		"key":      plan.Key,
+		"system":   plan.System,
+		"op":       plan.Op,
+		"op_text":  project.AccountOpTitle(plan.Op),
+		"username": plan.Username,
+		"ok":       false,
+	}
+	action, params, password, err := project.AccountPlanStep(plan)
+	if err != nil {
+		item["summary"] = "失败：" + err.Error()
+		return item
+	}
+	if reason := s.applyWorkflowAction(u, workflow, plan.System, action, params); reason != "" {
+		item["summary"] = "失败：" + reason
+		return item
+	}
+	item["ok"] = true
+	item["password"] = password
+	item["summary"] = "执行成功"
+	return item
+}
+
+// runPlanApply is the apply job of workflow: it carries out the approved
+// plan items one by one and reports each outcome by key.
+func (s *server) runPlanApply(jobID string, u authedUser, workflow string, plan []project.AccountPlanItem) {
+	total := len(plan)
+	items := make([]map[string]interface{}, 0, total)
+	okCount := 0
+	for idx, one := range plan {
+		item := s.applyPlanItem(u, workflow, one)
+		items = append(items, item)
+		if item["ok"] == true {
+			okCount++
+		}
+		line := fmt.Sprintf("%s %s（%s）：%s", project.WorkflowSystemTitle(one.System), one.Username, project.AccountOpTitle(one.Op), item["summary"])
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, line)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = idx + 1
+			job.Total = total
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+
+	summary := fmt.Sprintf("执行完成：成功 %d，失败 %d，共 %d 项", okCount, total-okCount, total)
+	s.logAction(u.ID, u.Username, "workflow_"+workflow+"_apply", "workflow", summary)
+	resultFile, err := project.AccountApplyReport(workflow, items)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	resultItems := make([]interface{}, 0, len(items))
+	for _, one := range items {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = okCount == total
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = fmt.Sprintf("%d 项执行失败", total-okCount)
+		}
+		job.LogLines = append(job.LogLines, summary)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -40,8 +41,15 @@ type asyncOperateJob struct {
 	LogLines    []string
 	ResultText  string
 	ResultItems []interface{}
+	ResultFile  string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+
+	// Issues, Plan and StatePlan keep what a reconcile or plan job computed,
+	// so the follow-up job can only act on what the server itself produced.
+	Issues    []project.ReconcileIssue
+	Plan      []project.AccountPlanItem
+	StatePlan *statePlan
 }
 
 type asyncOperateJobView struct {
@@ -59,6 +67,7 @@ type asyncOperateJobView struct {
 	LogLines    []string      `json:"log_lines"`
 	ResultText  string        `json:"result_text"`
 	ResultItems []interface{} `json:"result_items"`
+	ResultFile  string        `json:"result_file"`
 	CreatedAt   string        `json:"created_at"`
 	UpdatedAt   string        `json:"updated_at"`
 }
@@ -84,16 +93,8 @@ func (s *server) handleProjectOperateAsyncStart(w http.ResponseWriter, r *http.R
 	if params == nil {
 		params = map[string]interface{}{}
 	}
-	if req.ProjectType == "vpn" && req.Action == "delete_users" && toBoolDefault(params["remote_firewall"], false) {
-		fwAccount, fwPassword, fwErr := s.getProjectCredential(u.ID, "vpn_firewall")
-		if fwErr != nil {
-			params["__vpn_fw_configured"] = false
-			params["__vpn_fw_error"] = fwErr.Error()
-		} else {
-			params["__vpn_fw_configured"] = true
-			params["__vpn_fw_account"] = fwAccount
-			params["__vpn_fw_password"] = fwPassword
-		}
+	if req.ProjectType == "vpn" && project.VPNActionUsesFirewall(req.Action, params) {
+		s.injectFirewallCredential(u.ID, params)
 	}
 
 	_, didLogin, _, err := s.ensureProjectSession(u, req.ProjectType, false)
@@ -270,6 +271,7 @@ func (s *server) runAsyncOperate(jobID string, u authedUser, projectType, action
 		}
 		job.Error = ""
 		job.ResultItems = normalizeResultItems(res.Data)
+		job.ResultFile, _ = res.Data["export_file"].(string)
 		logText := extractLogText(res.Data)
 		if logText != "" {
 			if len(job.LogLines) <= 1 {
@@ -290,6 +292,9 @@ func (s *server) runAsyncOperate(jobID string, u authedUser, projectType, action
 		job.Progress = 100
 	})
 	s.logAction(u.ID, u.Username, "project_operate", projectType, fmt.Sprintf("action=%s", action))
+	if projectType == "vpn" {
+		s.trackVPNExpiry(u, action, res)
+	}
 }
 
 func calcJobProgress(processed, total, logCount int, done bool) int {
@@ -327,6 +332,24 @@ func (s *server) updateAsyncOperateJob(jobID string, fn func(*asyncOperateJob))
 	job.UpdatedAt = time.Now()
 }
 
+// finishedWorkflowJob returns a copy of u's successful job jobID of action,
+// for a follow-up job that acts on what it computed.
+func (s *server) finishedWorkflowJob(u authedUser, jobID, action string) (asyncOperateJob, error) {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+	job, ok := s.jobs[strings.TrimSpace(jobID)]
+	if !ok || job.UserID != u.ID || job.Action != action {
+		return asyncOperateJob{}, errors.New("结果不存在或已过期，请重新生成")
+	}
+	if !job.Done {
+		return asyncOperateJob{}, errors.New("任务尚未完成")
+	}
+	if !job.OK {
+		return asyncOperateJob{}, errors.New("任务未成功完成，请重新生成")
+	}
+	return *job, nil
+}
+
 func (s *server) getAsyncOperateJobView(jobID string, userID int64) (asyncOperateJobView, bool) {
 	s.jobMu.Lock()
 	defer s.jobMu.Unlock()
@@ -349,12 +372,47 @@ func (s *server) getAsyncOperateJobView(jobID string, userID int64) (asyncOperat
 		LogLines:    append([]string(nil), job.LogLines...),
 		ResultText:  job.ResultText,
 		ResultItems: append([]interface{}(nil), job.ResultItems...),
+		ResultFile:  job.ResultFile,
 		CreatedAt:   job.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:   job.UpdatedAt.Format(time.RFC3339),
 	}
+	if job.Done {
+		// Passwords are handed out once, with the finished result; later
+		// reads of the job no longer carry them.
+		job.ResultItems = withoutPasswords(job.ResultItems).([]interface{})
+	}
 	return view, true
 }
 
+// withoutPasswords returns a copy of v with every "password" key dropped from
+// the maps inside it.
+func withoutPasswords(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, one := range x {
+			if k != "password" {
+				out[k] = withoutPasswords(one)
+			}
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]map[string]interface{}, 0, len(x))
+		for _, one := range x {
+			out = append(out, withoutPasswords(one).(map[string]interface{}))
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(x))
+		for _, one := range x {
+			out = append(out, withoutPasswords(one))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
 func (s *server) purgeAsyncJobsLocked(now time.Time) {
 	if s.jobs == nil {
 		return
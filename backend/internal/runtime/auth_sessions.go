@@ -45,10 +45,10 @@ func (s *server) cleanupUserAuthTokens(userID int64) {
 func (s *server) loadAuthedUser(token, now string) (authedUser, error) {
 	var u authedUser
 	err := s.db.QueryRow(
-		`SELECT a.id,a.username,t.token FROM auth_tokens t JOIN admins a ON a.id=t.user_id WHERE t.token=? AND t.expires_at>?`,
+		`SELECT a.id,a.username,a.role,t.token FROM auth_tokens t JOIN admins a ON a.id=t.user_id WHERE t.token=? AND t.expires_at>?`,
 		token,
 		now,
-	).Scan(&u.ID, &u.Username, &u.Token)
+	).Scan(&u.ID, &u.Username, &u.Role, &u.Token)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return authedUser{}, sql.ErrNoRows
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"ops-admin-backend/internal/project"
+	"ops-admin-backend/internal/simulate"
 
 	_ "modernc.org/sqlite"
 )
@@ -22,16 +23,37 @@ type authedUser struct {
 	ID       int64
 	Username string
 	Token    string
+	Role     string
 }
 
+const (
+	roleAdmin    = "admin"
+	roleOperator = "operator"
+)
+
 type appConfig struct {
-	ADAPIURL        string
-	PrintAPIURL     string
-	VPNSshAddr      string
-	FirewallSSHAddr string
-	CredentialKey   string
-	ProjectCacheTTL time.Duration
-	SessionIdleTTL  time.Duration
+	ADAPIURL           string
+	PrintAPIURL        string
+	PrintBootstrapCSRF string
+	PrintAESKey        string
+	VPNSshAddr         string
+	VPNSSHPort         int
+	FirewallSSHAddr    string
+	FirewallSSHPort    int
+	CredentialKey      string
+	ProjectCacheTTL    time.Duration
+	SessionIdleTTL     time.Duration
+	Simulate           bool
+	SimulateAccount    string
+	SimulatePassword   string
+	VPNDeviceExpiry    bool
+	VPNExpirySweep     time.Duration
+	VPNExpiryRemind    time.Duration
+	VPNExpiryWebhook   string
+	OffboardPolicy     map[string]string
+	ProtectedAccounts  project.ProtectedAccounts
+	StatePruneMax      int
+	AdminUsers         []string
 }
 
 type server struct {
@@ -82,8 +104,11 @@ type browserCloseEventReq struct {
 }
 
 type projectCredentialReq struct {
-	Account  string `json:"account"`
-	Password string `json:"password"`
+	Account    string `json:"account"`
+	Password   string `json:"password"`
+	AuthMethod string `json:"auth_method"`
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
 }
 
 type operateReq struct {
@@ -119,12 +144,26 @@ const browserCloseLogGracePeriod = 3 * time.Second
 func Run() {
 	loadEnvFiles(".env", "../.env")
 	cfg := loadAppConfig()
+	var sim *simulate.Env
+	if cfg.Simulate {
+		var err error
+		if sim, err = startSimulators(&cfg); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer sim.Close()
+		log.Printf("simulate mode: ad=%s print=%s vpn=%s:%d firewall=%s:%d", cfg.ADAPIURL, cfg.PrintAPIURL, cfg.VPNSshAddr, cfg.VPNSSHPort, cfg.FirewallSSHAddr, cfg.FirewallSSHPort)
+	}
 	runtimeCfg = cfg
 	project.SetConfig(project.Config{
-		ADAPIURL:        cfg.ADAPIURL,
-		PrintAPIURL:     cfg.PrintAPIURL,
-		VPNSshAddr:      cfg.VPNSshAddr,
-		FirewallSSHAddr: cfg.FirewallSSHAddr,
+		ADAPIURL:           cfg.ADAPIURL,
+		PrintAPIURL:        cfg.PrintAPIURL,
+		PrintBootstrapCSRF: cfg.PrintBootstrapCSRF,
+		PrintAESKey:        cfg.PrintAESKey,
+		VPNSshAddr:         cfg.VPNSshAddr,
+		VPNSSHPort:         cfg.VPNSSHPort,
+		FirewallSSHAddr:    cfg.FirewallSSHAddr,
+		FirewallSSHPort:    cfg.FirewallSSHPort,
+		VPNDeviceExpiry:    cfg.VPNDeviceExpiry,
 	})
 
 	dbPath := filepath.Clean("./db/ops_admin.db")
@@ -146,6 +185,9 @@ func Run() {
 	if err = initDB(db, cfg); err != nil {
 		log.Fatalf("init db failed: %v", err)
 	}
+	if len(cfg.AdminUsers) == 0 {
+		log.Printf("ADMIN_USERS is empty: no account can confirm SSH host keys")
+	}
 
 	srv := &server{
 		db:                 db,
@@ -155,6 +197,14 @@ func Run() {
 		projectSessions:    newProjectSessionManager(),
 		browserCloseStates: make(map[string]*browserCloseState),
 	}
+	project.SetHostKeyStore(sqliteHostKeyStore{s: srv})
+	if sim != nil {
+		if err = srv.trustSimulatorHostKeys(sim); err != nil {
+			log.Fatalf("trust simulator host keys failed: %v", err)
+		}
+	}
+
+	go srv.runVPNExpirySweeper()
 
 	addr := os.Getenv("ADDR")
 	if addr == "" {
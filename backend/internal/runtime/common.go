@@ -16,16 +16,22 @@ import (
 
 func validProjectType(t string) bool {
 	switch t {
-	case "ad", "print", "vpn":
+	case "ad", "print", "vpn", "firewall":
 		return true
 	default:
 		return false
 	}
 }
 
+// sshCredentialProjectType reports whether a credential logs in to an SSH
+// device and may therefore use key or keyboard-interactive auth.
+func sshCredentialProjectType(t string) bool {
+	return t == "vpn" || t == "firewall"
+}
+
 func validCredentialProjectType(t string) bool {
 	switch t {
-	case "ad", "print", "vpn", "vpn_firewall":
+	case "ad", "print", "vpn", "firewall":
 		return true
 	default:
 		return false
@@ -1,11 +1,14 @@
 package runtime
 
 import (
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"ops-admin-backend/internal/project"
 )
 
 func loadEnvFiles(paths ...string) {
@@ -52,14 +55,51 @@ func loadAppConfig() appConfig {
 	if idleMinutes <= 0 {
 		idleMinutes = 60
 	}
+	sweepMinutes := envInt("VPN_EXPIRY_SWEEP_MINUTES", 60)
+	if sweepMinutes <= 0 {
+		sweepMinutes = 60
+	}
+	remindDays := envInt("VPN_EXPIRY_REMIND_DAYS", 7)
+	if remindDays < 0 {
+		remindDays = 7
+	}
+	offboardPolicy, err := project.ParseOffboardPolicy(envString("OFFBOARD_POLICY", ""))
+	if err != nil {
+		log.Printf("invalid OFFBOARD_POLICY, using defaults: %v", err)
+		offboardPolicy, _ = project.ParseOffboardPolicy("")
+	}
+	pruneMax := envInt("STATE_PRUNE_MAX_PERCENT", 10)
+	if pruneMax < 0 || pruneMax > 100 {
+		pruneMax = 10
+	}
+	protected, err := project.ParseProtectedAccounts(envString("PROTECTED_ACCOUNTS", project.DefaultProtectedAccounts))
+	if err != nil {
+		log.Printf("invalid PROTECTED_ACCOUNTS, using defaults: %v", err)
+		protected, _ = project.ParseProtectedAccounts(project.DefaultProtectedAccounts)
+	}
 	return appConfig{
-		ADAPIURL:        normalizeBaseURL(envString("AD_API_URL", "http://ad.example.internal/")),
-		PrintAPIURL:     normalizeBaseURL(envString("PRINT_API_URL", "http://print.example.internal/printhub/")),
-		VPNSshAddr:      strings.TrimSpace(envString("VPN_SSH_ADDR", "vpn.example.internal")),
-		FirewallSSHAddr: strings.TrimSpace(envString("FIREWALL_SSH_ADDR", "firewall.example.internal")),
-		CredentialKey:   envString("CREDENTIAL_SECRET", "change-me-ops-credential-secret"),
-		ProjectCacheTTL: time.Duration(ttlMinutes) * time.Minute,
-		SessionIdleTTL:  time.Duration(idleMinutes) * time.Minute,
+		ADAPIURL:           normalizeBaseURL(envString("AD_API_URL", "http://ad.example.internal/")),
+		PrintAPIURL:        normalizeBaseURL(envString("PRINT_API_URL", "http://print.example.internal/printhub/")),
+		PrintBootstrapCSRF: envString("PRINT_BOOTSTRAP_CSRF", ""),
+		PrintAESKey:        envString("PRINT_AES_KEY", ""),
+		VPNSshAddr:         strings.TrimSpace(envString("VPN_SSH_ADDR", "vpn.example.internal")),
+		VPNSSHPort:         envInt("VPN_SSH_PORT", 22),
+		FirewallSSHAddr:    strings.TrimSpace(envString("FIREWALL_SSH_ADDR", "firewall.example.internal")),
+		FirewallSSHPort:    envInt("FIREWALL_SSH_PORT", 22),
+		CredentialKey:      envString("CREDENTIAL_SECRET", "change-me-ops-credential-secret"),
+		ProjectCacheTTL:    time.Duration(ttlMinutes) * time.Minute,
+		SessionIdleTTL:     time.Duration(idleMinutes) * time.Minute,
+		Simulate:           envBool("SIMULATE", false),
+		SimulateAccount:    envString("SIMULATE_ACCOUNT", ""),
+		SimulatePassword:   envString("SIMULATE_PASSWORD", ""),
+		VPNDeviceExpiry:    envBool("VPN_DEVICE_EXPIRY", false),
+		VPNExpirySweep:     time.Duration(sweepMinutes) * time.Minute,
+		VPNExpiryRemind:    time.Duration(remindDays) * 24 * time.Hour,
+		VPNExpiryWebhook:   envString("VPN_EXPIRY_WEBHOOK_URL", ""),
+		OffboardPolicy:     offboardPolicy,
+		ProtectedAccounts:  protected,
+		StatePruneMax:      pruneMax,
+		AdminUsers:         envList("ADMIN_USERS"),
 	}
 }
 
@@ -83,6 +123,29 @@ func envInt(key string, def int) int {
 	return n
 }
 
+// envList splits a comma-separated setting, dropping empty entries.
+func envList(key string) []string {
+	items := make([]string, 0)
+	for _, one := range strings.Split(envString(key, ""), ",") {
+		if one = strings.TrimSpace(one); one != "" {
+			items = append(items, one)
+		}
+	}
+	return items
+}
+
+func envBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
 func normalizeBaseURL(raw string) string {
 	s := strings.TrimSpace(raw)
 	if s == "" {
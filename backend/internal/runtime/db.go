@@ -34,10 +34,23 @@ func initDB(db *sql.DB, cfg appConfig) error {
 			project_type TEXT NOT NULL,
 			account TEXT NOT NULL DEFAULT '',
 			password TEXT NOT NULL DEFAULT '',
+			auth_method TEXT NOT NULL DEFAULT 'password',
+			private_key TEXT NOT NULL DEFAULT '',
+			passphrase TEXT NOT NULL DEFAULT '',
 			updated_at TEXT NOT NULL,
 			UNIQUE(user_id, project_type),
 			FOREIGN KEY(user_id) REFERENCES admins(id)
 		);`,
+		`CREATE TABLE IF NOT EXISTS ssh_host_keys (
+			host TEXT PRIMARY KEY,
+			key_type TEXT NOT NULL DEFAULT '',
+			fingerprint TEXT NOT NULL DEFAULT '',
+			pending_key_type TEXT NOT NULL DEFAULT '',
+			pending_fingerprint TEXT NOT NULL DEFAULT '',
+			confirmed_by TEXT NOT NULL DEFAULT '',
+			confirmed_at TEXT NOT NULL DEFAULT '',
+			updated_at TEXT NOT NULL
+		);`,
 		`CREATE TABLE IF NOT EXISTS operation_logs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER,
@@ -47,6 +60,16 @@ func initDB(db *sql.DB, cfg appConfig) error {
 			detail TEXT,
 			created_at TEXT NOT NULL
 		);`,
+		`CREATE TABLE IF NOT EXISTS vpn_account_expiry (
+			vpn_user TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			expires_on TEXT NOT NULL,
+			reminded_at TEXT NOT NULL DEFAULT '',
+			disabled_at TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES admins(id)
+		);`,
 	}
 	for _, stmt := range schema {
 		if _, err := db.Exec(stmt); err != nil {
@@ -58,6 +81,18 @@ func initDB(db *sql.DB, cfg appConfig) error {
 	if err = migrateProjectCredentialsSchema(db); err != nil {
 		return err
 	}
+	if err = migrateProjectCredentialSSHColumns(db); err != nil {
+		return err
+	}
+	if err = migrateAdminRoleColumn(db); err != nil {
+		return err
+	}
+	if err = syncAdminRoles(db, cfg.AdminUsers); err != nil {
+		return err
+	}
+	if err = renameFirewallCredentials(db); err != nil {
+		return err
+	}
 	if err = migrateAuthTokensSchema(db); err != nil {
 		return err
 	}
@@ -192,6 +227,79 @@ func migrateProjectCredentialsSchema(db *sql.DB) error {
 	return tx.Commit()
 }
 
+// migrateProjectCredentialSSHColumns adds the SSH auth columns used by the
+// vpn and firewall credentials. private_key and passphrase are stored
+// encrypted like password.
+func migrateProjectCredentialSSHColumns(db *sql.DB) error {
+	for _, col := range []struct {
+		name string
+		ddl  string
+	}{
+		{"auth_method", `ALTER TABLE project_credentials ADD COLUMN auth_method TEXT NOT NULL DEFAULT 'password'`},
+		{"private_key", `ALTER TABLE project_credentials ADD COLUMN private_key TEXT NOT NULL DEFAULT ''`},
+		{"passphrase", `ALTER TABLE project_credentials ADD COLUMN passphrase TEXT NOT NULL DEFAULT ''`},
+	} {
+		has, err := tableHasColumn(db, "project_credentials", col.name)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err = db.Exec(col.ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAdminRoleColumn adds admins.role. Accounts come from open
+// registration, so none of them is an administrator by default.
+func migrateAdminRoleColumn(db *sql.DB) error {
+	has, err := tableHasColumn(db, "admins", "role")
+	if err != nil || has {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE admins ADD COLUMN role TEXT NOT NULL DEFAULT 'operator'`)
+	return err
+}
+
+// syncAdminRoles makes ADMIN_USERS the only source of the admin role: listed
+// accounts become admins and every other account, including one marked admin
+// by an earlier version, an operator.
+func syncAdminRoles(db *sql.DB, admins []string) error {
+	if _, err := db.Exec(`UPDATE admins SET role=?`, roleOperator); err != nil {
+		return err
+	}
+	for _, username := range admins {
+		if _, err := db.Exec(`UPDATE admins SET role=? WHERE username=?`, roleAdmin, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountRole is the role a newly registered account gets.
+func (s *server) accountRole(username string) string {
+	for _, one := range s.cfg.AdminUsers {
+		if one == username {
+			return roleAdmin
+		}
+	}
+	return roleOperator
+}
+
+// renameFirewallCredentials moves credentials saved under the old
+// vpn_firewall slot to the firewall project. A user who already has a
+// firewall row keeps it.
+func renameFirewallCredentials(db *sql.DB) error {
+	if _, err := db.Exec(`UPDATE OR IGNORE project_credentials SET project_type='firewall' WHERE project_type='vpn_firewall'`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM project_credentials WHERE project_type='vpn_firewall'`)
+	return err
+}
+
 func tableHasColumn(db *sql.DB, tableName, columnName string) (bool, error) {
 	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, tableName))
 	if err != nil {
@@ -244,7 +352,7 @@ func ensureDefaultProjectCredentialsForAllUsers(db *sql.DB) error {
 }
 
 func ensureDefaultProjectCredentialsForUser(db *sql.DB, userID int64) error {
-	for _, p := range []string{"ad", "print", "vpn", "vpn_firewall"} {
+	for _, p := range []string{"ad", "print", "vpn", "firewall"} {
 		if _, err := db.Exec(`INSERT OR IGNORE INTO project_credentials(user_id,project_type,account,password,updated_at) VALUES(?,?,?,?,?)`, userID, p, "", "", nowStr()); err != nil {
 			return err
 		}
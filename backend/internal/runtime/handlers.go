@@ -60,6 +60,14 @@ func (s *server) route(w http.ResponseWriter, r *http.Request) {
 		s.requireAuth(s.handleProjectCredentialByType)(w, r)
 		return
 	}
+	if r.URL.Path == "/api/ssh-host-keys" && r.Method == http.MethodGet {
+		s.requireAuth(s.handleHostKeys)(w, r)
+		return
+	}
+	if r.URL.Path == "/api/ssh-host-keys/confirm" && r.Method == http.MethodPost {
+		s.requireAdmin(s.handleConfirmHostKey)(w, r)
+		return
+	}
 	if r.URL.Path == "/api/projects/relogin" && r.Method == http.MethodPost {
 		s.requireAuth(s.handleProjectsRelogin)(w, r)
 		return
@@ -76,6 +84,14 @@ func (s *server) route(w http.ResponseWriter, r *http.Request) {
 		s.requireAuth(s.handleProjectOps)(w, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/api/workflows/") {
+		s.requireAuth(s.handleWorkflowOps)(w, r)
+		return
+	}
+	if r.URL.Path == "/api/search/person" && r.Method == http.MethodGet {
+		s.requireAuth(s.handlePersonSearch)(w, r)
+		return
+	}
 	if r.URL.Path == "/api/logs" && r.Method == http.MethodGet {
 		s.requireAuth(s.handleLogs)(w, r)
 		return
@@ -184,7 +200,6 @@ func (s *server) handleWindowCloseCancel(w http.ResponseWriter, r *http.Request,
 	}
 	detail := formatBrowserCloseCancelDetail(req)
 	s.logAction(u.ID, u.Username, "browser_close_timer_canceled", "", detail)
-	fmt.Printf("[browser-close-cancel] user=%s detail=%s\n", u.Username, detail)
 	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
 }
 
@@ -199,7 +214,6 @@ func (s *server) handleLogout(w http.ResponseWriter, r *http.Request, u authedUs
 	if strings.TrimSpace(req.Reason) == "reopen_timeout" {
 		detail := formatBrowserCloseEventDetail("页面关闭超时，已清理该账号全部 Token 与项目会话缓存", req)
 		s.logAction(u.ID, u.Username, "logout", "", detail)
-		fmt.Printf("[browser-close-timeout] user=%s detail=%s\n", u.Username, detail)
 	} else {
 		s.logAction(u.ID, u.Username, "logout", "", "管理员退出登录")
 	}
@@ -231,7 +245,7 @@ func (s *server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: "密码加密失败"})
 		return
 	}
-	res, err := s.db.Exec(`INSERT INTO admins(username,password_hash,created_at,updated_at) VALUES(?,?,?,?)`, username, string(hash), nowStr(), nowStr())
+	res, err := s.db.Exec(`INSERT INTO admins(username,password_hash,role,created_at,updated_at) VALUES(?,?,?,?,?)`, username, string(hash), s.accountRole(username), nowStr(), nowStr())
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "unique") {
 			writeJSON(w, http.StatusConflict, apiError{Error: "用户名已存在"})
@@ -292,17 +306,17 @@ func (s *server) handleProjectCredentials(w http.ResponseWriter, _ *http.Request
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: "初始化项目凭据失败"})
 		return
 	}
-	rows, err := s.db.Query(`SELECT project_type,account,password,updated_at FROM project_credentials WHERE user_id=? ORDER BY project_type`, u.ID)
+	rows, err := s.db.Query(`SELECT project_type,account,password,auth_method,private_key,updated_at FROM project_credentials WHERE user_id=? ORDER BY project_type`, u.ID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: "查询项目凭据失败"})
 		return
 	}
 	defer rows.Close()
 
-	items := make([]map[string]string, 0)
+	items := make([]map[string]interface{}, 0)
 	for rows.Next() {
-		var t, account, password, updated string
-		if err = rows.Scan(&t, &account, &password, &updated); err != nil {
+		var t, account, password, authMethod, privateKey, updated string
+		if err = rows.Scan(&t, &account, &password, &authMethod, &privateKey, &updated); err != nil {
 			writeJSON(w, http.StatusInternalServerError, apiError{Error: "读取项目凭据失败"})
 			return
 		}
@@ -311,7 +325,17 @@ func (s *server) handleProjectCredentials(w http.ResponseWriter, _ *http.Request
 			writeJSON(w, http.StatusInternalServerError, apiError{Error: "项目凭据解密失败"})
 			return
 		}
-		items = append(items, map[string]string{"project_type": t, "account": account, "password": plainPwd, "updated_at": updated})
+		// The private key and its passphrase never leave the server; the
+		// screen only learns whether one is stored.
+		items = append(items, map[string]interface{}{
+			"project_type":    t,
+			"account":         account,
+			"password":        plainPwd,
+			"auth_method":     authMethod,
+			"has_private_key": privateKey != "",
+			"ssh":             sshCredentialProjectType(t),
+			"updated_at":      updated,
+		})
 	}
 	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
 }
@@ -327,7 +351,30 @@ func (s *server) handleProjectCredentialByType(w http.ResponseWriter, r *http.Re
 		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
 		return
 	}
-	if strings.TrimSpace(req.Account) == "" || strings.TrimSpace(req.Password) == "" {
+	authMethod, ok := project.NormalizeSSHAuthMethod(req.AuthMethod)
+	if !ok || (!sshCredentialProjectType(projectType) && authMethod != project.SSHAuthPassword) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "不支持的认证方式"})
+		return
+	}
+	// An empty private key keeps the stored one (and its passphrase), so the
+	// key does not have to be pasted again when only the password changes.
+	var storedKey, storedPassphrase string
+	if strings.TrimSpace(req.PrivateKey) == "" {
+		if err := s.db.QueryRow(`SELECT private_key,passphrase FROM project_credentials WHERE user_id=? AND project_type=?`, u.ID, projectType).Scan(&storedKey, &storedPassphrase); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: "查询项目凭据失败"})
+			return
+		}
+	}
+	if strings.TrimSpace(req.Account) == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "账号不能为空"})
+		return
+	}
+	if authMethod == project.SSHAuthPublicKey {
+		if strings.TrimSpace(req.PrivateKey) == "" && storedKey == "" {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "私钥不能为空"})
+			return
+		}
+	} else if strings.TrimSpace(req.Password) == "" {
 		writeJSON(w, http.StatusBadRequest, apiError{Error: "账号和密码不能为空"})
 		return
 	}
@@ -336,15 +383,25 @@ func (s *server) handleProjectCredentialByType(w http.ResponseWriter, r *http.Re
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: "凭据加密失败"})
 		return
 	}
-	if _, err = s.db.Exec(`INSERT INTO project_credentials(user_id,project_type,account,password,updated_at) VALUES(?,?,?,?,?)
-	ON CONFLICT(user_id,project_type) DO UPDATE SET account=excluded.account,password=excluded.password,updated_at=excluded.updated_at`,
-		u.ID, projectType, req.Account, encryptedPwd, nowStr(),
+	encryptedKey, encryptedPassphrase := storedKey, storedPassphrase
+	if strings.TrimSpace(req.PrivateKey) != "" {
+		if encryptedKey, err = encryptCredentialPassword(req.PrivateKey, s.cfg.CredentialKey); err == nil {
+			encryptedPassphrase, err = encryptCredentialPassword(req.Passphrase, s.cfg.CredentialKey)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: "凭据加密失败"})
+			return
+		}
+	}
+	if _, err = s.db.Exec(`INSERT INTO project_credentials(user_id,project_type,account,password,auth_method,private_key,passphrase,updated_at) VALUES(?,?,?,?,?,?,?,?)
+	ON CONFLICT(user_id,project_type) DO UPDATE SET account=excluded.account,password=excluded.password,auth_method=excluded.auth_method,private_key=excluded.private_key,passphrase=excluded.passphrase,updated_at=excluded.updated_at`,
+		u.ID, projectType, req.Account, encryptedPwd, authMethod, encryptedKey, encryptedPassphrase, nowStr(),
 	); err != nil {
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: "更新项目凭据失败"})
 		return
 	}
 	s.projectSessions.clearUserProject(u.ID, projectType)
-	s.logAction(u.ID, u.Username, "update_project_credential", projectType, "更新项目凭据")
+	s.logAction(u.ID, u.Username, "update_project_credential", projectType, "更新项目凭据，认证方式："+authMethod)
 	writeJSON(w, http.StatusOK, map[string]string{"message": "更新成功"})
 }
 
@@ -379,9 +436,66 @@ func (s *server) handleProjectOps(w http.ResponseWriter, r *http.Request, u auth
 		s.handleProjectOperate(w, r, u, projectType)
 		return
 	}
+	if op == "export-file" && r.Method == http.MethodGet {
+		s.handleProjectExportFile(w, r, u, projectType)
+		return
+	}
+	if op == "expiry" && projectType == "vpn" && r.Method == http.MethodGet {
+		s.handleVPNExpiryList(w, u)
+		return
+	}
 	writeJSON(w, http.StatusNotFound, apiError{Error: "接口不存在"})
 }
 
+var workflowNames = map[string]bool{"onboard": true, "offboard": true, "reconcile": true, "roster": true, "state": true}
+
+// handleWorkflowOps serves /api/workflows/{workflow}[/{op}]. Workflows run as
+// async jobs polled through /api/projects/operate-async/{job_id}; their
+// spreadsheets use the batch file endpoints under the workflow's name.
+func (s *server) handleWorkflowOps(w http.ResponseWriter, r *http.Request, u authedUser) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || !workflowNames[parts[2]] {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "接口不存在"})
+		return
+	}
+	workflow, op := parts[2], ""
+	if len(parts) == 4 {
+		op = parts[3]
+	}
+	switch {
+	case op == "" && r.Method == http.MethodPost && workflow == "onboard":
+		s.handleOnboardStart(w, r, u)
+	case op == "" && r.Method == http.MethodPost && workflow == "offboard":
+		s.handleOffboardStart(w, r, u)
+	case op == "apply" && r.Method == http.MethodPost && workflow == "offboard":
+		s.handleOffboardApply(w, r, u)
+	case op == "policy" && r.Method == http.MethodGet && workflow == "offboard":
+		s.handleOffboardPolicy(w)
+	case op == "" && r.Method == http.MethodPost && workflow == "reconcile":
+		s.handleReconcileStart(w, r, u)
+	case op == "fix" && r.Method == http.MethodPost && workflow == "reconcile":
+		s.handleReconcileFix(w, r, u)
+	case op == "" && r.Method == http.MethodPost && workflow == "roster":
+		s.handleRosterPlan(w, r, u)
+	case op == "apply" && r.Method == http.MethodPost && workflow == "roster":
+		s.handleRosterApply(w, r, u)
+	case op == "" && r.Method == http.MethodPost && workflow == "state":
+		s.handleStatePlan(w, r, u)
+	case op == "apply" && r.Method == http.MethodPost && workflow == "state":
+		s.handleStateApply(w, r, u)
+	case op == "batch-template" && r.Method == http.MethodGet:
+		s.handleProjectBatchTemplate(w, r, workflow)
+	case op == "batch-upload" && r.Method == http.MethodPost:
+		s.handleProjectBatchUpload(w, r, workflow)
+	case op == "batch-files" && r.Method == http.MethodGet:
+		s.handleProjectBatchFiles(w, workflow)
+	case op == "export-file" && r.Method == http.MethodGet:
+		s.handleProjectExportFile(w, r, u, workflow)
+	default:
+		writeJSON(w, http.StatusNotFound, apiError{Error: "接口不存在"})
+	}
+}
+
 func (s *server) handleProjectLoad(w http.ResponseWriter, u authedUser, projectType string) {
 	_, didLogin, message, err := s.ensureProjectSession(u, projectType, false)
 	if err != nil {
@@ -398,35 +512,44 @@ func (s *server) handleProjectLoad(w http.ResponseWriter, u authedUser, projectT
 }
 
 func (s *server) handleProjectBatchFiles(w http.ResponseWriter, projectType string) {
-	if projectType != "ad" {
-		writeJSON(w, http.StatusBadRequest, apiError{Error: "批量文件仅支持AD项目"})
+	if !project.BatchSupported(projectType) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "该项目不支持批量文件"})
 		return
 	}
-	files, err := project.BatchExcelFiles()
+	files, err := project.BatchExcelFiles(projectType)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
 		return
 	}
+	dir := project.BatchUploadDir(projectType)
 	items := make([]map[string]string, 0, len(files))
 	for _, name := range files {
 		items = append(items, map[string]string{
 			"name": name,
-			"path": filepath.Join(project.BatchUploadDir(), name),
+			"path": filepath.Join(dir, name),
 		})
 	}
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"items": items,
-		"dir":   project.BatchUploadDir(),
+		"dir":   dir,
 	})
 }
 
 func (s *server) handleProjectBatchTemplate(w http.ResponseWriter, r *http.Request, projectType string) {
-	if projectType != "ad" {
-		writeJSON(w, http.StatusBadRequest, apiError{Error: "批量模板仅支持AD项目"})
+	if !project.BatchSupported(projectType) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "该项目不支持批量模板"})
 		return
 	}
-	path := project.BatchTemplatePath()
-	if _, err := os.Stat(path); err != nil {
+	path, err := project.BatchTemplatePath(projectType, strings.TrimSpace(r.URL.Query().Get("action")))
+	if errors.Is(err, project.ErrBatchActionUnsupported) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "该操作没有批量模板"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	if _, err = os.Stat(path); err != nil {
 		if os.IsNotExist(err) {
 			writeJSON(w, http.StatusNotFound, apiError{Error: "模板文件不存在"})
 			return
@@ -440,12 +563,26 @@ func (s *server) handleProjectBatchTemplate(w http.ResponseWriter, r *http.Reque
 	http.ServeFile(w, r, path)
 }
 
+func (s *server) handleProjectExportFile(w http.ResponseWriter, r *http.Request, u authedUser, projectType string) {
+	path, err := project.ExportFilePath(projectType, r.URL.Query().Get("name"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "导出文件不存在"})
+		return
+	}
+	filename := filepath.Base(path)
+	s.logAction(u.ID, u.Username, "download_export", projectType, "下载导出文件："+filename)
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(filename)))
+	http.ServeFile(w, r, path)
+}
+
 func (s *server) handleProjectBatchUpload(w http.ResponseWriter, r *http.Request, projectType string) {
-	if projectType != "ad" {
-		writeJSON(w, http.StatusBadRequest, apiError{Error: "批量上传仅支持AD项目"})
+	if !project.BatchSupported(projectType) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "该项目不支持批量上传"})
 		return
 	}
-	if err := os.MkdirAll(project.BatchUploadDir(), 0o755); err != nil {
+	dir := project.BatchUploadDir(projectType)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
 		return
 	}
@@ -463,13 +600,17 @@ func (s *server) handleProjectBatchUpload(w http.ResponseWriter, r *http.Request
 	oldFile := filepath.Base(strings.TrimSpace(r.FormValue("old_file")))
 
 	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext != ".xlsx" && ext != ".xls" {
-		writeJSON(w, http.StatusBadRequest, apiError{Error: "仅支持上传 xlsx/.xls 文件"})
+	if !project.BatchFileExtAllowed(projectType, ext) {
+		if projectType == "ad" {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "仅支持上传 xlsx/.xls 文件"})
+		} else {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "仅支持上传 xlsx/.xls/.csv 文件"})
+		}
 		return
 	}
 
-	storedName := fmt.Sprintf("ad_batch_%d%s", time.Now().UnixNano(), ext)
-	outPath := filepath.Join(project.BatchUploadDir(), storedName)
+	storedName := fmt.Sprintf("%s_batch_%d%s", projectType, time.Now().UnixNano(), ext)
+	outPath := filepath.Join(dir, storedName)
 	outFile, err := os.Create(outPath)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
@@ -481,8 +622,8 @@ func (s *server) handleProjectBatchUpload(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if oldFile != "" {
-		_ = os.Remove(filepath.Join(project.BatchUploadDir(), oldFile))
+	if oldFile != "" && oldFile != "." {
+		_ = os.Remove(filepath.Join(dir, oldFile))
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -505,16 +646,8 @@ func (s *server) handleProjectOperate(w http.ResponseWriter, r *http.Request, u
 	if req.Params == nil {
 		req.Params = map[string]interface{}{}
 	}
-	if projectType == "vpn" && req.Action == "delete_users" && toBoolDefault(req.Params["remote_firewall"], false) {
-		fwAccount, fwPassword, fwErr := s.getProjectCredential(u.ID, "vpn_firewall")
-		if fwErr != nil {
-			req.Params["__vpn_fw_configured"] = false
-			req.Params["__vpn_fw_error"] = fwErr.Error()
-		} else {
-			req.Params["__vpn_fw_configured"] = true
-			req.Params["__vpn_fw_account"] = fwAccount
-			req.Params["__vpn_fw_password"] = fwPassword
-		}
+	if projectType == "vpn" && project.VPNActionUsesFirewall(req.Action, req.Params) {
+		s.injectFirewallCredential(u.ID, req.Params)
 	}
 
 	entry, didLogin, _, err := s.ensureProjectSession(u, projectType, false)
@@ -539,13 +672,16 @@ func (s *server) handleProjectOperate(w http.ResponseWriter, r *http.Request, u
 		return
 	}
 	s.logAction(u.ID, u.Username, "project_operate", projectType, fmt.Sprintf("action=%s", req.Action))
+	if projectType == "vpn" {
+		s.trackVPNExpiry(u, req.Action, result)
+	}
 	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "message": result.Message, "data": result.Data, "session_state": projectSessionStateFromDidLogin(didLogin)})
 }
 
 func (s *server) handleProjectsRelogin(w http.ResponseWriter, _ *http.Request, u authedUser) {
 	s.projectSessions.clearToken(u.Token)
-	reloginItems := make([]map[string]interface{}, 0, 3)
-	for _, projectType := range []string{"ad", "print", "vpn"} {
+	reloginItems := make([]map[string]interface{}, 0, 4)
+	for _, projectType := range []string{"ad", "print", "vpn", "firewall"} {
 		_, _, message, err := s.ensureProjectSession(u, projectType, true)
 		if err != nil {
 			reloginItems = append(reloginItems, map[string]interface{}{
@@ -641,23 +777,46 @@ func (s *server) handleLogs(w http.ResponseWriter, r *http.Request, _ authedUser
 	})
 }
 
-func (s *server) getProjectCredential(userID int64, projectType string) (string, string, error) {
-	var account, password string
-	err := s.db.QueryRow(`SELECT account,password FROM project_credentials WHERE user_id=? AND project_type=?`, userID, projectType).Scan(&account, &password)
+func (s *server) getProjectCredential(userID int64, projectType string) (project.Credential, error) {
+	var cred project.Credential
+	var password, privateKey, passphrase string
+	err := s.db.QueryRow(`SELECT account,password,auth_method,private_key,passphrase FROM project_credentials WHERE user_id=? AND project_type=?`, userID, projectType).
+		Scan(&cred.Account, &password, &cred.AuthMethod, &privateKey, &passphrase)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", "", errors.New("项目凭据未配置")
+			return cred, errors.New("项目凭据未配置")
+		}
+		return cred, err
+	}
+	for _, one := range []struct {
+		cipher string
+		plain  *string
+	}{
+		{password, &cred.Password},
+		{privateKey, &cred.PrivateKey},
+		{passphrase, &cred.Passphrase},
+	} {
+		if *one.plain, err = decryptCredentialPassword(one.cipher, s.cfg.CredentialKey); err != nil {
+			return cred, errors.New("凭据解密失败")
 		}
-		return "", "", err
 	}
-	password, err = decryptCredentialPassword(password, s.cfg.CredentialKey)
-	if err != nil {
-		return "", "", errors.New("凭据解密失败")
+	if !cred.Usable() {
+		return cred, errors.New("项目凭据未配置")
 	}
-	if strings.TrimSpace(account) == "" || strings.TrimSpace(password) == "" {
-		return "", "", errors.New("项目凭据未配置")
+	return cred, nil
+}
+
+// injectFirewallCredential passes the firewall credential to a VPN action
+// that mirrors its changes, or the reason it is unavailable.
+func (s *server) injectFirewallCredential(userID int64, params map[string]interface{}) {
+	cred, err := s.getProjectCredential(userID, "firewall")
+	if err != nil {
+		params["__vpn_fw_configured"] = false
+		params["__vpn_fw_error"] = err.Error()
+		return
 	}
-	return account, password, nil
+	params["__vpn_fw_configured"] = true
+	project.SSHCredentialParams(params, "__vpn_fw_", cred)
 }
 
 func (s *server) requireAuth(next func(http.ResponseWriter, *http.Request, authedUser)) http.HandlerFunc {
@@ -677,6 +836,18 @@ func (s *server) requireAuth(next func(http.ResponseWriter, *http.Request, authe
 	}
 }
 
+// requireAdmin is requireAuth for endpoints that change what the console
+// trusts; other roles get 403.
+func (s *server) requireAdmin(next func(http.ResponseWriter, *http.Request, authedUser)) http.HandlerFunc {
+	return s.requireAuth(func(w http.ResponseWriter, r *http.Request, u authedUser) {
+		if u.Role != roleAdmin {
+			writeJSON(w, http.StatusForbidden, apiError{Error: "仅管理员可执行此操作"})
+			return
+		}
+		next(w, r, u)
+	})
+}
+
 func (s *server) logAction(userID int64, username, action, projectType, detail string) {
 	detail = normalizeGarbledText(detail)
 	_, _ = s.db.Exec(`INSERT INTO operation_logs(user_id,username,action,project_type,detail,created_at) VALUES(?,?,?,?,?,?)`, userID, username, action, projectType, detail, nowStr())
@@ -688,7 +859,6 @@ func (s *server) scheduleBrowserCloseLifecycle(u authedUser, req browserCloseEve
 	if token == "" {
 		detail := formatBrowserCloseEventDetail("检测到浏览器最后一个系统页面已关闭，开始计时", req)
 		s.logAction(u.ID, u.Username, "browser_close_timer_started", "", detail)
-		fmt.Printf("[browser-close] user=%s detail=%s\n", u.Username, detail)
 		s.executeBrowserCloseTimeout(u, req)
 		return
 	}
@@ -737,7 +907,6 @@ func (s *server) activateBrowserCloseStartLog(token string, expectedClosedAtMS i
 
 	detail := formatBrowserCloseEventDetail("检测到浏览器最后一个系统页面已关闭，开始计时", req)
 	s.logAction(user.ID, user.Username, "browser_close_timer_started", "", detail)
-	fmt.Printf("[browser-close] user=%s detail=%s\n", user.Username, detail)
 }
 
 func (s *server) handleBrowserCloseTimeout(token string, expectedClosedAtMS int64) {
@@ -764,7 +933,6 @@ func (s *server) handleBrowserCloseTimeout(token string, expectedClosedAtMS int6
 	if !started {
 		detail := formatBrowserCloseEventDetail("检测到浏览器最后一个系统页面已关闭，开始计时", req)
 		s.logAction(user.ID, user.Username, "browser_close_timer_started", "", detail)
-		fmt.Printf("[browser-close] user=%s detail=%s\n", user.Username, detail)
 	}
 
 	s.executeBrowserCloseTimeout(user, req)
@@ -775,7 +943,6 @@ func (s *server) executeBrowserCloseTimeout(u authedUser, req browserCloseEventR
 	s.cleanupUserAuthTokens(u.ID)
 	detail := formatBrowserCloseEventDetail("页面关闭超时，后端已自动清理该账号全部 Token 与项目会话缓存", req)
 	s.logAction(u.ID, u.Username, "logout", "", detail)
-	fmt.Printf("[browser-close-timeout-auto] user=%s detail=%s\n", u.Username, detail)
 }
 
 func (s *server) cancelBrowserCloseState(token string) (bool, bool) {
@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, cfg appConfig) *server {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "ops_admin.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err = initDB(db, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &server{
+		db:                 db,
+		tokenTTL:           time.Hour,
+		cfg:                cfg,
+		jobs:               make(map[string]*asyncOperateJob),
+		projectSessions:    newProjectSessionManager(),
+		browserCloseStates: make(map[string]*browserCloseState),
+	}
+}
+
+func testRequest(t *testing.T, s *server, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.route(w, r)
+	return w
+}
+
+func testLogin(t *testing.T, s *server, username string) string {
+	t.Helper()
+	cred := map[string]string{"username": username, "password": "Secret-pass1"}
+	if w := testRequest(t, s, "/api/auth/register", "", cred); w.Code != http.StatusOK {
+		t.Fatalf("register %s: %d %s", username, w.Code, w.Body)
+	}
+	w := testRequest(t, s, "/api/auth/login", "", cred)
+	var resp loginResp
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("login %s: %d %s", username, w.Code, w.Body)
+	}
+	return resp.Token
+}
+
+func TestConfirmHostKeyNeedsAdminRole(t *testing.T) {
+	s := newTestServer(t, appConfig{CredentialKey: "test-secret", AdminUsers: []string{"root"}})
+	operator := testLogin(t, s, "helpdesk")
+	admin := testLogin(t, s, "root")
+	req := map[string]string{"host": "vpn.example.internal:22", "fingerprint": "SHA256:test"}
+
+	if w := testRequest(t, s, "/api/ssh-host-keys/confirm", operator, req); w.Code != http.StatusForbidden {
+		t.Fatalf("operator: %d %s, want 403", w.Code, w.Body)
+	}
+	// The admin gets past the role check; there is no such host to confirm.
+	if w := testRequest(t, s, "/api/ssh-host-keys/confirm", admin, req); w.Code != http.StatusNotFound {
+		t.Fatalf("admin: %d %s, want 404", w.Code, w.Body)
+	}
+}
+
+func TestAdminUsersIsTheOnlySourceOfTheAdminRole(t *testing.T) {
+	cfg := appConfig{CredentialKey: "test-secret", AdminUsers: []string{"root"}}
+	s := newTestServer(t, cfg)
+	testLogin(t, s, "root")
+	testLogin(t, s, "helpdesk")
+	// An account marked admin before ADMIN_USERS existed loses the role.
+	if _, err := s.db.Exec(`UPDATE admins SET role=? WHERE username='helpdesk'`, roleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := initDB(s.db, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for username, want := range map[string]string{"root": roleAdmin, "helpdesk": roleOperator} {
+		var role string
+		if err := s.db.QueryRow(`SELECT role FROM admins WHERE username=?`, username).Scan(&role); err != nil {
+			t.Fatal(err)
+		}
+		if role != want {
+			t.Errorf("%s role = %q, want %q", username, role, want)
+		}
+	}
+}
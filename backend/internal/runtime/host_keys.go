@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type hostKeyRow struct {
+	Host               string `json:"host"`
+	ProjectType        string `json:"project_type"`
+	KeyType            string `json:"key_type"`
+	Fingerprint        string `json:"fingerprint"`
+	PendingKeyType     string `json:"pending_key_type"`
+	PendingFingerprint string `json:"pending_fingerprint"`
+	ConfirmedBy        string `json:"confirmed_by"`
+	ConfirmedAt        string `json:"confirmed_at"`
+	UpdatedAt          string `json:"updated_at"`
+}
+
+type confirmHostKeyReq struct {
+	Host        string `json:"host"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// sqliteHostKeyStore implements project.HostKeyStore on the ssh_host_keys
+// table. A key stays pending until an admin confirms its fingerprint. Every
+// refused changed key is audited; a pending first-use key only once.
+type sqliteHostKeyStore struct {
+	s *server
+}
+
+func (st sqliteHostKeyStore) TrustedHostKey(host string) (string, error) {
+	var fingerprint string
+	err := st.s.db.QueryRow(`SELECT fingerprint FROM ssh_host_keys WHERE host=?`, host).Scan(&fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return fingerprint, err
+}
+
+func (st sqliteHostKeyStore) ObserveHostKey(host, keyType, fingerprint string, changed bool) error {
+	var pending string
+	err := st.s.db.QueryRow(`SELECT pending_fingerprint FROM ssh_host_keys WHERE host=?`, host).Scan(&pending)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	now := nowStr()
+	if _, err = st.s.db.Exec(`INSERT INTO ssh_host_keys(host,key_type,fingerprint,pending_key_type,pending_fingerprint,confirmed_by,confirmed_at,updated_at) VALUES(?,'','',?,?,'','',?)
+	ON CONFLICT(host) DO UPDATE SET pending_key_type=excluded.pending_key_type,pending_fingerprint=excluded.pending_fingerprint,updated_at=excluded.updated_at`,
+		host, keyType, fingerprint, now,
+	); err != nil {
+		return err
+	}
+	projectType := st.s.hostKeyProjectType(host)
+	if changed {
+		st.s.logAction(0, "system", "ssh_host_key_changed", projectType, fmt.Sprintf("主机 %s 的 SSH 密钥已变更，新指纹 %s（%s），连接已拒绝", host, fingerprint, keyType))
+		return nil
+	}
+	if pending == fingerprint {
+		return nil
+	}
+	st.s.logAction(0, "system", "ssh_host_key_pending", projectType, fmt.Sprintf("首次连接主机 %s，指纹 %s（%s），等待确认", host, fingerprint, keyType))
+	return nil
+}
+
+// hostKeyProjectType maps a dialed "host:port" back to the credential it
+// belongs to so host keys can be shown next to that credential.
+func (s *server) hostKeyProjectType(host string) string {
+	name, _, err := net.SplitHostPort(host)
+	if err != nil {
+		name = host
+	}
+	switch name {
+	case s.cfg.VPNSshAddr:
+		return "vpn"
+	case s.cfg.FirewallSSHAddr:
+		return "firewall"
+	default:
+		return ""
+	}
+}
+
+func (s *server) listHostKeys() ([]hostKeyRow, error) {
+	rows, err := s.db.Query(`SELECT host,key_type,fingerprint,pending_key_type,pending_fingerprint,confirmed_by,confirmed_at,updated_at FROM ssh_host_keys ORDER BY host`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]hostKeyRow, 0)
+	for rows.Next() {
+		var one hostKeyRow
+		if err = rows.Scan(&one.Host, &one.KeyType, &one.Fingerprint, &one.PendingKeyType, &one.PendingFingerprint, &one.ConfirmedBy, &one.ConfirmedAt, &one.UpdatedAt); err != nil {
+			return nil, err
+		}
+		one.ProjectType = s.hostKeyProjectType(one.Host)
+		items = append(items, one)
+	}
+	return items, rows.Err()
+}
+
+func (s *server) handleHostKeys(w http.ResponseWriter, _ *http.Request, _ authedUser) {
+	items, err := s.listHostKeys()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "查询主机密钥失败"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+// handleConfirmHostKey trusts the pending key of a host; only admins may call
+// it. The request must repeat the fingerprint the admin saw, so a key that
+// changed again in the meantime is not confirmed by accident. The audit entry
+// records both the old and the new fingerprint.
+func (s *server) handleConfirmHostKey(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req confirmHostKeyReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	host := strings.TrimSpace(req.Host)
+	fingerprint := strings.TrimSpace(req.Fingerprint)
+	if host == "" || fingerprint == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "主机和指纹不能为空"})
+		return
+	}
+	var old, pending string
+	err := s.db.QueryRow(`SELECT fingerprint,pending_fingerprint FROM ssh_host_keys WHERE host=?`, host).Scan(&old, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "未找到该主机的密钥记录"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "查询主机密钥失败"})
+		return
+	}
+	if pending == "" || pending != fingerprint {
+		writeJSON(w, http.StatusConflict, apiError{Error: "指纹与待确认记录不一致，请刷新后重试"})
+		return
+	}
+	if _, err = s.db.Exec(`UPDATE ssh_host_keys SET key_type=pending_key_type,fingerprint=pending_fingerprint,pending_key_type='',pending_fingerprint='',confirmed_by=?,confirmed_at=?,updated_at=? WHERE host=?`,
+		u.Username, nowStr(), nowStr(), host,
+	); err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "确认主机密钥失败"})
+		return
+	}
+	if old == "" {
+		old = "无"
+	}
+	s.logAction(u.ID, u.Username, "confirm_ssh_host_key", s.hostKeyProjectType(host), fmt.Sprintf("确认主机 %s 的 SSH 密钥：原指纹 %s，新指纹 %s", host, old, fingerprint))
+	writeJSON(w, http.StatusOK, map[string]string{"message": "主机密钥已确认"})
+}
@@ -0,0 +1,318 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ops-admin-backend/internal/project"
+)
+
+// Offboarding workflow: one async job looks each leaver up on the selected
+// systems and plans the offboarding policy (disable, delete or keep) for
+// every account found; it changes nothing. The server keeps the plan, and
+// the operator approves its items by key; a second job applies those, so
+// the accounts acted on are the ones the operator saw. The policy comes from
+// OFFBOARD_POLICY and can be overridden per request.
+
+type offboardReq struct {
+	Systems   []string          `json:"systems"`
+	Accounts  interface{}       `json:"accounts"`
+	ExcelFile string            `json:"excel_file"`
+	Policy    map[string]string `json:"policy"`
+}
+
+type offboardApplyReq struct {
+	JobID string   `json:"job_id"`
+	Keys  []string `json:"keys"`
+}
+
+func (s *server) handleOffboardPolicy(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"systems": project.OffboardSystems,
+		"policy":  s.cfg.OffboardPolicy,
+	})
+}
+
+func (s *server) handleOffboardStart(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req offboardReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	systems := workflowSystems(project.OffboardSystems, req.Systems)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少选择一个目标系统"})
+		return
+	}
+	policy := make(map[string]string, len(s.cfg.OffboardPolicy))
+	for k, v := range s.cfg.OffboardPolicy {
+		policy[k] = v
+	}
+	for system, action := range req.Policy {
+		if strings.TrimSpace(action) == "" {
+			continue
+		}
+		if err := project.SetOffboardPolicy(policy, system, action); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+			return
+		}
+	}
+	records, failRes, ok := project.OffboardRecords(map[string]interface{}{
+		"accounts":   req.Accounts,
+		"excel_file": req.ExcelFile,
+	})
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: failRes.Message + "：" + failRes.Error})
+		return
+	}
+
+	states, err := s.ensureWorkflowSessions(u, systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "offboard")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runOffboard(job.ID, u, systems, policy, records)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "offboard",
+		"systems":        systems,
+		"policy":         policy,
+		"total":          len(records),
+		"session_states": states,
+	})
+}
+
+func (s *server) runOffboard(jobID string, u authedUser, systems []string, policy map[string]string, records []map[string]interface{}) {
+	total := len(records)
+	items := make([]map[string]interface{}, 0, total)
+	plan := make([]project.AccountPlanItem, 0, total)
+	planned := map[string]bool{}
+	counts := map[string]int{}
+	policyText := "策略：" + project.FormatOffboardPolicy(policy)
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.LogLines = append(job.LogLines, policyText)
+	})
+	for idx, record := range records {
+		item, lines, steps := s.offboardOne(u, systems, policy, record)
+		items = append(items, item)
+		// Two leavers can resolve to the same account; it is planned once.
+		for _, one := range steps {
+			if !planned[one.Key] {
+				planned[one.Key] = true
+				plan = append(plan, one)
+			}
+		}
+		counts[item["status"].(string)]++
+		s.logAction(u.ID, u.Username, "workflow_offboard", "workflow", fmt.Sprintf("离职查找 %s：%s", item["account"], strings.Join(lines[1:], "；")))
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, lines...)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = idx + 1
+			job.Total = total
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+
+	summary := fmt.Sprintf("计划完成：全部找到 %d，部分失败 %d，失败 %d，未找到 %d，共 %d 人，其中可执行 %d 项", counts["success"], counts["partial"], counts["failed"], counts["not_found"], total, len(plan))
+	resultFile, err := project.OffboardReport(systems, items)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	resultItems := make([]interface{}, 0, len(items))
+	for _, one := range items {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = counts["failed"] < total
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = "所有人员均查找失败"
+		}
+		job.LogLines = append(job.LogLines, summary)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Plan = plan
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
+
+// offboardOne looks one leaver up on every system and plans the policy for
+// each account found. It returns the result item, its log lines (the header
+// line first) and the plan items.
+func (s *server) offboardOne(u authedUser, systems []string, policy map[string]string, record map[string]interface{}) (map[string]interface{}, []string, []project.AccountPlanItem) {
+	account, _ := record["account"].(string)
+	account = strings.TrimSpace(account)
+	row := record["__row"]
+	item := map[string]interface{}{
+		"row":     row,
+		"account": account,
+	}
+	header := fmt.Sprintf("第 %v 行：%s", row, account)
+	if account == "" {
+		item["ok"] = false
+		item["status"] = "failed"
+		item["status_text"] = "失败：用户名或邮箱不能为空"
+		item["steps"] = []map[string]interface{}{}
+		return item, []string{header, "校验失败：用户名或邮箱不能为空"}, nil
+	}
+
+	steps := make([]map[string]interface{}, 0, len(systems))
+	plan := make([]project.AccountPlanItem, 0, len(systems))
+	lines := []string{header}
+	found, failed := 0, 0
+	known := ""
+	for _, system := range systems {
+		step := s.offboardStep(u, system, policy[system], account, known)
+		if step["found"] == true {
+			found++
+			name, _ := step["account"].(string)
+			if known == "" {
+				known = name
+			}
+			if step["key"] != nil {
+				plan = append(plan, project.OffboardPlanItem(system, policy[system], row, account, name))
+			}
+		}
+		if step["ok"] != true {
+			failed++
+		}
+		steps = append(steps, step)
+		lines = append(lines, fmt.Sprintf("%s：%s", project.WorkflowSystemTitle(system), step["summary"]))
+	}
+
+	item["steps"] = steps
+	item["ok"] = failed == 0
+	switch {
+	case failed == len(systems):
+		item["status"] = "failed"
+		item["status_text"] = "全部失败"
+	case failed > 0:
+		item["status"] = "partial"
+		item["status_text"] = fmt.Sprintf("部分失败（失败 %d/%d）", failed, len(systems))
+	case found == 0:
+		item["status"] = "not_found"
+		item["status_text"] = "各系统均未找到账号"
+	default:
+		item["status"] = "success"
+		item["status_text"] = fmt.Sprintf("成功（找到 %d 个系统账号）", found)
+	}
+	return item, lines, plan
+}
+
+// offboardStep runs find_account on system and reports what the policy
+// would do to the account it found; a step with an action to run carries
+// its plan item key. When an email finds nothing, the username another
+// system resolved it to (known) is tried as well, since mail addresses drift
+// between systems. A system without the account counts as a success.
+func (s *server) offboardStep(u authedUser, system, policy, account, known string) map[string]interface{} {
+	step := map[string]interface{}{"system": system, "policy": policy, "found": false, "ok": false}
+	fail := func(prefix, reason string) map[string]interface{} {
+		step["error"] = reason
+		step["summary"] = prefix + "：" + reason
+		return step
+	}
+
+	entry, _, _, err := s.ensureProjectSession(u, system, false)
+	if err != nil {
+		return fail("查找失败", strings.TrimSpace(err.Error()))
+	}
+	res, err := s.operateWithProjectSession(entry, "find_account", map[string]interface{}{"account": account})
+	if err == nil && !res.OK {
+		err = errors.New(project.WorkflowStepError(res))
+	}
+	if err == nil && res.Data["found"] != true && known != "" && known != account {
+		res, err = s.operateWithProjectSession(entry, "find_account", map[string]interface{}{"account": known})
+		if err == nil && !res.OK {
+			err = errors.New(project.WorkflowStepError(res))
+		}
+	}
+	if err != nil {
+		return fail("查找失败", strings.TrimSpace(err.Error()))
+	}
+	if res.Data["found"] != true {
+		step["ok"] = true
+		step["summary"] = "未找到账号"
+		return step
+	}
+	name, _ := res.Data["account"].(string)
+	step["found"] = true
+	step["account"] = name
+	found := "找到 " + name
+	if status, _ := res.Data["status"].(string); status != "" {
+		found += "（" + status + "）"
+	}
+
+	step["ok"] = true
+	if action, _ := project.OffboardStep(system, policy, name); action == "" {
+		step["summary"] = found + "，按策略保留"
+		return step
+	}
+	step["key"] = project.OffboardPlanItem(system, policy, nil, account, name).Key
+	step["summary"] = found + "，将" + project.OffboardPolicyTitle(policy)
+	return step
+}
+
+func (s *server) handleOffboardApply(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req offboardApplyReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	if len(req.Keys) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少批准一项计划"})
+		return
+	}
+	planJob, err := s.finishedWorkflowJob(u, req.JobID, "offboard")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "计划" + err.Error()})
+		return
+	}
+	plan, err := planSelection(planJob.Plan, req.Keys)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	requested := make([]string, 0, len(plan))
+	for _, one := range plan {
+		requested = append(requested, one.System)
+	}
+	systems := workflowSystems(project.OffboardSystems, requested)
+	states, err := s.ensureWorkflowSessions(u, systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "offboard_apply")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runPlanApply(job.ID, u, "offboard", plan)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "offboard_apply",
+		"plan_job_id":    planJob.ID,
+		"total":          len(plan),
+		"session_states": states,
+	})
+}
@@ -0,0 +1,42 @@
+package runtime
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ops-admin-backend/internal/project"
+)
+
+func TestOffboardApplyOnlyTakesPlannedItems(t *testing.T) {
+	s := newTestServer(t, appConfig{CredentialKey: "test-secret"})
+	token := testLogin(t, s, "helpdesk")
+	u := authedUser{ID: 1, Username: "helpdesk"}
+	planned := project.OffboardPlanItem("vpn", project.OffboardDisable, 1, "lisi@example.com", "lisi")
+
+	job, err := s.createAsyncOperateJob(u, "workflow", "offboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(keys ...string) (int, string) {
+		w := testRequest(t, s, "/api/workflows/offboard/apply", token, map[string]interface{}{"job_id": job.ID, "keys": keys})
+		return w.Code, w.Body.String()
+	}
+	if code, body := apply(planned.Key); code != http.StatusBadRequest || !strings.Contains(body, "尚未完成") {
+		t.Fatalf("running plan: %d %s", code, body)
+	}
+
+	s.updateAsyncOperateJob(job.ID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = true
+		job.Plan = []project.AccountPlanItem{planned}
+	})
+	// A key the lookup never produced, such as another account on the same
+	// system, is refused instead of being looked up again.
+	if code, body := apply("vpn:disable:zhaoliu"); code != http.StatusBadRequest || !strings.Contains(body, "不在计划中") {
+		t.Fatalf("unplanned key: %d %s", code, body)
+	}
+	if code, body := apply(planned.Key, planned.Key); code != http.StatusBadRequest || !strings.Contains(body, "重复提交") {
+		t.Fatalf("repeated key: %d %s", code, body)
+	}
+}
@@ -0,0 +1,250 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ops-admin-backend/internal/project"
+)
+
+// Onboarding workflow: one async job creates each person on the selected
+// systems in the order AD, print, VPN. A failed step does not stop the
+// remaining steps or the remaining people; every step is reported on its own
+// so a partial onboarding can be finished by hand.
+
+type onboardReq struct {
+	Systems   []string                 `json:"systems"`
+	Person    map[string]interface{}   `json:"person"`
+	Rows      []map[string]interface{} `json:"rows"`
+	ExcelFile string                   `json:"excel_file"`
+	Password  string                   `json:"password"`
+	Defaults  map[string]interface{}   `json:"defaults"`
+}
+
+// workflowSystems keeps the requested systems in the workflow's order and
+// drops unknown or repeated names.
+func workflowSystems(order, requested []string) []string {
+	want := make(map[string]bool, len(requested))
+	for _, one := range requested {
+		want[strings.ToLower(strings.TrimSpace(one))] = true
+	}
+	out := make([]string, 0, len(order))
+	for _, one := range order {
+		if want[one] {
+			out = append(out, one)
+		}
+	}
+	return out
+}
+
+func (s *server) handleOnboardStart(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req onboardReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	systems := workflowSystems(project.OnboardSystems, req.Systems)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少选择一个目标系统"})
+		return
+	}
+	params := map[string]interface{}{
+		"person":     req.Person,
+		"excel_file": req.ExcelFile,
+		"defaults":   req.Defaults,
+	}
+	if len(req.Rows) > 0 {
+		rows := make([]interface{}, 0, len(req.Rows))
+		for _, one := range req.Rows {
+			rows = append(rows, one)
+		}
+		params["rows"] = rows
+	}
+	records, failRes, ok := project.OnboardRecords(params)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: failRes.Message + "：" + failRes.Error})
+		return
+	}
+
+	states, err := s.ensureWorkflowSessions(u, systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+
+	job, err := s.createAsyncOperateJob(u, "workflow", "onboard")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runOnboard(job.ID, u, systems, records, strings.TrimSpace(req.Password))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "onboard",
+		"systems":        systems,
+		"total":          len(records),
+		"session_states": states,
+	})
+}
+
+// ensureWorkflowSessions logs in to every target system before a workflow
+// starts, so a missing credential fails the request instead of every row.
+func (s *server) ensureWorkflowSessions(u authedUser, systems []string) (map[string]string, error) {
+	states := make(map[string]string, len(systems))
+	for _, system := range systems {
+		_, didLogin, _, err := s.ensureProjectSession(u, system, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s：%s", project.WorkflowSystemTitle(system), err.Error())
+		}
+		states[system] = projectSessionStateFromDidLogin(didLogin)
+	}
+	return states, nil
+}
+
+// applyWorkflowAction runs one project action of workflow on the caller's
+// session of system and logs it under that project. It returns the failure
+// reason, empty on success.
+func (s *server) applyWorkflowAction(u authedUser, workflow, system, action string, params map[string]interface{}) string {
+	entry, _, _, err := s.ensureProjectSession(u, system, false)
+	if err != nil {
+		return strings.TrimSpace(err.Error())
+	}
+	res, err := s.operateWithProjectSession(entry, action, params)
+	reason := ""
+	if err != nil {
+		reason = strings.TrimSpace(err.Error())
+	} else {
+		reason = project.WorkflowStepError(res)
+	}
+	if reason != "" {
+		s.logAction(u.ID, u.Username, "project_operate_failed", system, fmt.Sprintf("action=%s, workflow=%s, err=%s", action, workflow, reason))
+		return reason
+	}
+	s.logAction(u.ID, u.Username, "project_operate", system, fmt.Sprintf("action=%s, workflow=%s", action, workflow))
+	if system == "vpn" {
+		s.trackVPNExpiry(u, action, res)
+	}
+	return ""
+}
+
+func (s *server) runOnboard(jobID string, u authedUser, systems []string, records []map[string]interface{}, password string) {
+	total := len(records)
+	items := make([]map[string]interface{}, 0, total)
+	counts := map[string]int{}
+	for idx, person := range records {
+		item, lines := s.onboardOne(u, systems, person, password)
+		items = append(items, item)
+		counts[item["status"].(string)]++
+		s.logAction(u.ID, u.Username, "workflow_onboard", "workflow", fmt.Sprintf("用户 %s：%s", item["username"], strings.Join(lines[1:], "；")))
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, lines...)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = idx + 1
+			job.Total = total
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+
+	summary := fmt.Sprintf("入职完成：全部成功 %d，部分失败 %d，失败 %d，共 %d 人", counts["success"], counts["partial"], counts["failed"], total)
+	resultFile, err := project.OnboardReport(systems, items)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	resultItems := make([]interface{}, 0, len(items))
+	for _, one := range items {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = counts["failed"] < total
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = "所有人员入职均失败"
+		}
+		job.LogLines = append(job.LogLines, summary)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
+
+// onboardOne runs every system step for one person and returns the result
+// item and its log lines, the header line first.
+func (s *server) onboardOne(u authedUser, systems []string, person map[string]interface{}, password string) (map[string]interface{}, []string) {
+	field := func(key string) string {
+		v, _ := person[key].(string)
+		return strings.TrimSpace(v)
+	}
+	row := person["__row"]
+	item := map[string]interface{}{
+		"row":      row,
+		"username": field("username"),
+		"name":     field("name"),
+		"password": "",
+	}
+	header := fmt.Sprintf("第 %v 行：%s（%s）", row, field("username"), field("name"))
+	if err := project.OnboardCheck(person); err != nil {
+		item["ok"] = false
+		item["status"] = "failed"
+		item["status_text"] = "失败：" + err.Error()
+		item["steps"] = []map[string]interface{}{}
+		return item, []string{header, "校验失败：" + err.Error()}
+	}
+
+	shared := project.OnboardPassword(person, password)
+	passwords := project.OnboardPasswords(systems, shared)
+	steps := make([]map[string]interface{}, 0, len(systems))
+	lines := []string{header}
+	okCount := 0
+	sharedUsed := false
+	for _, system := range systems {
+		title := project.WorkflowSystemTitle(system)
+		pwd := passwords[system]
+		step := map[string]interface{}{"system": system, "ok": false}
+
+		if reason := s.applyWorkflowAction(u, "onboard", system, "add_user", project.OnboardParams(system, person, pwd)); reason != "" {
+			step["error"] = reason
+			step["summary"] = "失败：" + reason
+		} else {
+			okCount++
+			step["ok"] = true
+			step["summary"] = "成功"
+			if pwd == shared {
+				sharedUsed = true
+			} else {
+				step["password"] = pwd
+				step["summary"] = "成功（已改用生成密码）"
+			}
+		}
+		steps = append(steps, step)
+		lines = append(lines, fmt.Sprintf("%s：%s", title, step["summary"]))
+	}
+
+	if sharedUsed {
+		item["password"] = shared
+	}
+	item["steps"] = steps
+	item["ok"] = okCount == len(systems)
+	switch okCount {
+	case len(systems):
+		item["status"] = "success"
+		item["status_text"] = "全部成功"
+	case 0:
+		item["status"] = "failed"
+		item["status_text"] = "全部失败"
+	default:
+		item["status"] = "partial"
+		item["status_text"] = fmt.Sprintf("部分失败（成功 %d/%d）", okCount, len(systems))
+	}
+	return item, lines
+}
@@ -0,0 +1,336 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ops-admin-backend/internal/project"
+)
+
+// Account reconciliation: one async job reads the full user lists of AD and
+// the selected systems and reports every difference. Remediation is a second
+// job that applies the action chosen for each selected difference; nothing is
+// changed by the report itself. A fix names the report job and the keys of
+// its differences, so only differences the server found can be acted on.
+// Differences of the PROTECTED_ACCOUNTS are only reported.
+
+type reconcileReq struct {
+	Systems []string `json:"systems"`
+}
+
+type reconcileFixItem struct {
+	project.ReconcileIssue
+	Action string
+}
+
+type reconcileFixReq struct {
+	JobID string `json:"job_id"`
+	Items []struct {
+		Key    string `json:"key"`
+		Action string `json:"action"`
+	} `json:"items"`
+}
+
+func (s *server) handleReconcileStart(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req reconcileReq
+	if err := decodeOptionalJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	requested := req.Systems
+	if len(requested) == 0 {
+		requested = project.ReconcileSystems
+	}
+	systems := workflowSystems(project.ReconcileSystems, requested)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少选择一个目标系统"})
+		return
+	}
+
+	states, err := s.ensureWorkflowSessions(u, append([]string{"ad"}, systems...))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "reconcile")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runReconcile(job.ID, u, systems)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "reconcile",
+		"systems":        systems,
+		"session_states": states,
+	})
+}
+
+// listAccounts runs list_accounts on system with the caller's session.
+func (s *server) listAccounts(u authedUser, system string) ([]project.PersonAccount, error) {
+	entry, _, _, err := s.ensureProjectSession(u, system, false)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.operateWithProjectSession(entry, "list_accounts", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, errors.New(project.WorkflowStepError(res))
+	}
+	items, _ := res.Data["items"].([]project.PersonAccount)
+	return items, nil
+}
+
+func (s *server) runReconcile(jobID string, u authedUser, systems []string) {
+	total := len(systems) + 1
+	logLine := func(done int, line string) {
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, line)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = done
+			job.Total = total
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+
+	ad, err := s.listAccounts(u, "ad")
+	if err != nil {
+		reason := strings.TrimSpace(err.Error())
+		s.logAction(u.ID, u.Username, "workflow_reconcile", "workflow", truncate("读取AD用户失败："+reason, 600))
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.Done = true
+			job.OK = false
+			job.Status = asyncJobStatusFailed
+			job.Message = "对账失败"
+			job.Error = "读取AD用户失败：" + reason
+			job.LogLines = append(job.LogLines, job.Error)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Progress = 100
+		})
+		return
+	}
+	logLine(1, fmt.Sprintf("AD：读取 %d 个账号", len(ad)))
+
+	lists := make(map[string][]project.PersonAccount, len(systems))
+	failed := make([]string, 0)
+	for idx, system := range systems {
+		title := project.WorkflowSystemTitle(system)
+		accounts, err := s.listAccounts(u, system)
+		if err != nil {
+			failed = append(failed, title)
+			logLine(idx+2, fmt.Sprintf("%s：读取失败，已跳过：%s", title, strings.TrimSpace(err.Error())))
+			continue
+		}
+		lists[system] = accounts
+		logLine(idx+2, fmt.Sprintf("%s：读取 %d 个账号", title, len(accounts)))
+	}
+
+	issues := project.ReconcileAccounts(ad, lists, s.cfg.ProtectedAccounts)
+	counts := map[string]int{}
+	protected := 0
+	for _, one := range issues {
+		counts[one.Issue]++
+		if one.Protected {
+			protected++
+		}
+	}
+	summary := fmt.Sprintf("对账完成：孤立账号 %d，缺少账号 %d，姓名不一致 %d，邮箱不一致 %d，状态不一致 %d，共 %d 项差异（其中受保护账号 %d 项，仅报告）",
+		counts[project.ReconcileOrphan], counts[project.ReconcileMissing], counts[project.ReconcileNameMismatch],
+		counts[project.ReconcileEmailMismatch], counts[project.ReconcileStatusMismatch], len(issues), protected)
+	if len(failed) > 0 {
+		summary += "（" + strings.Join(failed, "、") + "读取失败，未参与对账）"
+	}
+	resultFile, err := project.ReconcileReport(issues)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	s.logAction(u.ID, u.Username, "workflow_reconcile", "workflow", truncate(strings.SplitN(summary, "\n", 2)[0], 600))
+
+	resultItems := make([]interface{}, 0, len(issues))
+	for _, one := range issues {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = len(lists) > 0
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = "所有目标系统均读取失败"
+		}
+		job.LogLines = append(job.LogLines, summary)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Issues = issues
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
+
+func (s *server) handleReconcileFix(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req reconcileFixReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	if len(req.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少选择一项差异"})
+		return
+	}
+	report, err := s.finishedWorkflowJob(u, req.JobID, "reconcile")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "对账" + err.Error()})
+		return
+	}
+	fixes, err := reconcileFixSelection(report.Issues, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	requested := make([]string, 0, len(fixes))
+	for _, one := range fixes {
+		requested = append(requested, one.System)
+	}
+	systems := workflowSystems(project.ReconcileSystems, requested)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "不支持的目标系统"})
+		return
+	}
+	states, err := s.ensureWorkflowSessions(u, systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "reconcile_fix")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runReconcileFix(job.ID, u, fixes)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "reconcile_fix",
+		"total":          len(fixes),
+		"session_states": states,
+	})
+}
+
+// reconcileFixSelection looks the selected keys up in the report's issues
+// and checks each chosen action is one the issue allows.
+func reconcileFixSelection(issues []project.ReconcileIssue, req reconcileFixReq) ([]reconcileFixItem, error) {
+	byKey := make(map[string]project.ReconcileIssue, len(issues))
+	for _, one := range issues {
+		byKey[one.Key] = one
+	}
+	fixes := make([]reconcileFixItem, 0, len(req.Items))
+	seen := make(map[string]bool, len(req.Items))
+	for _, one := range req.Items {
+		issue, ok := byKey[one.Key]
+		if !ok {
+			return nil, fmt.Errorf("差异 %s 不在对账结果中", one.Key)
+		}
+		if seen[one.Key] {
+			return nil, fmt.Errorf("差异 %s 重复提交", one.Key)
+		}
+		seen[one.Key] = true
+		allowed := false
+		for _, action := range issue.Actions {
+			allowed = allowed || action == one.Action
+		}
+		if !allowed {
+			return nil, fmt.Errorf("差异 %s 不支持修复操作：%s", one.Key, one.Action)
+		}
+		fixes = append(fixes, reconcileFixItem{ReconcileIssue: issue, Action: one.Action})
+	}
+	return fixes, nil
+}
+
+func (s *server) runReconcileFix(jobID string, u authedUser, fixes []reconcileFixItem) {
+	total := len(fixes)
+	items := make([]map[string]interface{}, 0, total)
+	okCount := 0
+	for idx, fix := range fixes {
+		item := s.reconcileFixOne(u, fix)
+		items = append(items, item)
+		if item["ok"] == true {
+			okCount++
+		}
+		line := fmt.Sprintf("%s %s（%s）：%s", project.WorkflowSystemTitle(fix.System), fix.Username, project.ReconcileActionTitle(fix.Action), item["summary"])
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, line)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = idx + 1
+			job.Total = total
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+
+	summary := fmt.Sprintf("修复完成：成功 %d，失败 %d，共 %d 项", okCount, total-okCount, total)
+	s.logAction(u.ID, u.Username, "workflow_reconcile_fix", "workflow", summary)
+	resultFile, err := project.ReconcileFixReport(items)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	resultItems := make([]interface{}, 0, len(items))
+	for _, one := range items {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = okCount == total
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = fmt.Sprintf("%d 项修复失败", total-okCount)
+		}
+		job.LogLines = append(job.LogLines, summary)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
+
+// reconcileFixOne applies one remediation. A new account's password is
+// returned in the item so it can be handed over.
+func (s *server) reconcileFixOne(u authedUser, fix reconcileFixItem) map[string]interface{} {
+	item := map[string]interface{}{
+		"key":        fix.Key,
+		"system":     fix.System,
+		"issue":      fix.Issue,
+		"issue_text": fix.IssueText,
+		"username":   fix.Username,
+		"action":     fix.Action,
+		"ok":         false,
+	}
+	action, params, password, err := project.ReconcileFix(fix.ReconcileIssue, fix.Action)
+	if err != nil {
+		item["summary"] = "失败：" + err.Error()
+		return item
+	}
+	if reason := s.applyWorkflowAction(u, "reconcile", fix.System, action, params); reason != "" {
+		item["summary"] = "失败：" + reason
+		return item
+	}
+	item["ok"] = true
+	item["password"] = password
+	item["summary"] = "修复成功"
+	return item
+}
@@ -0,0 +1,187 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ops-admin-backend/internal/project"
+)
+
+// HR roster: planning is an async job that reads the live account lists and
+// compares them with an uploaded roster; it changes nothing. The server keeps
+// the plan, and the operator approves its items by key; a second job applies
+// those on the same project sessions. Accounts missing from the roster follow
+// OFFBOARD_POLICY, except the PROTECTED_ACCOUNTS, which are only listed.
+
+type rosterReq struct {
+	Systems   []string `json:"systems"`
+	ExcelFile string   `json:"excel_file"`
+}
+
+type rosterApplyReq struct {
+	JobID string   `json:"job_id"`
+	Keys  []string `json:"keys"`
+}
+
+func (s *server) handleRosterPlan(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req rosterReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	systems := workflowSystems(project.RosterSystems, req.Systems)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少选择一个目标系统"})
+		return
+	}
+	records, problems, failRes, ok := project.RosterRecords(map[string]interface{}{"excel_file": req.ExcelFile})
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: failRes.Message + "：" + failRes.Error})
+		return
+	}
+	// An empty roster would plan every account for offboarding.
+	if len(records) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "花名册没有可用的人员：" + strings.Join(problems, "；")})
+		return
+	}
+
+	states, err := s.ensureWorkflowSessions(u, systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "roster")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runRosterPlan(job.ID, u, systems, records, problems)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "roster",
+		"systems":        systems,
+		"total":          len(records),
+		"session_states": states,
+	})
+}
+
+func (s *server) runRosterPlan(jobID string, u authedUser, systems []string, records []map[string]interface{}, problems []string) {
+	total := len(systems)
+	lines := []string{fmt.Sprintf("花名册：%d 人", len(records))}
+	for _, one := range problems {
+		lines = append(lines, "已跳过 "+one)
+	}
+	lines = append(lines, "离职策略："+project.FormatOffboardPolicy(s.cfg.OffboardPolicy))
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.LogLines = append(job.LogLines, lines...)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+	})
+
+	lists, failed := s.listPlanAccounts(jobID, u, systems)
+	items := project.RosterPlan(records, lists, s.cfg.OffboardPolicy, s.cfg.ProtectedAccounts)
+	counts := map[string]int{}
+	actionable, protected := 0, 0
+	for _, one := range items {
+		counts[one.Op]++
+		if one.Actionable {
+			actionable++
+		}
+		if one.Protected {
+			protected++
+		}
+	}
+	summary := fmt.Sprintf("计划完成：创建 %d，更新 %d，禁用 %d，删除 %d，保留 %d（其中受保护账号 %d），其中可执行 %d 项",
+		counts[project.AccountCreate], counts[project.AccountUpdate], counts[project.AccountDisable],
+		counts[project.AccountDelete], counts[project.AccountKeep], protected, actionable)
+	summary += planReportOnlyNote(items)
+	if len(problems) > 0 {
+		summary += fmt.Sprintf("；花名册跳过 %d 行", len(problems))
+	}
+	if len(failed) > 0 {
+		summary += "（" + strings.Join(failed, "、") + "读取失败，未纳入计划）"
+	}
+	resultFile, err := project.AccountPlanReport("roster", items)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	s.logAction(u.ID, u.Username, "workflow_roster", "workflow", truncate(strings.SplitN(summary, "\n", 2)[0], 600))
+
+	resultItems := make([]interface{}, 0, len(items))
+	for _, one := range items {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = len(lists) > 0
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = "所有目标系统均读取失败"
+		}
+		job.LogLines = append(job.LogLines, summary)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Plan = items
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
+
+func (s *server) handleRosterApply(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req rosterApplyReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	if len(req.Keys) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少批准一项计划"})
+		return
+	}
+	planJob, err := s.finishedWorkflowJob(u, req.JobID, "roster")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "计划" + err.Error()})
+		return
+	}
+	plan, err := planSelection(planJob.Plan, req.Keys)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	requested := make([]string, 0, len(plan))
+	for _, one := range plan {
+		requested = append(requested, one.System)
+	}
+	systems := workflowSystems(project.RosterSystems, requested)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "不支持的目标系统"})
+		return
+	}
+	states, err := s.ensureWorkflowSessions(u, systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "roster_apply")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runPlanApply(job.ID, u, "roster", plan)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "roster_apply",
+		"total":          len(plan),
+		"session_states": states,
+	})
+}
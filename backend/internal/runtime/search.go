@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"ops-admin-backend/internal/project"
+)
+
+// Person search: one keyword is searched on every selected system at the same
+// time, each on the caller's own project session. A system that fails is
+// reported on its own line and the people found elsewhere are still returned.
+
+type personSearchSystem struct {
+	System       string `json:"system"`
+	OK           bool   `json:"ok"`
+	Count        int    `json:"count"`
+	Error        string `json:"error,omitempty"`
+	SessionState string `json:"session_state,omitempty"`
+
+	accounts []project.PersonAccount
+}
+
+func (s *server) handlePersonSearch(w http.ResponseWriter, r *http.Request, u authedUser) {
+	keyword := strings.TrimSpace(r.URL.Query().Get("q"))
+	if keyword == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请输入用户名、邮箱或姓名"})
+		return
+	}
+	requested := project.PersonSearchDefault
+	if raw := strings.TrimSpace(r.URL.Query().Get("systems")); raw != "" {
+		requested = strings.Split(raw, ",")
+	}
+	systems := workflowSystems(project.PersonSearchSystems, requested)
+	if len(systems) == 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请至少选择一个目标系统"})
+		return
+	}
+
+	results := make([]personSearchSystem, len(systems))
+	var wg sync.WaitGroup
+	for i, system := range systems {
+		wg.Add(1)
+		go func(i int, system string) {
+			defer wg.Done()
+			results[i] = s.searchPersonOn(u, system, keyword)
+		}(i, system)
+	}
+	wg.Wait()
+
+	// Only systems that answered can say a person has no account there.
+	accounts := make([]project.PersonAccount, 0)
+	answered := make([]string, 0, len(results))
+	failed := make([]string, 0)
+	for _, one := range results {
+		accounts = append(accounts, one.accounts...)
+		if one.OK {
+			answered = append(answered, one.System)
+		} else {
+			failed = append(failed, fmt.Sprintf("%s失败：%s", project.WorkflowSystemTitle(one.System), one.Error))
+		}
+	}
+	persons := project.MergePersons(answered, accounts)
+
+	detail := fmt.Sprintf("关键字=%s，找到 %d 人", keyword, len(persons))
+	if len(failed) > 0 {
+		detail += "；" + strings.Join(failed, "；")
+	}
+	s.logAction(u.ID, u.Username, "person_search", "search", truncate(detail, 600))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   keyword,
+		"systems": results,
+		"persons": persons,
+		"total":   len(persons),
+	})
+}
+
+// searchPersonOn runs every search_user query of system for keyword and keeps
+// the accounts that match, once each.
+func (s *server) searchPersonOn(u authedUser, system, keyword string) personSearchSystem {
+	out := personSearchSystem{System: system}
+	entry, didLogin, _, err := s.ensureProjectSession(u, system, false)
+	if err != nil {
+		out.Error = strings.TrimSpace(err.Error())
+		return out
+	}
+	out.SessionState = projectSessionStateFromDidLogin(didLogin)
+
+	seen := map[string]bool{}
+	for _, params := range project.PersonSearchParams(system, keyword) {
+		res, err := s.operateWithProjectSession(entry, "search_user", params)
+		if err == nil && !res.OK {
+			reason := strings.TrimSpace(res.Error)
+			if reason == "" {
+				reason = strings.TrimSpace(res.Message)
+			}
+			err = errors.New(reason)
+		}
+		if err != nil {
+			out.Error = strings.TrimSpace(err.Error())
+			return out
+		}
+		for _, one := range project.PersonAccounts(system, res) {
+			key := strings.ToLower(one.Username)
+			if seen[key] || !project.PersonMatches(one, keyword) {
+				continue
+			}
+			seen[key] = true
+			out.accounts = append(out.accounts, one)
+		}
+	}
+	out.OK = true
+	out.Count = len(out.accounts)
+	return out
+}
@@ -14,8 +14,7 @@ type managedProjectSession struct {
 	token       string
 	userID      int64
 	projectType string
-	username    string
-	password    string
+	cred        project.Credential
 	session     project.Session
 	loadedAt    time.Time
 	lastUsedAt  time.Time
@@ -32,12 +31,12 @@ func newProjectSessionManager() *projectSessionManager {
 	}
 }
 
-func (m *projectSessionManager) ensure(u authedUser, projectType, username, password string, ttl time.Duration, forceRelogin bool) (*managedProjectSession, bool, string, error) {
+func (m *projectSessionManager) ensure(u authedUser, projectType string, cred project.Credential, ttl time.Duration, forceRelogin bool) (*managedProjectSession, bool, string, error) {
 	now := time.Now()
 
 	m.mu.Lock()
 	existing := m.getLocked(u.Token, projectType)
-	if existing != nil && !forceRelogin && !m.isExpiredLocked(existing, username, password, ttl, now) {
+	if existing != nil && !forceRelogin && !m.isExpiredLocked(existing, cred, ttl, now) {
 		existing.lastUsedAt = now
 		m.mu.Unlock()
 		return existing, false, "", nil
@@ -47,7 +46,7 @@ func (m *projectSessionManager) ensure(u authedUser, projectType, username, pass
 	}
 	m.mu.Unlock()
 
-	session, message, err := project.OpenSession(projectType, username, password)
+	session, message, err := project.OpenSession(projectType, cred)
 	if err != nil {
 		if message == "" {
 			message = loginFailureMessage(projectType)
@@ -62,8 +61,7 @@ func (m *projectSessionManager) ensure(u authedUser, projectType, username, pass
 		token:       u.Token,
 		userID:      u.ID,
 		projectType: projectType,
-		username:    username,
-		password:    password,
+		cred:        cred,
 		session:     session,
 		loadedAt:    now,
 		lastUsedAt:  now,
@@ -179,11 +177,11 @@ func (m *projectSessionManager) removeLocked(token, projectType string) {
 	}
 }
 
-func (m *projectSessionManager) isExpiredLocked(item *managedProjectSession, username, password string, ttl time.Duration, now time.Time) bool {
+func (m *projectSessionManager) isExpiredLocked(item *managedProjectSession, cred project.Credential, ttl time.Duration, now time.Time) bool {
 	if item == nil || item.session == nil {
 		return true
 	}
-	if item.username != username || item.password != password {
+	if item.cred != cred {
 		return true
 	}
 	if ttl > 0 && now.Sub(item.loadedAt) >= ttl {
@@ -200,17 +198,19 @@ func loginFailureMessage(projectType string) string {
 		return "打印管理登录失败"
 	case "vpn":
 		return "VPN 登录失败"
+	case "firewall":
+		return "防火墙登录失败"
 	default:
 		return "项目登录失败"
 	}
 }
 
 func (s *server) ensureProjectSession(u authedUser, projectType string, forceRelogin bool) (*managedProjectSession, bool, string, error) {
-	account, password, err := s.getProjectCredential(u.ID, projectType)
+	cred, err := s.getProjectCredential(u.ID, projectType)
 	if err != nil {
 		return nil, false, "", err
 	}
-	return s.projectSessions.ensure(u, projectType, account, password, s.cfg.ProjectCacheTTL, forceRelogin)
+	return s.projectSessions.ensure(u, projectType, cred, s.cfg.ProjectCacheTTL, forceRelogin)
 }
 
 func (s *server) operateWithProjectSession(entry *managedProjectSession, action string, params map[string]interface{}) (projectResult, error) {
@@ -220,10 +220,8 @@ func (s *server) operateWithProjectSession(entry *managedProjectSession, action
 	if params == nil {
 		params = map[string]interface{}{}
 	}
-	if entry.projectType == "vpn" {
-		params["__vpn_account"] = entry.username
-		params["__vpn_password"] = entry.password
-	}
+	s.projectSessions.mu.Lock()
 	entry.lastUsedAt = time.Now()
+	s.projectSessions.mu.Unlock()
 	return entry.session.Operate(action, params)
 }
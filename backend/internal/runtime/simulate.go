@@ -0,0 +1,62 @@
+package runtime
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"ops-admin-backend/internal/simulate"
+)
+
+// startSimulators launches the in-process AD, print, VPN and firewall fakes
+// and points cfg at them instead of the real hosts.
+func startSimulators(cfg *appConfig) (*simulate.Env, error) {
+	// The print fake only has to agree with this process, so demo values do
+	// when the real ones are not configured.
+	if cfg.PrintAESKey == "" {
+		cfg.PrintAESKey = "simulate-aes-key"
+	}
+	if cfg.PrintBootstrapCSRF == "" {
+		cfg.PrintBootstrapCSRF = "simulate-bootstrap-csrf"
+	}
+	env, err := simulate.Start(simulate.Options{
+		PrintAESKey:        cfg.PrintAESKey,
+		PrintBootstrapCSRF: cfg.PrintBootstrapCSRF,
+		Account:            cfg.SimulateAccount,
+		Password:           cfg.SimulatePassword,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start simulators failed: %w", err)
+	}
+	cfg.ADAPIURL = env.ADURL
+	cfg.PrintAPIURL = env.PrintURL
+	cfg.VPNSshAddr = env.VPNAddr
+	cfg.VPNSSHPort = env.VPNPort
+	cfg.FirewallSSHAddr = env.FirewallAddr
+	cfg.FirewallSSHPort = env.FirewallPort
+	// The CLI fakes keep expire-date like firmware with account validity.
+	cfg.VPNDeviceExpiry = true
+	return env, nil
+}
+
+// trustSimulatorHostKeys pins the simulators' host key so SSH logins work
+// without a confirmation round. The ports change on every start, so keys left
+// by earlier runs are dropped first.
+func (s *server) trustSimulatorHostKeys(env *simulate.Env) error {
+	if _, err := s.db.Exec(`DELETE FROM ssh_host_keys WHERE confirmed_by='simulate'`); err != nil {
+		return err
+	}
+	now := nowStr()
+	for _, host := range []string{
+		net.JoinHostPort(env.VPNAddr, strconv.Itoa(env.VPNPort)),
+		net.JoinHostPort(env.FirewallAddr, strconv.Itoa(env.FirewallPort)),
+	} {
+		if _, err := s.db.Exec(`INSERT INTO ssh_host_keys(host,key_type,fingerprint,pending_key_type,pending_fingerprint,confirmed_by,confirmed_at,updated_at) VALUES(?,?,?,'','','simulate',?,?)
+		ON CONFLICT(host) DO UPDATE SET key_type=excluded.key_type,fingerprint=excluded.fingerprint,pending_key_type='',pending_fingerprint='',confirmed_by=excluded.confirmed_by,confirmed_at=excluded.confirmed_at,updated_at=excluded.updated_at`,
+			host, env.HostKeyType, env.HostKeyFingerprint, now, now,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ops-admin-backend/internal/project"
+)
+
+// Declarative accounts: the caller sends a desired-state file. Planning is an
+// async job that reads the live account lists and reports what would change;
+// the server keeps the plan. Apply names a plan job rather than a file: it
+// reads the live state again and carries out the plan's actionable items only
+// if the live state still yields exactly the plan the operator reviewed.
+// Undeclared accounts are only touched when prune is set, never the
+// PROTECTED_ACCOUNTS, and a prune that deletes more than
+// STATE_PRUNE_MAX_PERCENT of a system's accounts has to be forced.
+
+type stateReq struct {
+	Content string `json:"content"`
+	Prune   bool   `json:"prune"`
+}
+
+type stateApplyReq struct {
+	JobID string `json:"job_id"`
+	Force bool   `json:"force"`
+}
+
+// statePlan is what a plan job keeps for the apply that follows it.
+type statePlan struct {
+	jobID     string
+	state     project.DesiredState
+	prune     bool
+	digest    string
+	overLimit []string
+}
+
+func (s *server) handleStatePlan(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req stateReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	state, err := project.ParseDesiredState(req.Content)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	states, err := s.ensureWorkflowSessions(u, state.Systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "state")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runState(job.ID, u, statePlan{state: state, prune: req.Prune}, false, false)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "state",
+		"systems":        state.Systems,
+		"total":          len(state.Accounts),
+		"prune":          req.Prune,
+		"session_states": states,
+	})
+}
+
+func (s *server) handleStateApply(w http.ResponseWriter, r *http.Request, u authedUser) {
+	var req stateApplyReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "请求体格式错误"})
+		return
+	}
+	planJob, err := s.finishedWorkflowJob(u, req.JobID, "state")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "计划" + err.Error()})
+		return
+	}
+	plan := *planJob.StatePlan
+	if len(plan.overLimit) > 0 && !req.Force {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: stateOverLimitError(plan.overLimit)})
+		return
+	}
+	states, err := s.ensureWorkflowSessions(u, plan.state.Systems)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	job, err := s.createAsyncOperateJob(u, "workflow", "state_apply")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "创建异步任务失败"})
+		return
+	}
+	go s.runState(job.ID, u, plan, true, req.Force)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":         job.ID,
+		"status":         job.Status,
+		"created_at":     job.CreatedAt.Format(time.RFC3339),
+		"project_type":   "workflow",
+		"action":         "state_apply",
+		"plan_job_id":    planJob.ID,
+		"systems":        plan.state.Systems,
+		"total":          len(plan.state.Accounts),
+		"prune":          plan.prune,
+		"force":          req.Force,
+		"session_states": states,
+	})
+}
+
+func stateOverLimitError(overLimit []string) string {
+	return "清理删除的账号超过上限：" + strings.Join(overLimit, "；") + "。确认无误后请强制执行（force）"
+}
+
+// runState plans plan.state against the live account lists. A plan job
+// keeps the result for a later apply; an apply carries out the actionable
+// items only if the plan still has the digest the plan job recorded.
+func (s *server) runState(jobID string, u authedUser, plan statePlan, apply, force bool) {
+	state, prune := plan.state, plan.prune
+	logAction := "workflow_state"
+	if apply {
+		logAction = "workflow_state_apply"
+	}
+	lines := []string{fmt.Sprintf("声明文件：%d 个账号，管理系统：%s", len(state.Accounts), stateSystemsText(state.Systems))}
+	if apply {
+		lines = append(lines, fmt.Sprintf("按计划 %s 执行：重新读取现状，与计划一致才执行", plan.jobID))
+	}
+	if prune {
+		lines = append(lines, "已开启清理：未声明的账号将被删除")
+	} else {
+		lines = append(lines, "未开启清理：未声明的账号保持不变")
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.LogLines = append(job.LogLines, lines...)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+	})
+
+	total := len(state.Systems)
+	lists, failed := s.listPlanAccounts(jobID, u, state.Systems)
+	items := project.PlanDesiredState(state, lists, prune, s.cfg.ProtectedAccounts)
+	counts := map[string]int{}
+	actionable := make([]project.AccountPlanItem, 0, len(items))
+	for _, one := range items {
+		counts[one.Op]++
+		if one.Actionable {
+			actionable = append(actionable, one)
+		}
+	}
+	summary := fmt.Sprintf("计划完成：创建 %d，更新 %d，删除 %d，其中可执行 %d 项",
+		counts[project.AccountCreate], counts[project.AccountUpdate], counts[project.AccountDelete], len(actionable))
+	summary += planReportOnlyNote(items)
+	if len(failed) > 0 {
+		summary += "（" + strings.Join(failed, "、") + "读取失败，未纳入计划）"
+	}
+	if len(lists) == 0 {
+		s.logAction(u.ID, u.Username, logAction, "workflow", truncate(summary, 600))
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.Done = true
+			job.OK = false
+			job.Status = asyncJobStatusFailed
+			job.Message = summary
+			job.Error = "所有目标系统均读取失败"
+			job.LogLines = append(job.LogLines, summary)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Progress = 100
+		})
+		return
+	}
+	overLimit := project.PruneOverLimit(items, lists, s.cfg.StatePruneMax)
+	digest := project.DesiredStateDigest(items)
+	if !apply {
+		s.finishStatePlan(jobID, u, statePlan{jobID: jobID, state: state, prune: prune, digest: digest, overLimit: overLimit}, items, summary)
+		return
+	}
+	refused := ""
+	switch {
+	case digest != plan.digest:
+		refused = "现状已变化，与已确认的计划不一致，未执行任何操作，请重新生成计划"
+	case len(overLimit) > 0 && !force:
+		refused = stateOverLimitError(overLimit)
+	}
+	if refused != "" {
+		s.logAction(u.ID, u.Username, logAction, "workflow", truncate(refused+"；"+summary, 600))
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.Done = true
+			job.OK = false
+			job.Status = asyncJobStatusFailed
+			job.Message = "执行被拒绝"
+			job.Error = refused
+			job.LogLines = append(job.LogLines, summary, refused)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Progress = 100
+		})
+		return
+	}
+	if len(overLimit) > 0 {
+		summary += "；已强制执行：" + strings.Join(overLimit, "；")
+	}
+
+	planLines := []string{summary}
+	for _, one := range items {
+		if !one.Actionable {
+			planLines = append(planLines, fmt.Sprintf("%s %s（%s）：未执行，%s", project.WorkflowSystemTitle(one.System), one.Username, one.OpText, one.Note))
+		}
+	}
+	total += len(actionable)
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.LogLines = append(job.LogLines, planLines...)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.Total = total
+	})
+	results := make([]map[string]interface{}, 0, len(actionable))
+	okCount := 0
+	for idx, one := range actionable {
+		item := s.applyPlanItem(u, "state", one)
+		results = append(results, item)
+		if item["ok"] == true {
+			okCount++
+		}
+		line := fmt.Sprintf("%s %s（%s）：%s", project.WorkflowSystemTitle(one.System), one.Username, one.OpText, item["summary"])
+		s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+			job.LogLines = append(job.LogLines, line)
+			job.ResultText = strings.Join(job.LogLines, "\n")
+			job.Processed = len(state.Systems) + idx + 1
+			job.Progress = calcJobProgress(job.Processed, job.Total, len(job.LogLines), false)
+		})
+	}
+
+	done := fmt.Sprintf("执行完成：成功 %d，失败 %d，共 %d 项", okCount, len(actionable)-okCount, len(actionable))
+	if len(actionable) == 0 {
+		done = "执行完成：已是声明的状态，无需变更"
+	}
+	s.logAction(u.ID, u.Username, logAction, "workflow", truncate(done+"；"+summary, 600))
+	resultFile, err := project.AccountApplyReport("state", results)
+	if err != nil {
+		done += "\n保存结果文件失败：" + err.Error()
+	}
+	resultItems := make([]interface{}, 0, len(results))
+	for _, one := range results {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = okCount == len(actionable)
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(done, "\n", 2)[0]
+		if !job.OK {
+			job.Status = asyncJobStatusFailed
+			job.Error = fmt.Sprintf("%d 项执行失败", len(actionable)-okCount)
+		}
+		job.LogLines = append(job.LogLines, done)
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.Processed = total
+		job.Total = total
+		job.Progress = 100
+	})
+}
+
+func (s *server) finishStatePlan(jobID string, u authedUser, plan statePlan, items []project.AccountPlanItem, summary string) {
+	if len(plan.overLimit) > 0 {
+		summary += "；清理超过上限，执行时需强制确认：" + strings.Join(plan.overLimit, "；")
+	}
+	resultFile, err := project.AccountPlanReport("state", items)
+	if err != nil {
+		summary += "\n保存结果文件失败：" + err.Error()
+	}
+	s.logAction(u.ID, u.Username, "workflow_state", "workflow", truncate(strings.SplitN(summary, "\n", 2)[0], 600))
+	resultItems := make([]interface{}, 0, len(items))
+	for _, one := range items {
+		resultItems = append(resultItems, one)
+	}
+	s.updateAsyncOperateJob(jobID, func(job *asyncOperateJob) {
+		job.Done = true
+		job.OK = true
+		job.Status = asyncJobStatusSuccess
+		job.Message = strings.SplitN(summary, "\n", 2)[0]
+		job.LogLines = append(job.LogLines, summary, "计划编号："+plan.jobID+"，确认后按此编号执行")
+		job.ResultText = strings.Join(job.LogLines, "\n")
+		job.ResultItems = resultItems
+		job.ResultFile = resultFile
+		job.StatePlan = &plan
+		job.Processed = job.Total
+		job.Progress = 100
+	})
+}
+
+func stateSystemsText(systems []string) string {
+	titles := make([]string, 0, len(systems))
+	for _, one := range systems {
+		titles = append(titles, project.WorkflowSystemTitle(one))
+	}
+	return strings.Join(titles, "、")
+}
@@ -0,0 +1,192 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"ops-admin-backend/internal/project"
+)
+
+// RunAccountsCLI implements `accounts plan -f FILE` and `accounts apply -plan
+// ID`: a thin client that sends a desired-state file to a running server, or
+// applies a plan it made, follows the job and prints its log. It returns the
+// process exit code.
+func RunAccountsCLI(args []string) int {
+	usage := "用法：accounts plan -f 声明文件 [-prune] | accounts apply -plan 计划编号 [-force]，可加 [-server 地址] [-token 令牌]"
+	if len(args) == 0 || (args[0] != "plan" && args[0] != "apply") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("accounts "+cmd, flag.ContinueOnError)
+	file := fs.String("f", "", "声明文件（YAML 或 JSON）")
+	prune := fs.Bool("prune", false, "删除声明文件中没有的账号")
+	planID := fs.String("plan", "", "要执行的计划编号（plan 输出）")
+	force := fs.Bool("force", false, "清理超过上限时仍然执行")
+	serverURL := fs.String("server", envString("OPS_ADMIN_SERVER", "http://127.0.0.1:8080"), "服务地址")
+	token := fs.String("token", envString("OPS_ADMIN_TOKEN", ""), "登录令牌")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *token == "" || (cmd == "plan" && *file == "") || (cmd == "apply" && *planID == "") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	c := accountsClient{base: strings.TrimRight(*serverURL, "/"), token: *token, http: &http.Client{Timeout: 30 * time.Second}}
+	var started struct {
+		JobID string `json:"job_id"`
+	}
+	if cmd == "plan" {
+		content, err := os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "读取声明文件失败："+err.Error())
+			return 1
+		}
+		// Catch file errors before bothering the server.
+		if _, err := project.ParseDesiredState(string(content)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		if err := c.call(http.MethodPost, "/api/workflows/state", stateReq{Content: string(content), Prune: *prune}, &started); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	} else if err := c.call(http.MethodPost, "/api/workflows/state/apply", stateApplyReq{JobID: *planID, Force: *force}, &started); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	job, err := c.follow(started.JobID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if cmd == "plan" {
+		printStatePlan(job.ResultItems)
+		if job.OK {
+			fmt.Printf("确认无误后执行：accounts apply -plan %s\n", started.JobID)
+		}
+	} else {
+		printStatePasswords(job.ResultItems)
+	}
+	if job.ResultFile != "" {
+		fmt.Println("结果文件：" + job.ResultFile)
+	}
+	if !job.OK {
+		fmt.Fprintln(os.Stderr, job.Error)
+		return 1
+	}
+	return 0
+}
+
+type accountsClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func (c accountsClient) call(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求服务失败：%s", err.Error())
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败：%s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr apiError
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("服务返回 %d", resp.StatusCode)
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// follow polls the job until it is done, printing log lines as they arrive.
+func (c accountsClient) follow(jobID string) (asyncOperateJobView, error) {
+	printed := 0
+	for {
+		var job asyncOperateJobView
+		if err := c.call(http.MethodGet, "/api/projects/operate-async/"+jobID, nil, &job); err != nil {
+			return job, err
+		}
+		for ; printed < len(job.LogLines); printed++ {
+			fmt.Println(job.LogLines[printed])
+		}
+		if job.Done {
+			return job, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func printStatePlan(items []interface{}) {
+	if len(items) == 0 {
+		fmt.Println("无需变更")
+		return
+	}
+	for _, raw := range items {
+		data, _ := json.Marshal(raw)
+		var one project.AccountPlanItem
+		if json.Unmarshal(data, &one) != nil {
+			continue
+		}
+		mark := "-"
+		switch one.Op {
+		case project.AccountCreate:
+			mark = "+"
+		case project.AccountUpdate:
+			mark = "~"
+		}
+		line := fmt.Sprintf("%s %s %s（%s）", mark, project.WorkflowSystemTitle(one.System), one.Username, one.OpText)
+		if text := project.AccountChangesText(one.Changes); text != "" {
+			line += "：" + text
+		}
+		if !one.Actionable {
+			line += " [仅报告]"
+		}
+		if one.Note != "" {
+			line += " " + one.Note
+		}
+		fmt.Println(line)
+	}
+}
+
+// printStatePasswords shows the passwords of accounts the apply created. The
+// server hands them out only once and keeps them out of the result file.
+func printStatePasswords(items []interface{}) {
+	for _, raw := range items {
+		one, _ := raw.(map[string]interface{})
+		password, _ := one["password"].(string)
+		if password == "" {
+			continue
+		}
+		system, _ := one["system"].(string)
+		fmt.Printf("%s %v 初始密码：%s\n", project.WorkflowSystemTitle(system), one["username"], password)
+	}
+}
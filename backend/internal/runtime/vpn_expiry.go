@@ -0,0 +1,265 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VPN account expiry: actions that set expire_date are recorded in
+// vpn_account_expiry under the admin who ran them. A sweeper reminds before
+// the date and disables the account once the day has passed, using that
+// admin's VPN credential. Every automatic step is written to operation_logs
+// as user "system".
+
+const vpnExpiryDayLayout = "2006-01-02"
+
+// vpnExpirySweepToken prefixes the session the sweeper opens for each admin.
+// It never borrows an admin's interactive session.
+const vpnExpirySweepToken = "system:vpn-expiry"
+
+type vpnExpiryRow struct {
+	VPNUser    string `json:"vpn_user"`
+	ExpiresOn  string `json:"expires_on"`
+	DaysLeft   int    `json:"days_left"`
+	State      string `json:"state"`
+	RemindedAt string `json:"reminded_at"`
+	DisabledAt string `json:"disabled_at"`
+	UpdatedAt  string `json:"updated_at"`
+
+	userID   int64
+	username string
+}
+
+func vpnExpiryDaysLeft(expiresOn string, now time.Time) int {
+	day, err := time.ParseInLocation(vpnExpiryDayLayout, expiresOn, time.Local)
+	if err != nil {
+		return 0
+	}
+	today, _ := time.ParseInLocation(vpnExpiryDayLayout, now.Format(vpnExpiryDayLayout), time.Local)
+	return int(day.Sub(today).Hours() / 24)
+}
+
+// trackVPNExpiry keeps vpn_account_expiry in step with a successful VPN
+// action run by u.
+func (s *server) trackVPNExpiry(u authedUser, action string, res projectResult) {
+	if !res.OK || res.Data == nil {
+		return
+	}
+	switch action {
+	case "add_user", "modify_password", "modify_status", "extend_expiry":
+		name, _ := res.Data["vpn_user"].(string)
+		expire, _ := res.Data["expire_date"].(string)
+		s.recordVPNExpiry(u, name, expire)
+	case "batch_add_users":
+		items, _ := res.Data["items"].([]map[string]interface{})
+		for _, item := range items {
+			name, _ := item["username"].(string)
+			expire, _ := item["expire_date"].(string)
+			if item["ok"] == true {
+				s.recordVPNExpiry(u, name, expire)
+			}
+		}
+	case "delete_users":
+		items, _ := res.Data["items"].([]map[string]interface{})
+		for _, item := range items {
+			name, _ := item["vpn_user"].(string)
+			if item["ok"] == true && name != "" {
+				_, _ = s.db.Exec(`DELETE FROM vpn_account_expiry WHERE vpn_user=?`, name)
+			}
+		}
+	}
+}
+
+// recordVPNExpiry starts or moves the tracked expiry of VPN user name.
+func (s *server) recordVPNExpiry(u authedUser, name, expire string) {
+	if strings.TrimSpace(name) == "" || expire == "" {
+		return
+	}
+	now := nowStr()
+	if _, err := s.db.Exec(`INSERT INTO vpn_account_expiry(vpn_user,user_id,expires_on,reminded_at,disabled_at,created_at,updated_at) VALUES(?,?,?,'','',?,?)
+	ON CONFLICT(vpn_user) DO UPDATE SET user_id=excluded.user_id,expires_on=excluded.expires_on,reminded_at='',disabled_at='',updated_at=excluded.updated_at`,
+		name, u.ID, expire, now, now,
+	); err != nil {
+		log.Printf("record vpn expiry failed: user=%s err=%v", name, err)
+	}
+}
+
+func (s *server) listVPNExpiry(where string, args ...interface{}) ([]vpnExpiryRow, error) {
+	rows, err := s.db.Query(`SELECT e.vpn_user,e.user_id,COALESCE(a.username,''),e.expires_on,e.reminded_at,e.disabled_at,e.updated_at
+		FROM vpn_account_expiry e LEFT JOIN admins a ON a.id=e.user_id `+where+` ORDER BY e.expires_on,e.vpn_user`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	remindDays := int(s.cfg.VPNExpiryRemind.Hours() / 24)
+	items := make([]vpnExpiryRow, 0)
+	for rows.Next() {
+		var one vpnExpiryRow
+		if err = rows.Scan(&one.VPNUser, &one.userID, &one.username, &one.ExpiresOn, &one.RemindedAt, &one.DisabledAt, &one.UpdatedAt); err != nil {
+			return nil, err
+		}
+		one.DaysLeft = vpnExpiryDaysLeft(one.ExpiresOn, now)
+		switch {
+		case one.DisabledAt != "":
+			one.State = "disabled"
+		case one.DaysLeft < 0:
+			one.State = "expired"
+		case one.DaysLeft <= remindDays:
+			one.State = "due_soon"
+		default:
+			one.State = "active"
+		}
+		items = append(items, one)
+	}
+	return items, rows.Err()
+}
+
+func (s *server) handleVPNExpiryList(w http.ResponseWriter, u authedUser) {
+	items, err := s.listVPNExpiry(`WHERE e.user_id=?`, u.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "查询到期账号失败"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+func (s *server) runVPNExpirySweeper() {
+	ticker := time.NewTicker(s.cfg.VPNExpirySweep)
+	defer ticker.Stop()
+	for {
+		s.sweepVPNExpiry(time.Now())
+		<-ticker.C
+	}
+}
+
+// sweepVPNExpiry sends the reminders that are due and disables accounts whose
+// last valid day has passed. Accounts that fail to disable stay pending and
+// are retried on the next sweep.
+func (s *server) sweepVPNExpiry(now time.Time) {
+	horizon := now.Add(s.cfg.VPNExpiryRemind).Format(vpnExpiryDayLayout)
+	items, err := s.listVPNExpiry(`WHERE e.disabled_at='' AND e.expires_on<=?`, horizon)
+	if err != nil {
+		log.Printf("vpn expiry sweep failed: %v", err)
+		return
+	}
+	expired := make(map[int64][]vpnExpiryRow)
+	for _, one := range items {
+		switch {
+		case one.DaysLeft < 0:
+			expired[one.userID] = append(expired[one.userID], one)
+		case one.RemindedAt == "":
+			s.remindVPNExpiry(one)
+		}
+	}
+	for userID, rows := range expired {
+		s.disableExpiredVPNUsers(userID, rows)
+	}
+}
+
+func (s *server) remindVPNExpiry(one vpnExpiryRow) {
+	detail := fmt.Sprintf("VPN 账号 %s 将于 %s 到期（剩余 %d 天），所属管理员：%s", one.VPNUser, one.ExpiresOn, one.DaysLeft, one.username)
+	if err := s.notifyVPNExpiry("vpn_expiry_reminder", one, detail); err != nil {
+		detail += "，提醒推送失败：" + err.Error()
+	}
+	s.logAction(one.userID, "system", "vpn_expiry_reminder", "vpn", detail)
+	_, _ = s.db.Exec(`UPDATE vpn_account_expiry SET reminded_at=?,updated_at=? WHERE vpn_user=?`, nowStr(), nowStr(), one.VPNUser)
+}
+
+// disableExpiredVPNUsers disables rows, all owned by userID, on that admin's
+// VPN credential, on a session of the sweeper's own that is closed again
+// afterwards. Like every other status change the disable is mirrored to the
+// firewall; a row whose firewall account could not be disabled stays pending
+// and is retried on the next sweep.
+func (s *server) disableExpiredVPNUsers(userID int64, rows []vpnExpiryRow) {
+	u := authedUser{ID: userID, Username: rows[0].username, Token: fmt.Sprintf("%s:%d", vpnExpirySweepToken, userID)}
+	entry, _, _, err := s.ensureProjectSession(u, "vpn", false)
+	if err != nil {
+		for _, one := range rows {
+			s.logAction(userID, "system", "vpn_expiry_disable_failed", "vpn", fmt.Sprintf("VPN 账号 %s 已于 %s 到期，自动禁用失败：%s", one.VPNUser, one.ExpiresOn, err.Error()))
+		}
+		return
+	}
+	defer s.projectSessions.clearToken(u.Token)
+
+	for _, one := range rows {
+		params := map[string]interface{}{
+			"vpn_user":        one.VPNUser,
+			"status":          "disabled",
+			"kick_online":     true,
+			"remote_firewall": true,
+		}
+		s.injectFirewallCredential(userID, params)
+		res, err := s.operateWithProjectSession(entry, "modify_status", params)
+		reason := ""
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case !res.OK:
+			reason = res.Error
+			if reason == "" {
+				reason = res.Message
+			}
+		}
+		if reason == "用户不存在" {
+			_, _ = s.db.Exec(`DELETE FROM vpn_account_expiry WHERE vpn_user=?`, one.VPNUser)
+			s.logAction(userID, "system", "vpn_expiry_untracked", "vpn", fmt.Sprintf("VPN 账号 %s 在设备上已不存在，停止跟踪到期日期", one.VPNUser))
+			continue
+		}
+		if reason != "" {
+			s.logAction(userID, "system", "vpn_expiry_disable_failed", "vpn", fmt.Sprintf("VPN 账号 %s 已于 %s 到期，自动禁用失败：%s", one.VPNUser, one.ExpiresOn, reason))
+			continue
+		}
+		// An account that was never mirrored has nothing to disable there.
+		if fwReason, _ := res.Data["remote_error"].(string); fwReason != "" && fwReason != "用户不存在" {
+			s.logAction(userID, "system", "vpn_expiry_disable_failed", "vpn", fmt.Sprintf("VPN 账号 %s 已于 %s 到期，网关已禁用，防火墙同步禁用失败：%s，下次扫描重试", one.VPNUser, one.ExpiresOn, fwReason))
+			continue
+		}
+		_, _ = s.db.Exec(`UPDATE vpn_account_expiry SET disabled_at=?,updated_at=? WHERE vpn_user=?`, nowStr(), nowStr(), one.VPNUser)
+		detail := fmt.Sprintf("VPN 账号 %s 已于 %s 到期，已自动禁用", one.VPNUser, one.ExpiresOn)
+		if res.Data["kick_ok"] == true {
+			detail += "并强制下线"
+		}
+		if res.Data["remote_ok"] == true {
+			detail += "，防火墙已同步禁用"
+		}
+		if err = s.notifyVPNExpiry("vpn_expiry_disabled", one, detail); err != nil {
+			detail += "，通知推送失败：" + err.Error()
+		}
+		s.logAction(userID, "system", "vpn_expiry_disabled", "vpn", detail)
+	}
+}
+
+// notifyVPNExpiry posts an expiry event to VPN_EXPIRY_WEBHOOK_URL, when set.
+func (s *server) notifyVPNExpiry(event string, one vpnExpiryRow, text string) error {
+	url := strings.TrimSpace(s.cfg.VPNExpiryWebhook)
+	if url == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"event":      event,
+		"vpn_user":   one.VPNUser,
+		"expires_on": one.ExpiresOn,
+		"days_left":  one.DaysLeft,
+		"admin":      one.username,
+		"text":       text,
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
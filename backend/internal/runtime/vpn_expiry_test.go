@@ -0,0 +1,23 @@
+package runtime
+
+import "testing"
+
+func TestTrackVPNExpiryRecordsBatchAdds(t *testing.T) {
+	s := newTestServer(t, appConfig{CredentialKey: "test-secret"})
+	testLogin(t, s, "helpdesk")
+	u := authedUser{ID: 1, Username: "helpdesk"}
+	s.trackVPNExpiry(u, "batch_add_users", projectResult{OK: true, Data: map[string]interface{}{
+		"items": []map[string]interface{}{
+			{"username": "newhire", "ok": true, "expire_date": "2099-06-30"},
+			{"username": "noexpiry", "ok": true},
+			{"username": "failed", "ok": false, "expire_date": "2099-06-30"},
+		},
+	}})
+	items, err := s.listVPNExpiry(`WHERE e.user_id=?`, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].VPNUser != "newhire" || items[0].ExpiresOn != "2099-06-30" {
+		t.Fatalf("tracked = %+v, want newhire only", items)
+	}
+}
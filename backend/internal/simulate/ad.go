@@ -0,0 +1,275 @@
+package simulate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ADUser is one account held by the AD fake.
+type ADUser struct {
+	SAMAccountName    string
+	DistinguishedName string
+	DisplayName       string
+	Sn                string
+	GivenName         string
+	Mail              string
+	Description       string
+	MemberOf          []string
+	Password          string
+	Locked            bool
+	MustChangePwd     bool
+	Disabled          bool
+}
+
+// ADServer emulates the AD web API: a session cookie from userlogin/, the
+// GetLeaveUser search and the form endpoints that change accounts.
+type ADServer struct {
+	auth authChecker
+
+	mu       sync.Mutex
+	users    map[string]*ADUser
+	sessions map[string]bool
+	mux      *http.ServeMux
+}
+
+const adCookieName = "sessionid"
+
+func NewADServer(account, password string) *ADServer {
+	s := &ADServer{auth: newAuthChecker(account, password), users: map[string]*ADUser{}, sessions: map[string]bool{}}
+	for _, u := range seedADUsers() {
+		s.users[strings.ToLower(u.SAMAccountName)] = u
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/userlogin/", s.handleLogin)
+	mux.HandleFunc("/api/GetLeaveUser/", s.authed(s.handleSearch))
+	mux.HandleFunc("/addUser/", s.authed(s.handleAddUser))
+	mux.HandleFunc("/resetUserPassword/", s.authed(s.handleResetPassword))
+	mux.HandleFunc("/unLockuser/", s.authed(s.handleUnlock))
+	mux.HandleFunc("/api/ChangeUserMessage/", s.authed(s.handleChangeMessage))
+	mux.HandleFunc("/setRenameObject/", s.authed(s.handleRename))
+	mux.HandleFunc("/delObject/", s.authed(s.handleDelete))
+	s.mux = mux
+	return s
+}
+
+func (s *ADServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Users returns a snapshot of the accounts sorted by sAMAccountName.
+func (s *ADServer) Users() []ADUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ADUser, 0, len(s.users))
+	for _, u := range s.users {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SAMAccountName < out[j].SAMAccountName })
+	return out
+}
+
+func (s *ADServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	if err := s.auth.check(r.PostForm.Get("Username"), r.PostForm.Get("Password")); err != nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": err.Error()})
+		return
+	}
+	sid := randomHex(16)
+	s.mu.Lock()
+	s.sessions[sid] = true
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: adCookieName, Value: sid, Path: "/"})
+	// The real API answers a successful login with code 4.
+	writeJSON(w, map[string]interface{}{"code": 4, "msg": "登录成功"})
+}
+
+func (s *ADServer) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(adCookieName)
+		s.mu.Lock()
+		ok := err == nil && s.sessions[c.Value]
+		s.mu.Unlock()
+		if !ok {
+			http.Redirect(w, r, "/userlogin/", http.StatusFound)
+			return
+		}
+		_ = r.ParseForm()
+		h(w, r)
+	}
+}
+
+func (s *ADServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	needle := strings.ToLower(strings.TrimSpace(r.Form.Get("searchvalue")))
+	s.mu.Lock()
+	rows := make([]map[string]interface{}, 0)
+	for _, u := range s.users {
+		if needle != "" &&
+			!strings.Contains(strings.ToLower(u.SAMAccountName), needle) &&
+			!strings.Contains(strings.ToLower(u.DisplayName), needle) &&
+			!strings.Contains(strings.ToLower(u.Description), needle) &&
+			!strings.Contains(strings.ToLower(u.Mail), needle) {
+			continue
+		}
+		rows = append(rows, adUserRow(u))
+	}
+	s.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool {
+		return fmt.Sprint(rows[i]["sAMAccountName"]) < fmt.Sprint(rows[j]["sAMAccountName"])
+	})
+	writeJSON(w, map[string]interface{}{"message": rows})
+}
+
+func adUserRow(u *ADUser) map[string]interface{} {
+	desc := []string{}
+	if u.Description != "" {
+		desc = append(desc, u.Description)
+	}
+	uac := 512
+	if u.Disabled {
+		uac |= 0x2
+	}
+	return map[string]interface{}{
+		"sAMAccountName":     u.SAMAccountName,
+		"distinguishedName":  u.DistinguishedName,
+		"displayName":        u.DisplayName,
+		"description":        desc,
+		"mail":               u.Mail,
+		"memberOf":           append([]string{}, u.MemberOf...),
+		"userAccountControl": uac,
+	}
+}
+
+func (s *ADServer) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.Form.Get("add_user_sAMAccountName2"))
+	cn := strings.TrimSpace(r.Form.Get("add_user_cn"))
+	parent := strings.TrimSpace(r.Form.Get("add_user_distinguishedName"))
+	if name == "" || cn == "" || parent == "" {
+		writeJSON(w, adFail("添加失败，缺少必填字段"))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[strings.ToLower(name)]; ok {
+		writeJSON(w, adFail(fmt.Sprintf("添加失败，AD用户 %s 已存在！", name)))
+		return
+	}
+	s.users[strings.ToLower(name)] = &ADUser{
+		SAMAccountName:    name,
+		DistinguishedName: fmt.Sprintf("CN=%s,%s", cn, parent),
+		DisplayName:       cn,
+		Sn:                r.Form.Get("add_user_sn"),
+		GivenName:         r.Form.Get("add_user_givenName"),
+		Mail:              r.Form.Get("add_user_mail2"),
+		Description:       r.Form.Get("add_user_description"),
+		MemberOf:          []string{"CN=Domain Users,CN=Users,DC=vdesktop,DC=sunline,DC=cn"},
+		Password:          r.Form.Get("add_user_password"),
+		MustChangePwd:     true,
+	}
+	writeJSON(w, adOK())
+}
+
+func (s *ADServer) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.findByDNLocked(r.Form.Get("distinguishedName"))
+	if u == nil {
+		writeJSON(w, adFail("对象不存在"))
+		return
+	}
+	u.Password = r.Form.Get("newpassword")
+	u.MustChangePwd = r.Form.Get("pwdLastSet") == "true"
+	writeJSON(w, adOK())
+}
+
+func (s *ADServer) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[strings.ToLower(strings.TrimSpace(r.Form.Get("sAMAccountName")))]
+	if u == nil {
+		writeJSON(w, adFail("用户不存在"))
+		return
+	}
+	u.Locked = false
+	writeJSON(w, adOK())
+}
+
+func (s *ADServer) handleChangeMessage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[strings.ToLower(strings.TrimSpace(r.Form.Get("CountName")))]
+	if u == nil {
+		writeJSON(w, adFail("用户不存在"))
+		return
+	}
+	if r.Form.Get("Attributes") != "description" {
+		writeJSON(w, adFail("不支持的属性"))
+		return
+	}
+	u.Description = r.Form.Get("ChangeMessage")
+	writeJSON(w, adOK())
+}
+
+func (s *ADServer) handleRename(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.findByDNLocked(r.Form.Get("distinguishedName"))
+	if u == nil {
+		writeJSON(w, adFail("对象不存在"))
+		return
+	}
+	cn := strings.TrimSpace(r.Form.Get("cn"))
+	if i := strings.Index(u.DistinguishedName, ","); i >= 0 && cn != "" {
+		u.DistinguishedName = "CN=" + cn + u.DistinguishedName[i:]
+	}
+	u.DisplayName = strings.TrimSpace(r.Form.Get("displayName"))
+	u.Sn = r.Form.Get("sn")
+	u.GivenName = r.Form.Get("givenName")
+	writeJSON(w, adOK())
+}
+
+func (s *ADServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.findByDNLocked(r.Form.Get("dn"))
+	if u == nil {
+		writeJSON(w, adFail("对象不存在"))
+		return
+	}
+	delete(s.users, strings.ToLower(u.SAMAccountName))
+	writeJSON(w, adOK())
+}
+
+func (s *ADServer) findByDNLocked(dn string) *ADUser {
+	dn = strings.TrimSpace(dn)
+	for _, u := range s.users {
+		if strings.EqualFold(u.DistinguishedName, dn) {
+			return u
+		}
+	}
+	return nil
+}
+
+func adOK() map[string]interface{} {
+	return map[string]interface{}{"isSuccess": true, "message": "操作成功"}
+}
+
+func adFail(msg string) map[string]interface{} {
+	return map[string]interface{}{"isSuccess": false, "message": msg}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
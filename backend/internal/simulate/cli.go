@@ -0,0 +1,653 @@
+package simulate
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// CLIUser is one local user of the gateway / firewall fake.
+type CLIUser struct {
+	Name        string
+	Group       string
+	Password    string
+	Description string
+	Mail        string
+	Invalid     bool
+	// ExpireDate is the expire-date the console set, if any; the fake only
+	// stores it.
+	ExpireDate string
+}
+
+// CLISession is one connected VPN client of the gateway fake.
+type CLISession struct {
+	ID        int
+	User      string
+	ClientIP  string
+	LoginTime time.Time
+	BytesIn   int64
+	BytesOut  int64
+}
+
+// Device error codes the fake reports; the backend keys on them.
+const (
+	cliErrUserNotExist   = "-24501"
+	cliErrUserExists     = "-24502"
+	cliErrSamePassword   = "-24316"
+	cliErrPasswordShort  = "-23204"
+	cliErrGroupNotExist  = "-24601"
+	cliErrInvalidCommand = "-20001"
+	cliErrNotOnline      = "-24801"
+)
+
+// cliPageLines is how many lines the fake prints before "--More--".
+const cliPageLines = 20
+
+// CLIServer is an SSH server emulating the "aaaa user" CLI of the VPN
+// gateway (the firewall speaks the same dialect): an interactive shell with a
+// prompt, command echo, --More-- paging, y/n confirmation on delete and
+// "-24501"-style error codes. It also keeps a table of online sessions for
+// the "aaaa online-user" commands.
+type CLIServer struct {
+	hostname string
+	auth     authChecker
+	config   *ssh.ServerConfig
+
+	mu          sync.Mutex
+	users       map[string]*CLIUser
+	groups      []string
+	sessions    []*CLISession
+	nextSession int
+	ln          net.Listener
+	conns       map[net.Conn]struct{}
+}
+
+var hostSigner ssh.Signer
+
+func init() {
+	// A fixed seed keeps the fingerprint stable across restarts, so a trusted
+	// simulator key stays trusted.
+	seed := sha256.Sum256([]byte("ops-admin simulate host key"))
+	signer, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed[:]))
+	if err != nil {
+		panic(err)
+	}
+	hostSigner = signer
+}
+
+// HostKey returns the type and SHA256 fingerprint of the key every CLI fake
+// presents.
+func HostKey() (string, string) {
+	key := hostSigner.PublicKey()
+	return key.Type(), ssh.FingerprintSHA256(key)
+}
+
+func NewCLIServer(hostname, account, password string, users []*CLIUser) *CLIServer {
+	s := &CLIServer{
+		hostname: hostname,
+		auth:     newAuthChecker(account, password),
+		users:    map[string]*CLIUser{},
+		groups:   seedCLIGroups(),
+		conns:    map[net.Conn]struct{}{},
+		// Session numbers start high enough to look like a busy device.
+		nextSession: 1000,
+	}
+	for _, u := range users {
+		s.users[strings.ToLower(u.Name)] = u
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pwd []byte) (*ssh.Permissions, error) {
+			return nil, s.auth.check(c.User(), string(pwd))
+		},
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge(c.User(), "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 {
+				return nil, errLoginRejected
+			}
+			return nil, s.auth.check(c.User(), answers[0])
+		},
+		// Any key is accepted for a known account; the fake has no
+		// authorized_keys of its own.
+		PublicKeyCallback: func(c ssh.ConnMetadata, _ ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.auth.account != "" && c.User() != s.auth.account {
+				return nil, errLoginRejected
+			}
+			return nil, nil
+		},
+		ServerVersion: "SSH-2.0-" + hostname,
+	}
+	cfg.AddHostKey(hostSigner)
+	s.config = cfg
+	return s
+}
+
+// Listen starts accepting connections on addr and returns the bound host and
+// port.
+func (s *CLIServer) Listen(addr string) (string, int, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", 0, err
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn)
+		}
+	}()
+	host, portText, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.Atoi(portText)
+	return host, port, nil
+}
+
+// Close stops the listener and drops every open connection.
+func (s *CLIServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	return err
+}
+
+// Users returns a snapshot of the local users sorted by name.
+func (s *CLIServer) Users() []CLIUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]CLIUser, 0, len(s.users))
+	for _, u := range s.users {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// AddSession records a client of user connected from clientIP and returns
+// its session number. Deleting or disabling the user does not end it; only a
+// kick does, as on the real gateway.
+func (s *CLIServer) AddSession(user, clientIP string, loginTime time.Time, bytesIn, bytesOut int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextSession++
+	s.sessions = append(s.sessions, &CLISession{ID: s.nextSession, User: user, ClientIP: clientIP, LoginTime: loginTime, BytesIn: bytesIn, BytesOut: bytesOut})
+	return s.nextSession
+}
+
+// Sessions returns a snapshot of the online sessions in login order.
+func (s *CLIServer) Sessions() []CLISession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]CLISession, 0, len(s.sessions))
+	for _, one := range s.sessions {
+		out = append(out, *one)
+	}
+	return out
+}
+
+func (s *CLIServer) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(ch, chReqs)
+	}
+}
+
+func (s *CLIServer) serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	started := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req", "window-change", "env":
+			_ = req.Reply(true, nil)
+		case "shell":
+			if started {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			started = true
+			_ = req.Reply(true, nil)
+			go func() {
+				s.runShell(ch)
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				_ = ch.Close()
+			}()
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// cliTerm is the server side of one interactive shell: it echoes input and
+// hands out complete lines or single keys. Input is taken as UTF-8.
+type cliTerm struct {
+	rw     io.ReadWriter
+	buf    []byte
+	lastCR bool
+}
+
+// write sends s in GB18030, the encoding the real devices use.
+func (t *cliTerm) write(s string) error {
+	out, _, err := transform.String(simplifiedchinese.GB18030.NewEncoder(), s)
+	if err != nil {
+		out = s
+	}
+	_, err = io.WriteString(t.rw, out)
+	return err
+}
+
+func (t *cliTerm) fill() error {
+	b := make([]byte, 256)
+	n, err := t.rw.Read(b)
+	t.buf = append(t.buf, b[:n]...)
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// readLine returns the next line and echoes it once complete; "\r\n"
+// counts as one break.
+func (t *cliTerm) readLine() (string, error) {
+	var line []byte
+	for {
+		for len(t.buf) > 0 {
+			c := t.buf[0]
+			t.buf = t.buf[1:]
+			if c == '\n' && t.lastCR {
+				t.lastCR = false
+				continue
+			}
+			t.lastCR = c == '\r'
+			if c == '\r' || c == '\n' {
+				return string(line), t.write(string(line) + "\r\n")
+			}
+			line = append(line, c)
+		}
+		if err := t.fill(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// readKey returns the next key without echo, used by the pager.
+func (t *cliTerm) readKey() (byte, error) {
+	for len(t.buf) == 0 {
+		if err := t.fill(); err != nil {
+			return 0, err
+		}
+	}
+	c := t.buf[0]
+	t.buf = t.buf[1:]
+	return c, nil
+}
+
+// page prints lines, stopping for a key after every cliPageLines lines.
+func (t *cliTerm) page(lines []string) error {
+	for i, line := range lines {
+		if i > 0 && i%cliPageLines == 0 {
+			if err := t.write("--More--"); err != nil {
+				return err
+			}
+			key, err := t.readKey()
+			if err != nil {
+				return err
+			}
+			if err = t.write("\r        \r"); err != nil {
+				return err
+			}
+			if key == 'q' || key == 'Q' {
+				return nil
+			}
+		}
+		if err := t.write(line + "\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *CLIServer) runShell(rw io.ReadWriter) {
+	t := &cliTerm{rw: rw}
+	prompt := s.hostname + "# "
+	if t.write(fmt.Sprintf("Welcome to %s (simulator)\r\n\r\n%s", s.hostname, prompt)) != nil {
+		return
+	}
+	for {
+		line, err := t.readLine()
+		if err != nil {
+			return
+		}
+		words, err := cliSplit(line)
+		switch {
+		case err != nil:
+			err = t.page([]string{cliError(cliErrInvalidCommand, err.Error())})
+		case len(words) == 0:
+		case words[0] == "exit" || words[0] == "quit":
+			return
+		default:
+			err = s.execute(t, words)
+		}
+		if err != nil || t.write(prompt) != nil {
+			return
+		}
+	}
+}
+
+// cliSplit splits a command line on whitespace; single quotes group words and
+// there is no escape character.
+func cliSplit(line string) ([]string, error) {
+	words := make([]string, 0, 8)
+	var cur strings.Builder
+	inWord, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+func cliError(code, msg string) string {
+	return fmt.Sprintf("%% Error(%s): %s", code, msg)
+}
+
+// cliArgs reads "key value" pairs following the command words.
+func cliArgs(words []string) (map[string]string, bool) {
+	if len(words)%2 != 0 {
+		return nil, false
+	}
+	args := make(map[string]string, len(words)/2)
+	for i := 0; i < len(words); i += 2 {
+		args[words[i]] = words[i+1]
+	}
+	return args, true
+}
+
+func (s *CLIServer) execute(t *cliTerm, words []string) error {
+	cmd := strings.Join(words[:min(len(words), 4)], " ")
+	if cmd == "aaaa user group show" {
+		return t.page(s.groupLines())
+	}
+	if len(words) >= 3 && words[0] == "aaaa" && words[1] == "online-user" {
+		args, ok := cliArgs(words[3:])
+		if !ok {
+			return t.page([]string{cliError(cliErrInvalidCommand, "incomplete command")})
+		}
+		switch words[2] {
+		case "show":
+			return t.page(s.onlineLines(args))
+		case "kick":
+			return t.page([]string{s.kick(args)})
+		}
+		return t.page([]string{cliError(cliErrInvalidCommand, "unknown command")})
+	}
+	if len(words) < 4 || !strings.HasPrefix(cmd, "aaaa user user ") {
+		return t.page([]string{cliError(cliErrInvalidCommand, "unknown command")})
+	}
+	args, ok := cliArgs(words[4:])
+	if !ok {
+		return t.page([]string{cliError(cliErrInvalidCommand, "incomplete command")})
+	}
+	switch words[3] {
+	case "add":
+		return t.page([]string{s.addUser(args)})
+	case "search":
+		return t.page(s.search(args))
+	case "modify-info":
+		return t.page([]string{s.modifyUser(args)})
+	case "delete":
+		return s.deleteUser(t, args)
+	default:
+		return t.page([]string{cliError(cliErrInvalidCommand, "unknown command")})
+	}
+}
+
+func (s *CLIServer) groupLines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := []string{"Group list:"}
+	for _, g := range s.groups {
+		name, parent, _ := strings.Cut(g, "^")
+		lines = append(lines, fmt.Sprintf("  name %s parent-group %s", name, parent))
+	}
+	return lines
+}
+
+func (s *CLIServer) hasGroupLocked(group string) bool {
+	for _, g := range s.groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CLIServer) addUser(args map[string]string) string {
+	name, group, passwd := args["name"], args["group"], args["passwd"]
+	if name == "" || group == "" || passwd == "" {
+		return cliError(cliErrInvalidCommand, "incomplete command")
+	}
+	if len(passwd) < 8 {
+		return cliError(cliErrPasswordShort, "password length must be at least 8")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.hasGroupLocked(group) {
+		return cliError(cliErrGroupNotExist, "group not exist")
+	}
+	if _, ok := s.users[strings.ToLower(name)]; ok {
+		return cliError(cliErrUserExists, "user already exist")
+	}
+	s.users[strings.ToLower(name)] = &CLIUser{
+		Name:        name,
+		Group:       group,
+		Password:    passwd,
+		Description: args["description"],
+		Mail:        args["mail"],
+		Invalid:     args["invalid"] == "yes",
+		ExpireDate:  args["expire-date"],
+	}
+	return "OK"
+}
+
+func (s *CLIServer) search(args map[string]string) []string {
+	key, value := args["key-word"], strings.ToLower(args["key-value"])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]*CLIUser, 0)
+	for _, u := range s.users {
+		field := map[string]string{"name": u.Name, "mail": u.Mail, "description": u.Description}[key]
+		if value != "" && !strings.Contains(strings.ToLower(field), value) {
+			continue
+		}
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	lines := []string{fmt.Sprintf("Total: %d", len(users))}
+	for _, u := range users {
+		invalid := "no"
+		if u.Invalid {
+			invalid = "yes"
+		}
+		lines = append(lines, fmt.Sprintf("  name %s invalid %s description %s group %s mail %s", u.Name, invalid, u.Description, u.Group, u.Mail))
+	}
+	return lines
+}
+
+func (s *CLIServer) modifyUser(args map[string]string) string {
+	if args["index-key"] != "name" || args["index-value"] == "" {
+		return cliError(cliErrInvalidCommand, "incomplete command")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[strings.ToLower(args["index-value"])]
+	if u == nil {
+		return cliError(cliErrUserNotExist, "user not exist")
+	}
+	if pwd, ok := args["passwd"]; ok {
+		if len(pwd) < 8 {
+			return cliError(cliErrPasswordShort, "password length must be at least 8")
+		}
+		if pwd == u.Password {
+			return cliError(cliErrSamePassword, "new password is the same as the old one")
+		}
+	}
+	if group, ok := args["group"]; ok && !s.hasGroupLocked(group) {
+		return cliError(cliErrGroupNotExist, "group not exist")
+	}
+	if pwd, ok := args["passwd"]; ok {
+		u.Password = pwd
+	}
+	if invalid, ok := args["invalid"]; ok {
+		u.Invalid = invalid == "yes"
+	}
+	if desc, ok := args["description"]; ok {
+		u.Description = desc
+	}
+	if mail, ok := args["mail"]; ok {
+		u.Mail = mail
+	}
+	if group, ok := args["group"]; ok {
+		u.Group = group
+	}
+	if expire, ok := args["expire-date"]; ok {
+		u.ExpireDate = expire
+	}
+	return "OK"
+}
+
+func (s *CLIServer) deleteUser(t *cliTerm, args map[string]string) error {
+	name := args["index-value"]
+	if args["index-key"] != "name" || name == "" {
+		return t.page([]string{cliError(cliErrInvalidCommand, "incomplete command")})
+	}
+	s.mu.Lock()
+	_, ok := s.users[strings.ToLower(name)]
+	s.mu.Unlock()
+	if !ok {
+		return t.page([]string{cliError(cliErrUserNotExist, "user not exist")})
+	}
+	if err := t.write(fmt.Sprintf("Are you sure to delete user %s? (y/n):", name)); err != nil {
+		return err
+	}
+	answer, err := t.readLine()
+	if err != nil {
+		return err
+	}
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return t.page([]string{"Canceled"})
+	}
+	s.mu.Lock()
+	delete(s.users, strings.ToLower(name))
+	s.mu.Unlock()
+	return t.page([]string{"OK"})
+}
+
+func (s *CLIServer) onlineLines(args map[string]string) []string {
+	name := ""
+	if args["index-key"] == "name" {
+		name = strings.ToLower(args["index-value"])
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, 0, len(s.sessions)+1)
+	for _, one := range s.sessions {
+		if name != "" && strings.ToLower(one.User) != name {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  session-id %d name %s client-ip %s login-time %s bytes-in %d bytes-out %d",
+			one.ID, one.User, one.ClientIP, one.LoginTime.Format("2006-01-02 15:04:05"), one.BytesIn, one.BytesOut))
+	}
+	return append([]string{fmt.Sprintf("Total: %d", len(lines))}, lines...)
+}
+
+func (s *CLIServer) kick(args map[string]string) string {
+	key, value := args["index-key"], args["index-value"]
+	if value == "" || (key != "name" && key != "session-id") {
+		return cliError(cliErrInvalidCommand, "incomplete command")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.sessions[:0]
+	kicked := 0
+	for _, one := range s.sessions {
+		hit := strconv.Itoa(one.ID) == value
+		if key == "name" {
+			hit = strings.EqualFold(one.User, value)
+		}
+		if hit {
+			kicked++
+			continue
+		}
+		kept = append(kept, one)
+	}
+	s.sessions = kept
+	if kicked == 0 {
+		return cliError(cliErrNotOnline, "user not online")
+	}
+	return fmt.Sprintf("OK, %d session(s) kicked", kicked)
+}
@@ -0,0 +1,415 @@
+package simulate
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PrintDept is one department of the print fake; Path is derived from the
+// parent chain the same way the print server reports dept.name.
+type PrintDept struct {
+	ID       string
+	Name     string
+	ParentID string
+}
+
+// PrintUser is one account held by the print fake.
+type PrintUser struct {
+	ID       string
+	Name     string
+	Fullname string
+	Sex      string
+	Email    string
+	Status   string
+	DeptID   string
+	RoleIDs  []string
+	Password string
+	// Extra holds the save fields the fake only stores and echoes back
+	// (defaultlang, docsecuritylevel, isauditor, userAuthStr).
+	Extra map[string]string
+}
+
+var printExtraFields = []string{"defaultlang", "docsecuritylevel", "isauditor", "userAuthStr"}
+
+// printRoles mirrors the role table of the real server.
+var printRoles = map[string]string{
+	"13a8c61c6888a4c":     "彩色权限",
+	"36b238261872cd10208": "报表",
+	"7d9bfe7cd65a29":      "管理员",
+	"12483a1e79473e4":     "黑白权限",
+}
+
+// PrintServer emulates the print server's web API: the two-step CSRF/AES
+// login and the api/right/dept and api/right/user endpoints. Every call after
+// login must carry a csrftoken encrypted from the session's token.
+type PrintServer struct {
+	block     cipher.Block
+	bootstrap string
+	auth      authChecker
+
+	mu       sync.Mutex
+	sessions map[string]*printSession
+	depts    []PrintDept
+	users    map[string]*PrintUser
+	nextID   int
+	mux      *http.ServeMux
+}
+
+type printSession struct {
+	csrf     string
+	loggedIn bool
+}
+
+const printCookieName = "JSESSIONID"
+
+func NewPrintServer(aesKey, bootstrapCSRF, account, password string) (*PrintServer, error) {
+	block, err := aes.NewCipher([]byte(aesKey))
+	if err != nil {
+		return nil, fmt.Errorf("print simulator: %w", err)
+	}
+	s := &PrintServer{
+		block:     block,
+		bootstrap: strings.TrimSpace(bootstrapCSRF),
+		auth:      newAuthChecker(account, password),
+		sessions:  map[string]*printSession{},
+		depts:     seedPrintDepts(),
+		users:     map[string]*PrintUser{},
+		nextID:    1000,
+	}
+	for _, u := range seedPrintUsers() {
+		s.users[u.ID] = u
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/printhub/isEnableVerifyCode", s.handlePreLogin)
+	mux.HandleFunc("/printhub/login", s.handleLogin)
+	mux.HandleFunc("/printhub/api/right/dept/queryTable", s.authed(s.handleDepts))
+	mux.HandleFunc("/printhub/api/right/user/query", s.authed(s.handleQuery))
+	mux.HandleFunc("/printhub/api/right/user/save", s.authed(s.handleSave))
+	mux.HandleFunc("/printhub/api/right/user/setDefPwd", s.authed(s.handleSetPassword))
+	mux.HandleFunc("/printhub/api/right/user/delete", s.authed(s.handleDelete))
+	s.mux = mux
+	return s, nil
+}
+
+func (s *PrintServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ExpireSessions drops every login, so the next call reports a timeout the
+// way the real server does after its idle limit.
+func (s *PrintServer) ExpireSessions() {
+	s.mu.Lock()
+	s.sessions = map[string]*printSession{}
+	s.mu.Unlock()
+}
+
+// Users returns a snapshot of the accounts sorted by name.
+func (s *PrintServer) Users() []PrintUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]PrintUser, 0, len(s.users))
+	for _, u := range s.users {
+		one := *u
+		one.RoleIDs = append([]string(nil), u.RoleIDs...)
+		out = append(out, one)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *PrintServer) handlePreLogin(w http.ResponseWriter, r *http.Request) {
+	if s.bootstrap == "" || !s.checkToken(r.URL.Query().Get("csrftoken"), s.bootstrap) {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "csrf token invalid"})
+		return
+	}
+	sid := randomHex(16)
+	sess := &printSession{csrf: randomUUID()}
+	s.mu.Lock()
+	s.sessions[sid] = sess
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: printCookieName, Value: sid, Path: "/"})
+	w.Header().Set("csrftoken", sess.csrf)
+	writeJSON(w, map[string]interface{}{"code": 0, "data": false})
+}
+
+func (s *PrintServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	sess := s.session(r)
+	if sess == nil || !s.checkToken(r.PostForm.Get("csrftoken"), sess.csrf) {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "csrf token invalid"})
+		return
+	}
+	name, err1 := s.decrypt(r.PostForm.Get("name"))
+	pwd, err2 := s.decrypt(r.PostForm.Get("pwd"))
+	if err1 != nil || err2 != nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "参数解密失败"})
+		return
+	}
+	if err := s.auth.check(name, pwd); err != nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	sess.loggedIn = true
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"code": 0, "msg": "success"})
+}
+
+func (s *PrintServer) session(r *http.Request) *printSession {
+	c, err := r.Cookie(printCookieName)
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[c.Value]
+}
+
+func (s *PrintServer) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		sess := s.session(r)
+		if sess == nil || !sess.loggedIn || !s.checkToken(r.PostForm.Get("csrftoken"), sess.csrf) {
+			writeJSON(w, map[string]interface{}{"code": 1, "msg": "登录超时，请重新登录"})
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+// checkToken accepts a once-token: url-escaped base64 of AES-ECB("csrf_ms").
+func (s *PrintServer) checkToken(token, csrf string) bool {
+	raw, err := url.QueryUnescape(token)
+	if err != nil {
+		return false
+	}
+	plain, err := s.decrypt(raw)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(plain, csrf+"_")
+}
+
+func (s *PrintServer) decrypt(text string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	bs := s.block.BlockSize()
+	if err != nil || len(data) == 0 || len(data)%bs != 0 {
+		return "", errors.New("cipher text invalid")
+	}
+	out := make([]byte, len(data))
+	for i := 0; i < len(data); i += bs {
+		s.block.Decrypt(out[i:i+bs], data[i:i+bs])
+	}
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > bs || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return "", errors.New("cipher padding invalid")
+	}
+	return string(out[:len(out)-pad]), nil
+}
+
+func (s *PrintServer) handleDepts(w http.ResponseWriter, r *http.Request) {
+	rows := make([]map[string]interface{}, 0, len(s.depts))
+	for _, d := range s.depts {
+		rows = append(rows, map[string]interface{}{"id": d.ID, "name": d.Name, "parentId": d.ParentID})
+	}
+	page, size := formPage(r.PostForm, "page", "pagesize")
+	writeJSON(w, map[string]interface{}{"code": 0, "data": pageOf(rows, page, size), "total": len(rows)})
+}
+
+func (s *PrintServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	field, value := "", ""
+	for _, key := range []string{"username", "fullname", "email"} {
+		if v := strings.TrimSpace(r.PostForm.Get(key)); v != "" {
+			field, value = key, strings.ToLower(v)
+			break
+		}
+	}
+	users := make([]*PrintUser, 0, len(s.users))
+	for _, u := range s.users {
+		target := map[string]string{"username": u.Name, "fullname": u.Fullname, "email": u.Email}[field]
+		if field != "" && !strings.Contains(strings.ToLower(target), value) {
+			continue
+		}
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	rows := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, s.userRowLocked(u))
+	}
+	page, size := formPage(r.PostForm, "pageNum", "pageSizeNum")
+	writeJSON(w, map[string]interface{}{"code": 0, "data": pageOf(rows, page, size), "total": len(rows)})
+}
+
+func (s *PrintServer) userRowLocked(u *PrintUser) map[string]interface{} {
+	names := make([]string, 0, len(u.RoleIDs))
+	for _, id := range u.RoleIDs {
+		if name := printRoles[id]; name != "" {
+			names = append(names, name)
+		}
+	}
+	row := map[string]interface{}{
+		"id":        u.ID,
+		"name":      u.Name,
+		"fullname":  u.Fullname,
+		"email":     u.Email,
+		"sex":       map[string]interface{}{"value": u.Sex},
+		"status":    map[string]interface{}{"value": u.Status},
+		"dept.id":   u.DeptID,
+		"dept.name": s.deptPathLocked(u.DeptID),
+		"roleIds":   strings.Join(u.RoleIDs, ","),
+		"roleNames": strings.Join(names, "|"),
+	}
+	for key, v := range u.Extra {
+		row[key] = v
+	}
+	return row
+}
+
+func (s *PrintServer) deptPathLocked(id string) string {
+	parts := make([]string, 0, 4)
+	for depth := 0; id != "" && depth < 16; depth++ {
+		found := false
+		for _, d := range s.depts {
+			if d.ID == id {
+				parts = append([]string{d.Name}, parts...)
+				id, found = d.ParentID, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return strings.Join(parts, `\`)
+}
+
+func (s *PrintServer) deptExistsLocked(id string) bool {
+	for _, d := range s.depts {
+		if d.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PrintServer) handleSave(w http.ResponseWriter, r *http.Request) {
+	f := r.PostForm
+	name, err1 := s.decrypt(f.Get("name"))
+	fullname, err2 := s.decrypt(f.Get("fullname"))
+	if err1 != nil || err2 != nil || strings.TrimSpace(name) == "" {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "参数错误"})
+		return
+	}
+	if !s.deptExistsLocked(f.Get("dept")) {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "部门不存在"})
+		return
+	}
+	id := strings.TrimSpace(f.Get("id"))
+	for _, u := range s.users {
+		if u.ID != id && strings.EqualFold(u.Name, name) {
+			writeJSON(w, map[string]interface{}{"code": 1, "msg": "用户名已存在"})
+			return
+		}
+	}
+	u := s.users[id]
+	if id == "" {
+		pwd, err := s.decrypt(f.Get("pwd"))
+		if err != nil {
+			writeJSON(w, map[string]interface{}{"code": 1, "msg": "密码解密失败"})
+			return
+		}
+		s.nextID++
+		u = &PrintUser{ID: strconv.Itoa(s.nextID), Password: pwd}
+		s.users[u.ID] = u
+	} else if u == nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "用户不存在"})
+		return
+	}
+	u.Name = name
+	u.Fullname = fullname
+	u.Sex = f.Get("sex")
+	u.Email = f.Get("email")
+	u.Status = f.Get("status")
+	u.DeptID = f.Get("dept")
+	u.RoleIDs = splitIDs(f.Get("roleIds"))
+	u.Extra = map[string]string{}
+	for _, key := range printExtraFields {
+		if v, ok := f[key]; ok {
+			u.Extra[key] = strings.Join(v, ",")
+		}
+	}
+	writeJSON(w, map[string]interface{}{"code": 0, "msg": "success", "data": map[string]interface{}{"id": u.ID}})
+}
+
+func (s *PrintServer) handleSetPassword(w http.ResponseWriter, r *http.Request) {
+	u := s.users[r.PostForm.Get("userId")]
+	if u == nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "用户不存在"})
+		return
+	}
+	pwd, err := s.decrypt(r.PostForm.Get("pwd"))
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "密码解密失败"})
+		return
+	}
+	u.Password = pwd
+	writeJSON(w, map[string]interface{}{"code": 0, "msg": "success"})
+}
+
+func (s *PrintServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PostForm.Get("id")
+	if s.users[id] == nil {
+		writeJSON(w, map[string]interface{}{"code": 1, "msg": "用户不存在"})
+		return
+	}
+	delete(s.users, id)
+	writeJSON(w, map[string]interface{}{"code": 0, "msg": "success"})
+}
+
+func formPage(f url.Values, pageKey, sizeKey string) (int, int) {
+	page, _ := strconv.Atoi(f.Get(pageKey))
+	size, _ := strconv.Atoi(f.Get(sizeKey))
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	return page, size
+}
+
+func pageOf(rows []map[string]interface{}, page, size int) []map[string]interface{} {
+	start := (page - 1) * size
+	if start >= len(rows) {
+		return []map[string]interface{}{}
+	}
+	return rows[start:min(start+size, len(rows))]
+}
+
+func splitIDs(s string) []string {
+	out := make([]string, 0, 2)
+	for _, one := range strings.Split(s, ",") {
+		if one = strings.TrimSpace(one); one != "" {
+			out = append(out, one)
+		}
+	}
+	return out
+}
+
+func randomUUID() string {
+	h := randomHex(16)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
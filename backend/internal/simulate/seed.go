@@ -0,0 +1,92 @@
+package simulate
+
+import (
+	"fmt"
+	"time"
+)
+
+// The seed data describes one small company seen from every system, with a
+// few deliberate gaps (a leaver still on the VPN, a firewall that lags the
+// gateway, an outdated print email) so reports have something to show.
+
+func seedADUsers() []*ADUser {
+	dn := func(cn, ou string) string {
+		return fmt.Sprintf("CN=%s,OU=Users,OU=%s,DC=vdesktop,DC=sunline,DC=cn", cn, ou)
+	}
+	users := []*ADUser{
+		{SAMAccountName: "zhangsan", DisplayName: "张三", Sn: "张", GivenName: "三", Mail: "zhangsan@example.com", Description: "研发工程师", MemberOf: []string{"CN=研发组,OU=Groups,DC=vdesktop,DC=sunline,DC=cn"}, Password: "Passw0rd!", DistinguishedName: dn("张三", "研发部")},
+		{SAMAccountName: "lisi", DisplayName: "李四", Sn: "李", GivenName: "四", Mail: "lisi@example.com", Description: "销售经理", MemberOf: []string{"CN=销售组,OU=Groups,DC=vdesktop,DC=sunline,DC=cn"}, Password: "Passw0rd!", DistinguishedName: dn("李四", "销售部")},
+		{SAMAccountName: "wangwu", DisplayName: "王五", Sn: "王", GivenName: "五", Mail: "wangwu@example.com", Description: "运维工程师", MemberOf: []string{"CN=运维组,OU=Groups,DC=vdesktop,DC=sunline,DC=cn"}, Password: "Passw0rd!", DistinguishedName: dn("王五", "信息技术部")},
+		{SAMAccountName: "qianqi", DisplayName: "钱七", Sn: "钱", GivenName: "七", Mail: "qianqi@example.com", Description: "财务专员", Password: "Passw0rd!", Locked: true, DistinguishedName: dn("钱七", "财务部")},
+	}
+	for _, u := range users {
+		u.MemberOf = append(u.MemberOf, "CN=Domain Users,CN=Users,DC=vdesktop,DC=sunline,DC=cn")
+	}
+	return users
+}
+
+func seedPrintDepts() []PrintDept {
+	return []PrintDept{
+		{ID: "1", Name: "总部"},
+		{ID: "2", Name: "研发部", ParentID: "1"},
+		{ID: "3", Name: "销售部", ParentID: "1"},
+		{ID: "4", Name: "信息技术部", ParentID: "1"},
+		{ID: "5", Name: "财务部", ParentID: "1"},
+	}
+}
+
+func seedPrintUsers() []*PrintUser {
+	return []*PrintUser{
+		{ID: "101", Name: "zhangsan", Fullname: "张三", Sex: "male", Email: "zhangsan@example.com", Status: "enabled", DeptID: "2", RoleIDs: []string{"12483a1e79473e4"}, Password: "123"},
+		{ID: "102", Name: "lisi", Fullname: "李四", Sex: "female", Email: "lisi@old.example.com", Status: "enabled", DeptID: "3", RoleIDs: []string{"12483a1e79473e4", "13a8c61c6888a4c"}, Password: "123"},
+		{ID: "103", Name: "wangwu", Fullname: "王五", Sex: "male", Email: "wangwu@example.com", Status: "disabled", DeptID: "4", RoleIDs: []string{"7d9bfe7cd65a29"}, Password: "123"},
+	}
+}
+
+func seedCLIGroups() []string {
+	return []string{"default^root", "it^root", "dev^it^root", "sales^root", "contractor^root"}
+}
+
+func seedGatewayUsers() []*CLIUser {
+	return append([]*CLIUser{
+		{Name: "zhangsan", Group: "dev^it^root", Password: "Vpn@2024a", Description: "张三", Mail: "zhangsan@example.com"},
+		{Name: "lisi", Group: "sales^root", Password: "Vpn@2024b", Description: "李四", Mail: "lisi@example.com"},
+		{Name: "wangwu", Group: "it^root", Password: "Vpn@2024c", Description: "王五", Mail: "wangwu@example.com"},
+		{Name: "zhaoliu", Group: "contractor^root", Password: "Vpn@2024d", Description: "赵六（已离职）", Mail: "zhaoliu@example.com"},
+	}, seedContractors()...)
+}
+
+func seedContractors() []*CLIUser {
+	users := make([]*CLIUser, 0, 30)
+	// Enough contractor accounts that a full listing spans several pages.
+	for i := 1; i <= 30; i++ {
+		users = append(users, &CLIUser{
+			Name:        fmt.Sprintf("ext%03d", i),
+			Group:       "contractor^root",
+			Password:    fmt.Sprintf("Ext@%04d", i),
+			Description: fmt.Sprintf("外包人员%03d", i),
+			Mail:        fmt.Sprintf("ext%03d@partner.example.com", i),
+			Invalid:     i%7 == 0,
+		})
+	}
+	return users
+}
+
+func seedFirewallUsers() []*CLIUser {
+	return append([]*CLIUser{
+		{Name: "zhangsan", Group: "dev^it^root", Password: "Vpn@2024a", Description: "张三", Mail: "zhangsan@example.com"},
+		{Name: "lisi", Group: "sales^root", Password: "Vpn@2024b", Description: "李四", Mail: "lisi@example.com", Invalid: true},
+		{Name: "zhaoliu", Group: "contractor^root", Password: "Vpn@2024d", Description: "赵六（已离职）", Mail: "zhaoliu@example.com"},
+		{Name: "olduser", Group: "default^root", Password: "Old@2019x", Description: "历史账号", Mail: "olduser@example.com"},
+	}, seedContractors()...)
+}
+
+// seedGatewaySessions connects a few users, including the leaver, so the
+// online list and kicks have something to work on.
+func seedGatewaySessions(s *CLIServer) {
+	now := time.Now()
+	s.AddSession("zhangsan", "10.8.0.12", now.Add(-3*time.Hour), 52428800, 8388608)
+	s.AddSession("zhangsan", "10.8.0.31", now.Add(-25*time.Minute), 1048576, 262144)
+	s.AddSession("zhaoliu", "10.8.0.57", now.Add(-26*time.Hour), 734003200, 94371840)
+	s.AddSession("ext003", "10.8.1.3", now.Add(-40*time.Minute), 2097152, 524288)
+}
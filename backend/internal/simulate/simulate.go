@@ -0,0 +1,136 @@
+// Package simulate runs in-process stand-ins for the systems the console
+// manages: the AD web API, the print server and the VPN gateway / firewall
+// CLI. State lives in memory, so the console can be demoed and exercised
+// end to end without touching a real host.
+package simulate
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Options configures the fakes. PrintAESKey and PrintBootstrapCSRF must match
+// what the backend is configured with. Account/Password is the one login every
+// fake accepts; when Account is empty any non-empty login is accepted.
+type Options struct {
+	PrintAESKey        string
+	PrintBootstrapCSRF string
+	Account            string
+	Password           string
+}
+
+// Env is a running set of fakes and the addresses the backend should use.
+type Env struct {
+	ADURL        string
+	PrintURL     string
+	VPNAddr      string
+	VPNPort      int
+	FirewallAddr string
+	FirewallPort int
+	// HostKeyType and HostKeyFingerprint describe the key both SSH fakes
+	// present, so callers can trust it up front.
+	HostKeyType        string
+	HostKeyFingerprint string
+
+	AD       *ADServer
+	Print    *PrintServer
+	VPN      *CLIServer
+	Firewall *CLIServer
+
+	closeOnce sync.Once
+	closers   []func() error
+}
+
+// Start launches all fakes on loopback ports.
+func Start(opts Options) (*Env, error) {
+	env := &Env{}
+	env.AD = NewADServer(opts.Account, opts.Password)
+	adURL, closeAD, err := serveHTTP(env.AD)
+	if err != nil {
+		return nil, err
+	}
+	env.ADURL = adURL
+	env.closers = append(env.closers, closeAD)
+
+	env.Print, err = NewPrintServer(opts.PrintAESKey, opts.PrintBootstrapCSRF, opts.Account, opts.Password)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	printURL, closePrint, err := serveHTTP(env.Print)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.PrintURL = printURL + "/printhub"
+	env.closers = append(env.closers, closePrint)
+
+	env.VPN = NewCLIServer("SSL-VPN", opts.Account, opts.Password, seedGatewayUsers())
+	seedGatewaySessions(env.VPN)
+	if env.VPNAddr, env.VPNPort, err = env.VPN.Listen("127.0.0.1:0"); err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.closers = append(env.closers, env.VPN.Close)
+
+	env.Firewall = NewCLIServer("FW", opts.Account, opts.Password, seedFirewallUsers())
+	if env.FirewallAddr, env.FirewallPort, err = env.Firewall.Listen("127.0.0.1:0"); err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.closers = append(env.closers, env.Firewall.Close)
+
+	env.HostKeyType, env.HostKeyFingerprint = HostKey()
+	return env, nil
+}
+
+// Close stops every fake that was started.
+func (e *Env) Close() {
+	e.closeOnce.Do(func() {
+		for i := len(e.closers) - 1; i >= 0; i-- {
+			_ = e.closers[i]()
+		}
+	})
+}
+
+// serveHTTP serves h on a loopback port and returns its base URL.
+func serveHTTP(h http.Handler) (string, func() error, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: h}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	return "http://" + ln.Addr().String(), srv.Close, nil
+}
+
+// authChecker decides whether a login is accepted.
+type authChecker struct {
+	account  string
+	password string
+}
+
+func newAuthChecker(account, password string) authChecker {
+	return authChecker{account: strings.TrimSpace(account), password: password}
+}
+
+var errLoginRejected = errors.New("用户名或密码错误")
+
+func (a authChecker) check(account, password string) error {
+	account = strings.TrimSpace(account)
+	if account == "" || password == "" {
+		return errLoginRejected
+	}
+	if a.account == "" {
+		return nil
+	}
+	if account != a.account || password != a.password {
+		return errLoginRejected
+	}
+	return nil
+}
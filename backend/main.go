@@ -1,7 +1,14 @@
 package main
 
-import "ops-admin-backend/internal/runtime"
+import (
+	"os"
+
+	"ops-admin-backend/internal/runtime"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "accounts" {
+		os.Exit(runtime.RunAccountsCLI(os.Args[2:]))
+	}
 	runtime.Run()
 }
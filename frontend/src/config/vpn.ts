@@ -1,38 +1,13 @@
-export const VPN_SECTION_VALUES = [
-  'default^root',
-  '数金总部^root',
-  '数据总部^root',
-  '长亮控股^root',
-  '长亮合度^root',
-  '长亮金服^root',
-  '长亮智能^root',
-  'temp^root',
-  '长亮科技^root',
-  '共享服务中心^长亮科技^root',
-  '集团解决方案部^长亮科技^root',
-  '人力资源中心^长亮科技^root',
-  '信息服务中心^长亮科技^root',
-  '财务中心^长亮科技^root',
-  '运营中心^长亮科技^root',
-  '咨询业务中心^长亮科技^root',
-  '集团产品发展部^长亮科技^root',
-  '干部部^长亮科技^root',
-  '北京运营中心^长亮科技^root',
-  '市场部^长亮科技^root',
-  '董事会^长亮科技^root',
-  '集团总裁办^长亮科技^root',
-  '总裁办公室^长亮科技^root',
-  '研发中心^长亮科技^root',
-  '销售总部^长亮科技^root',
-  '内部审计部^长亮科技^root',
-  '公共关系部^长亮科技^root',
-  '战略规划部^长亮科技^root',
-  '健康督导办公室^长亮科技^root',
-  '税务部^长亮科技^root',
-  '集团项目管理部^长亮科技^root',
-  '研发体系^长亮科技^root',
-  '董事会办公室^长亮科技^root',
-  '战略发展部^长亮科技^root',
-  'Manager^root',
-  '浙农信项目^root',
+// VPN 用户组由后端 list_sections 动作从设备读取，这里只保留默认组。
+export const VPN_DEFAULT_SECTION = 'default^root'
+
+export const VPN_SEARCH_KEY_OPTIONS = [
+  { label: '描述', value: 'description' },
+  { label: '用户名', value: 'name' },
+  { label: '邮箱', value: 'mail' },
+]
+
+export const VPN_MATCH_OPTIONS = [
+  { label: '模糊匹配', value: 'fuzzy' },
+  { label: '精确匹配', value: 'exact' },
 ]
@@ -0,0 +1,66 @@
+// 人员流程按固定顺序（AD、打印、VPN）依次在各系统执行，这里的顺序仅用于展示。
+export const ONBOARD_SYSTEM_OPTIONS = [
+  { label: 'AD', value: 'ad' },
+  { label: '打印', value: 'print' },
+  { label: 'VPN', value: 'vpn' },
+]
+
+export const ONBOARD_SYSTEM_VALUES = ONBOARD_SYSTEM_OPTIONS.map((x) => x.value)
+
+// 离职流程额外覆盖防火墙本地账户，执行顺序为 AD、打印、VPN、防火墙。
+export const OFFBOARD_SYSTEM_OPTIONS = [...ONBOARD_SYSTEM_OPTIONS, { label: '防火墙', value: 'firewall' }]
+
+export const OFFBOARD_SYSTEM_VALUES = OFFBOARD_SYSTEM_OPTIONS.map((x) => x.value)
+
+// 人员查询默认覆盖 AD、打印与 VPN，防火墙可按需勾选。
+export const PERSON_SEARCH_DEFAULT_SYSTEMS = ['ad', 'print', 'vpn']
+
+// 离职策略：AD 接口没有禁用操作，只能删除或保留。
+export const OFFBOARD_POLICY_OPTIONS = [
+  { label: '禁用', value: 'disable' },
+  { label: '删除', value: 'delete' },
+  { label: '保留', value: 'keep' },
+]
+
+export const OFFBOARD_AD_POLICY_OPTIONS = OFFBOARD_POLICY_OPTIONS.filter((x) => x.value !== 'disable')
+
+// 账号对账以 AD 为准，对比打印与 VPN 的全部账号。
+export const RECONCILE_SYSTEM_OPTIONS = ONBOARD_SYSTEM_OPTIONS.filter((x) => x.value !== 'ad')
+
+export const RECONCILE_SYSTEM_VALUES = RECONCILE_SYSTEM_OPTIONS.map((x) => x.value)
+
+export const RECONCILE_ACTION_TITLES: Record<string, string> = {
+  disable: '禁用账号',
+  delete: '删除账号',
+  create: '按AD创建账号',
+  sync_name: '姓名同步为AD',
+  sync_email: '邮箱同步为AD',
+  sync_status: '状态同步为AD',
+}
+
+// 花名册与声明式账号计划项的操作名称；保留与仅报告的项不能批准执行。
+export const ROSTER_OP_TITLES: Record<string, string> = {
+  create: '创建',
+  update: '更新',
+  disable: '禁用',
+  delete: '删除',
+  keep: '保留',
+}
+
+// 表单动作到后端流程名的映射：/api/workflows/{流程名}
+export const WORKFLOW_OF_ACTION: Record<string, string> = {
+  onboard: 'onboard',
+  batch_onboard: 'onboard',
+  offboard: 'offboard',
+  batch_offboard: 'offboard',
+  reconcile: 'reconcile',
+  roster: 'roster',
+  state: 'state',
+}
+
+// 需要逐项确认的流程：先生成结果列表，勾选后提交到 /api/workflows/{流程名}/{执行接口}
+export const WORKFLOW_APPLY_OP: Record<string, string> = {
+  reconcile: 'fix',
+  roster: 'apply',
+  offboard: 'apply',
+}